@@ -0,0 +1,645 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Metrics a rule can watch. Thread metrics are evaluated per Java thread, so a rule fires for every thread breaching it.
+const (
+	// percent of one core
+	ALERT_METRIC_THREAD_CPU        = "thread.cpu"
+	// bytes per second
+	ALERT_METRIC_THREAD_READ_RATE  = "thread.read_rate"
+	ALERT_METRIC_THREAD_WRITE_RATE = "thread.write_rate"
+	ALERT_METRIC_THREAD_IO_RATE    = "thread.io_rate"
+	// in kB
+	ALERT_METRIC_PROCESS_RSS        = "process.rss"
+	ALERT_METRIC_PROCESS_PSS        = "process.pss"
+	// bytes per second
+	ALERT_METRIC_PROCESS_READ_RATE  = "process.read_rate"
+	ALERT_METRIC_PROCESS_WRITE_RATE = "process.write_rate"
+	ALERT_METRIC_PROCESS_THREADS    = "process.threads"
+	// followed by a thread state, e.g. threads.BLOCKED, the number of Java threads in that state
+	ALERT_METRIC_THREADS_PREFIX     = "threads."
+)
+
+var listOfThreadAlertMetrics = []string{ALERT_METRIC_THREAD_CPU, ALERT_METRIC_THREAD_READ_RATE, ALERT_METRIC_THREAD_WRITE_RATE, ALERT_METRIC_THREAD_IO_RATE}
+
+var listOfProcessAlertMetrics = []string{ALERT_METRIC_PROCESS_RSS, ALERT_METRIC_PROCESS_PSS, ALERT_METRIC_PROCESS_READ_RATE, ALERT_METRIC_PROCESS_WRITE_RATE, ALERT_METRIC_PROCESS_THREADS}
+
+const ALERT_SUBJECT_PROCESS = "process"
+
+const (
+	ALERT_STATE_FIRING   = "firing"
+	ALERT_STATE_RESOLVED = "resolved"
+)
+
+// exit code of `ptop watch` when a rule has fired
+const EXIT_CODE_ALERT = 2
+
+var errAlertFired = errors.New("alert fired")
+
+// RuleDuration is a time.Duration read from a string such as "30s" or "5m"
+type RuleDuration time.Duration
+
+func (this *RuleDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30s\"")
+	}
+
+	duration, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*this = RuleDuration(duration)
+	return nil
+}
+
+// AlertRule fires when metric compares to threshold with op for at least For. With Over, the value compared is the growth
+// of a process metric in percent over that window, e.g. {"metric": "process.rss", "over": "5m", "threshold": 10}.
+type AlertRule struct {
+	Name      string       `json:"name"`
+	Metric    string       `json:"metric"`
+	// one of >, >=, <, <=, default >
+	Op        string       `json:"op"`
+	Threshold float64      `json:"threshold"`
+	For       RuleDuration `json:"for"`
+	Over      RuleDuration `json:"over"`
+	// overrides the command of the config
+	Command   string       `json:"command"`
+	// captures a diagnostic bundle when the rule fires
+	Bundle    bool         `json:"bundle"`
+}
+
+func (this *AlertRule) isThreadRule() bool {
+	return strings.HasPrefix(this.Metric, "thread.")
+}
+
+func (this *AlertRule) isStateRule() bool {
+	return strings.HasPrefix(this.Metric, ALERT_METRIC_THREADS_PREFIX)
+}
+
+func (this *AlertRule) breached(value float64) bool {
+	switch this.Op {
+	case ">=":
+		return value >= this.Threshold
+	case "<":
+		return value < this.Threshold
+	case "<=":
+		return value <= this.Threshold
+	default:
+		return value > this.Threshold
+	}
+}
+
+func (this *AlertRule) check() error {
+	if this.Name == "" {
+		return fmt.Errorf("a rule has no name")
+	}
+
+	switch this.Op {
+	case "", ">", ">=", "<", "<=":
+	default:
+		return fmt.Errorf("rule %s: unknown op [%s]", this.Name, this.Op)
+	}
+
+	var known = false
+	for _, state := range jvmdump.ListOfThreadStates {
+		known = known || this.Metric == ALERT_METRIC_THREADS_PREFIX+state
+	}
+	for _, metric := range append(append([]string{}, listOfThreadAlertMetrics...), listOfProcessAlertMetrics...) {
+		known = known || this.Metric == metric
+	}
+	if !known {
+		return fmt.Errorf("rule %s: unknown metric [%s]", this.Name, this.Metric)
+	}
+
+	if this.Over != 0 && (this.isThreadRule() || this.isStateRule()) {
+		return fmt.Errorf("rule %s: over only applies to process metrics", this.Name)
+	}
+
+	return nil
+}
+
+// AlertConfig is the rules file, a JSON document such as
+//
+//	{"command": "notify.sh", "eventLog": "/var/log/ptop-events.log", "rules": [
+//	  {"name": "busy-writer", "metric": "thread.write_rate", "threshold": 52428800, "for": "30s"},
+//	  {"name": "rss-growth", "metric": "process.rss", "over": "5m", "threshold": 10},
+//	  {"name": "contention", "metric": "threads.BLOCKED", "threshold": 20, "bundle": true}],
+//	 "bundle": {"dir": "/var/tmp", "threadDumps": 3, "interval": "5s"}}
+type AlertConfig struct {
+	Rules    []AlertRule   `json:"rules"`
+	// run by sh -c when a rule fires, with PTOP_ALERT_* set in its environment
+	Command  string        `json:"command"`
+	// file the event lines are appended to
+	EventLog string        `json:"eventLog"`
+	// where and what the bundles of the rules are captured
+	Bundle   *BundleConfig `json:"bundle"`
+}
+
+func LoadAlertConfig(path string) (*AlertConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config AlertConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	if len(config.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rule defined", path)
+	}
+	var names = make(map[string]bool)
+	for i := range config.Rules {
+		if names[config.Rules[i].Name] {
+			return nil, fmt.Errorf("%s: rule %s is defined twice", path, config.Rules[i].Name)
+		}
+		names[config.Rules[i].Name] = true
+		if err := config.Rules[i].check(); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+
+	return &config, nil
+}
+
+// AlertEvent is a rule starting or ceasing to be breached, written as one JSON line
+type AlertEvent struct {
+	Time      time.Time `json:"time"`
+	State     string    `json:"state"`
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Pid       int32     `json:"pid"`
+	// "process", or the thread the value was measured on
+	Subject   string    `json:"subject"`
+	Tid       int       `json:"tid,omitempty"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	// since when the rule is breached
+	Since     time.Time `json:"since"`
+}
+
+// alertObservation is the value of a rule for a subject
+type alertObservation struct {
+	subject string
+	tid     int
+	value   float64
+}
+
+type processSample struct {
+	sampledAt time.Time
+	values    map[string]float64
+}
+
+type alertState struct {
+	since  time.Time
+	firing bool
+	last   AlertEvent
+}
+
+// AlertEvaluator evaluates the rules against the successive samples of a process
+type AlertEvaluator struct {
+	config  *AlertConfig
+	pid     int32
+	//by rule name and subject
+	states  map[string]*alertState
+	//process metrics kept as long as the largest growth window
+	samples []processSample
+	window  time.Duration
+}
+
+func NewAlertEvaluator(config *AlertConfig, pid int32) *AlertEvaluator {
+	evaluator := AlertEvaluator{config: config, pid: pid, states: make(map[string]*alertState)}
+	for _, rule := range config.Rules {
+		if time.Duration(rule.Over) > evaluator.window {
+			evaluator.window = time.Duration(rule.Over)
+		}
+	}
+
+	return &evaluator
+}
+
+// Evaluate checks the rules and returns the alerts which fired or resolved. Thread and thread state rules are skipped
+// without history or snapshot, and their alerts are kept as they are, e.g. in the TUI while no process is drilled into.
+func (this *AlertEvaluator) Evaluate(summary *ProcessSummary, history *ProcessHistory, snapshot *correlate.Snapshot) []AlertEvent {
+	now := summary.sampledAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	this.recordSample(now, summary)
+
+	var events []AlertEvent
+	for _, rule := range this.config.Rules {
+		observations, ok := this.observe(&rule, now, summary, history, snapshot)
+		if !ok {
+			continue
+		}
+
+		breached := make(map[string]bool)
+		for _, observation := range observations {
+			if !rule.breached(observation.value) {
+				continue
+			}
+
+			key := rule.Name + "\x00" + observation.subject
+			breached[key] = true
+			state, ok := this.states[key]
+			if !ok {
+				state = &alertState{since: now}
+				this.states[key] = state
+			}
+			state.last = AlertEvent{Time: now, State: ALERT_STATE_FIRING, Rule: rule.Name, Metric: rule.Metric, Pid: this.pid,
+				Subject: observation.subject, Tid: observation.tid, Value: observation.value, Threshold: rule.Threshold, Since: state.since}
+
+			if !state.firing && now.Sub(state.since) >= time.Duration(rule.For) {
+				state.firing = true
+				events = append(events, state.last)
+			}
+		}
+
+		for key, state := range this.states {
+			if !strings.HasPrefix(key, rule.Name+"\x00") || breached[key] {
+				continue
+			}
+			if state.firing {
+				event := state.last
+				event.Time = now
+				event.State = ALERT_STATE_RESOLVED
+				events = append(events, event)
+			}
+			delete(this.states, key)
+		}
+	}
+
+	return events
+}
+
+// Firing returns the alerts currently firing, ordered by rule and subject
+func (this *AlertEvaluator) Firing() []AlertEvent {
+	var firing []AlertEvent
+	for _, state := range this.states {
+		if state.firing {
+			firing = append(firing, state.last)
+		}
+	}
+
+	sort.Slice(firing, func(i, j int) bool {
+		if firing[i].Rule != firing[j].Rule {
+			return firing[i].Rule < firing[j].Rule
+		}
+		return firing[i].Subject < firing[j].Subject
+	})
+
+	return firing
+}
+
+func (this *AlertEvaluator) recordSample(now time.Time, summary *ProcessSummary) {
+	values := map[string]float64{
+		ALERT_METRIC_PROCESS_RSS: float64(summary.Rss),
+		ALERT_METRIC_PROCESS_PSS: float64(summary.Pss),
+		ALERT_METRIC_PROCESS_READ_RATE: summary.ReadRate,
+		ALERT_METRIC_PROCESS_WRITE_RATE: summary.WriteRate,
+		ALERT_METRIC_PROCESS_THREADS: float64(summary.NumThreads),
+	}
+	this.samples = append(this.samples, processSample{sampledAt: now, values: values})
+
+	//keep the latest sample at or before the start of the window, the growth is measured from it
+	for len(this.samples) > 1 && !this.samples[1].sampledAt.After(now.Add(-this.window)) {
+		this.samples = this.samples[1:]
+	}
+}
+
+// observe returns the values of a rule, or false if the rule cannot be evaluated for now
+func (this *AlertEvaluator) observe(rule *AlertRule, now time.Time, summary *ProcessSummary, history *ProcessHistory, snapshot *correlate.Snapshot) ([]alertObservation, bool) {
+	if rule.isThreadRule() {
+		if history == nil || snapshot == nil {
+			return nil, false
+		}
+
+		var observations []alertObservation
+		for _, segment := range *filterJavaThread(snapshot.Segments) {
+			threadHistory, ok := history.Threads[segment.TaskID]
+			if !ok {
+				continue
+			}
+
+			var value float64
+			switch rule.Metric {
+			case ALERT_METRIC_THREAD_CPU:
+				value = threadHistory.Cpu.Last()
+			case ALERT_METRIC_THREAD_READ_RATE:
+				value = threadHistory.ReadRate.Last()
+			case ALERT_METRIC_THREAD_WRITE_RATE:
+				value = threadHistory.WriteRate.Last()
+			case ALERT_METRIC_THREAD_IO_RATE:
+				value = threadHistory.IoRate.Last()
+			}
+			observations = append(observations, alertObservation{subject: fmt.Sprintf("%s (%d)", segment.Path, segment.TaskID), tid: segment.TaskID, value: value})
+		}
+		return observations, true
+	}
+
+	if rule.isStateRule() {
+		if snapshot == nil {
+			return nil, false
+		}
+
+		state := strings.TrimPrefix(rule.Metric, ALERT_METRIC_THREADS_PREFIX)
+		var count = 0
+		for _, jthread := range snapshot.Threads {
+			if jthread.State == state {
+				count++
+			}
+		}
+		return []alertObservation{{subject: ALERT_SUBJECT_PROCESS, value: float64(count)}}, true
+	}
+
+	current := this.samples[len(this.samples)-1].values[rule.Metric]
+	if rule.Over == 0 {
+		return []alertObservation{{subject: ALERT_SUBJECT_PROCESS, value: current}}, true
+	}
+
+	//growth needs a sample from at least a window ago
+	oldest := this.samples[0]
+	if oldest.sampledAt.After(now.Add(-time.Duration(rule.Over))) {
+		return nil, false
+	}
+	for _, sample := range this.samples {
+		if sample.sampledAt.After(now.Add(-time.Duration(rule.Over))) {
+			break
+		}
+		oldest = sample
+	}
+	//no growth in percent from nothing
+	if oldest.values[rule.Metric] == 0 {
+		return nil, false
+	}
+	return []alertObservation{{subject: ALERT_SUBJECT_PROCESS, value: (current - oldest.values[rule.Metric]) / oldest.values[rule.Metric] * 100}}, true
+}
+
+////////////////////////////////////////////////////////////////
+
+// AlertNotifier writes the event lines, runs the commands and captures the bundles of the alerts
+type AlertNotifier struct {
+	config  *AlertConfig
+	writer  io.Writer
+	mutex   sync.Mutex
+	bundles *BundleCapturer
+}
+
+// NewAlertNotifier writes the event lines to the event log of the config if any, to fallback otherwise, which may be nil
+func NewAlertNotifier(config *AlertConfig, fallback io.Writer) (*AlertNotifier, error) {
+	notifier := AlertNotifier{config: config, writer: fallback, bundles: NewBundleCapturer(config.Bundle)}
+	if config.EventLog != "" {
+		file, err := os.OpenFile(config.EventLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		notifier.writer = file
+	}
+
+	return &notifier, nil
+}
+
+func (this *AlertNotifier) Notify(event AlertEvent) {
+	glog.Infof("alert %s %s on %d %s: %g (threshold %g)", event.Rule, event.State, event.Pid, event.Subject, event.Value, event.Threshold)
+
+	if this.writer != nil {
+		line, _ := json.Marshal(event)
+		this.mutex.Lock()
+		this.writer.Write(append(line, '\n'))
+		this.mutex.Unlock()
+	}
+
+	if event.State != ALERT_STATE_FIRING {
+		return
+	}
+
+	command := this.config.Command
+	for _, rule := range this.config.Rules {
+		if rule.Name == event.Rule && rule.Command != "" {
+			command = rule.Command
+		}
+		if rule.Name == event.Rule && rule.Bundle {
+			this.bundles.Trigger(event.Pid, "alert "+event.Rule)
+		}
+	}
+	if command != "" {
+		//the command may be slow, the sampling goes on meanwhile
+		go runAlertCommand(command, event)
+	}
+}
+
+func runAlertCommand(command string, event AlertEvent) {
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"PTOP_ALERT_RULE="+event.Rule,
+		"PTOP_ALERT_METRIC="+event.Metric,
+		"PTOP_ALERT_PID="+strconv.Itoa(int(event.Pid)),
+		"PTOP_ALERT_SUBJECT="+event.Subject,
+		"PTOP_ALERT_TID="+strconv.Itoa(event.Tid),
+		"PTOP_ALERT_VALUE="+strconv.FormatFloat(event.Value, 'g', -1, 64),
+		"PTOP_ALERT_THRESHOLD="+strconv.FormatFloat(event.Threshold, 'g', -1, 64))
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		glog.Errorf("alert command of %s Cause: [%s] %s", event.Rule, err, output)
+	}
+}
+
+func (this *AlertNotifier) Close() error {
+	if closer, ok := this.writer.(io.Closer); ok && this.config.EventLog != "" {
+		return closer.Close()
+	}
+	return nil
+}
+
+////////////////////////////////////////////////////////////////
+
+// runWatch evaluates the rules against a process without the TUI, i.e. `ptop watch -rules <file> <pid>`.
+// It exits with EXIT_CODE_ALERT once a rule has fired.
+func runWatch(args []string) error {
+	flags := flag.NewFlagSet("ptop watch", flag.ContinueOnError)
+	rulesPath := flags.String("rules", "", "rules file")
+	interval := flags.Duration("interval", DEFAULT_PROFILE_INTERVAL_IN_SECOND * time.Second, "time between two evaluations")
+	snapshotInterval := flags.Duration("snapshot-interval", DEFAULT_WEB_SNAPSHOT_INTERVAL_IN_SECOND * time.Second, "time between two thread dumps, which thread rules need")
+	count := flags.Int("count", 0, "number of evaluations, 0 for no limit")
+	exitOnAlert := flags.Bool("exit-on-alert", false, "stop as soon as a rule fires")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *rulesPath == "" {
+		return usageErrorf("a pid and -rules <file> are required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	pid := int32(parsedPid)
+
+	config, err := LoadAlertConfig(*rulesPath)
+	if err != nil {
+		return err
+	}
+	notifier, err := NewAlertNotifier(config, os.Stdout)
+	if err != nil {
+		return err
+	}
+	defer notifier.Close()
+	//a bundle triggered by the last alert is completed before exiting
+	defer notifier.bundles.Wait()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	options := watchOptions{interval: *interval, snapshotInterval: *snapshotInterval, count: *count, exitOnAlert: *exitOnAlert}
+	return watch(&LiveSource{}, pid, config, notifier, options, signals)
+}
+
+// watchOptions are the flags of `ptop watch` which drive its loop
+type watchOptions struct {
+	interval         time.Duration
+	snapshotInterval time.Duration
+	// 0 for no limit
+	count            int
+	exitOnAlert      bool
+}
+
+// watch evaluates the rules against source until count evaluations, the process is gone, a signal, or a rule fired with
+// exitOnAlert. It returns errAlertFired if a rule has fired, however it stopped.
+func watch(source SnapshotSource, pid int32, config *AlertConfig, notifier *AlertNotifier, options watchOptions, signals <-chan os.Signal) error {
+	evaluator := NewAlertEvaluator(config, pid)
+	history := NewProcessHistory()
+	var summary *ProcessSummary
+	var snapshot *correlate.Snapshot
+	var snapshotAt time.Time
+	var fired = false
+
+	ticker := time.NewTicker(options.interval)
+	defer ticker.Stop()
+
+loop:
+	for evaluated := 0; options.count == 0 || evaluated < options.count; evaluated++ {
+		if needsSnapshot(config) && time.Since(snapshotAt) >= options.snapshotInterval {
+			if taken, err := source.GetSnapshot(pid); err == nil {
+				snapshot, snapshotAt = taken, time.Now()
+			} else {
+				glog.Warningf("GetSnapshot(%d) Cause: [%s]", pid, err)
+			}
+		}
+
+		taken, err := source.GetProcessSummary(pid, summary)
+		if err != nil {
+			if _, searchErr := procfs.SearchProcessByPid(pid); searchErr != nil {
+				fmt.Fprintf(os.Stderr, "process %d is gone\n", pid)
+				break
+			}
+			glog.Warningf("GetProcessSummary(%d) Cause: [%s]", pid, err)
+		} else {
+			summary = taken
+			if summary.Rollup != nil {
+				history.RecordRollup(summary.Rollup)
+			}
+			if snapshot != nil {
+				sampleThreads(source, pid, snapshot, history)
+			}
+
+			for _, event := range evaluator.Evaluate(summary, history, snapshot) {
+				notifier.Notify(event)
+				if event.State == ALERT_STATE_FIRING {
+					fired = true
+				}
+			}
+			if fired && options.exitOnAlert {
+				break
+			}
+		}
+
+		if options.count != 0 && evaluated+1 == options.count {
+			break
+		}
+		select {
+		case <-signals:
+			//stopped by the user or a supervisor, what fired so far still decides the exit code
+			break loop
+		case <-ticker.C:
+		}
+	}
+
+	if fired {
+		return errAlertFired
+	}
+	return nil
+}
+
+// needsSnapshot tells whether a rule needs the thread dump, which is not free for the target JVM
+func needsSnapshot(config *AlertConfig) bool {
+	for _, rule := range config.Rules {
+		if rule.isThreadRule() || rule.isStateRule() {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleThreads records the counters of the Java threads of the snapshot
+func sampleThreads(source SnapshotSource, pid int32, snapshot *correlate.Snapshot, history *ProcessHistory) {
+	recordThreadSamples(history, takeThreadSamples(source, pid, snapshot))
+}
+
+// takeThreadSamples reads the counters of the Java threads of the snapshot, by tid
+func takeThreadSamples(source SnapshotSource, pid int32, snapshot *correlate.Snapshot) map[int]*ThreadSample {
+	samples := make(map[int]*ThreadSample)
+	for _, segment := range *filterJavaThread(snapshot.Segments) {
+		sample, err := source.SampleThread(pid, segment.TaskID)
+		if err != nil {
+			glog.V(3).Infof("SampleThread(%d, %d) Cause: [%s]", pid, segment.TaskID, err)
+			continue
+		}
+		samples[segment.TaskID] = sample
+	}
+	return samples
+}
+
+// recordThreadSamples records the samples, the threads which were not sampled are forgotten
+func recordThreadSamples(history *ProcessHistory, samples map[int]*ThreadSample) {
+	liveTids := make(map[int]bool)
+	for tid, sample := range samples {
+		history.RecordThread(tid, sample)
+		liveTids[tid] = true
+	}
+	history.Prune(liveTids)
+}
+
+// describeAlerts is the one-line banner of the TUI
+func describeAlerts(firing []AlertEvent) string {
+	var texts []string
+	for _, event := range firing {
+		texts = append(texts, fmt.Sprintf("%s: %d %s = %s", event.Rule, event.Pid, event.Subject, strconv.FormatFloat(event.Value, 'f', 1, 64)))
+	}
+	return fmt.Sprintf("ALERTS (%d) %s", len(firing), strings.Join(texts, " | "))
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"github.com/shirou/gopsutil/process"
+	"os"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type alertThread struct {
+	tid       int
+	name      string
+	state     string
+	// bytes per second
+	writeRate float64
+}
+
+// alertStep is one evaluation, at some time since the first one, with the events it is expected to return as
+// "<state> <rule> <subject>"
+type alertStep struct {
+	at       time.Duration
+	rss      uint64
+	threads  []alertThread
+	expected []string
+}
+
+func alertSnapshot(listOfThreads []alertThread) *correlate.Snapshot {
+	snapshot := correlate.Snapshot{Threads: make(map[int]jvmdump.JavaThread), Layout: &correlate.JvmMemoryLayout{}}
+
+	var listOfSegments []correlate.TaskMemorySegment
+	for _, thread := range listOfThreads {
+		snapshot.Threads[thread.tid] = jvmdump.JavaThread{Nid: thread.tid, ThreadName: thread.name, State: thread.state}
+
+		segment := correlate.TaskMemorySegment{TaskID: thread.tid}
+		segment.ProcessMemorySegment = smaps.ProcessMemorySegment{MemoryMapsStat: process.MemoryMapsStat{Path: thread.name}, FrameType: "JavaThread"}
+		listOfSegments = append(listOfSegments, segment)
+	}
+	snapshot.Segments = &listOfSegments
+
+	return &snapshot
+}
+
+func describeEvents(events []AlertEvent) []string {
+	var result []string
+	for _, event := range events {
+		result = append(result, fmt.Sprintf("%s %s %s", event.State, event.Rule, event.Subject))
+	}
+	return result
+}
+
+func TestAlertEvaluatorEvaluate(t *testing.T) {
+	worker := func(writeRate float64) []alertThread {
+		return []alertThread{{10, "worker-1", "RUNNABLE", writeRate}, {11, "worker-2", "RUNNABLE", 0}}
+	}
+	blocked := func(count int) []alertThread {
+		var listOfThreads = []alertThread{{10, "main", "RUNNABLE", 0}}
+		for i := 0; i < count; i++ {
+			listOfThreads = append(listOfThreads, alertThread{20 + i, fmt.Sprintf("worker-%d", i), "BLOCKED", 0})
+		}
+		return listOfThreads
+	}
+
+	var tests = []struct {
+		name  string
+		rule  AlertRule
+		steps []alertStep
+	}{
+		{"thread rate for 30s",
+			AlertRule{Name: "busy-writer", Metric: ALERT_METRIC_THREAD_WRITE_RATE, Threshold: 1000, For: RuleDuration(30 * time.Second)},
+			[]alertStep{
+				{0, 0, worker(2000), nil},
+				{20 * time.Second, 0, worker(2000), nil},
+				{30 * time.Second, 0, worker(2000), []string{"firing busy-writer worker-1 (10)"}},
+				{40 * time.Second, 0, worker(3000), nil},
+				{50 * time.Second, 0, worker(10), []string{"resolved busy-writer worker-1 (10)"}},
+				//breached again, the 30s start over
+				{60 * time.Second, 0, worker(2000), nil},
+			}},
+		{"blocked threads",
+			AlertRule{Name: "contention", Metric: ALERT_METRIC_THREADS_PREFIX + "BLOCKED", Threshold: 1},
+			[]alertStep{
+				{0, 0, blocked(1), nil},
+				{10 * time.Second, 0, blocked(2), []string{"firing contention process"}},
+				{20 * time.Second, 0, blocked(3), nil},
+				{30 * time.Second, 0, blocked(0), []string{"resolved contention process"}},
+			}},
+		{"rss growth over 5m",
+			AlertRule{Name: "rss-growth", Metric: ALERT_METRIC_PROCESS_RSS, Threshold: 10, Over: RuleDuration(5 * time.Minute)},
+			[]alertStep{
+				{0, 1000, nil, nil},
+				//no sample from 5 minutes ago yet
+				{2 * time.Minute, 1200, nil, nil},
+				{5 * time.Minute, 1050, nil, nil},
+				{6 * time.Minute, 1200, nil, []string{"firing rss-growth process"}},
+				{12 * time.Minute, 1200, nil, []string{"resolved rss-growth process"}},
+			}},
+	}
+
+	for _, test := range tests {
+		evaluator := NewAlertEvaluator(&AlertConfig{Rules: []AlertRule{test.rule}}, 1)
+		history := NewProcessHistory()
+		start := time.Now()
+
+		for i, step := range test.steps {
+			for _, thread := range step.threads {
+				if _, ok := history.Threads[thread.tid]; !ok {
+					history.Threads[thread.tid] = &ThreadHistory{}
+				}
+				history.Threads[thread.tid].WriteRate.Add(thread.writeRate)
+			}
+
+			summary := ProcessSummary{Pid: 1, Rss: step.rss, sampledAt: start.Add(step.at)}
+			events := describeEvents(evaluator.Evaluate(&summary, history, alertSnapshot(step.threads)))
+			if !reflect.DeepEqual(events, step.expected) {
+				t.Errorf("%s, step %d: events = %v, expected %v", test.name, i, events, step.expected)
+			}
+		}
+	}
+}
+
+func TestAlertEvaluatorGrowth(t *testing.T) {
+	config := AlertConfig{Rules: []AlertRule{
+		{Name: "short", Metric: ALERT_METRIC_PROCESS_READ_RATE, Threshold: 10, Over: RuleDuration(time.Minute)},
+		{Name: "long", Metric: ALERT_METRIC_PROCESS_READ_RATE, Op: "<", Threshold: -10, Over: RuleDuration(10 * time.Minute)},
+	}}
+	evaluator := NewAlertEvaluator(&config, 1)
+
+	//the sample a minute ago is 0, there is no growth in percent from it
+	start := time.Now()
+	var events []AlertEvent
+	for i, rate := range []float64{100, 0, 50} {
+		events = evaluator.Evaluate(&ProcessSummary{ReadRate: rate, sampledAt: start.Add(time.Duration(i) * 5 * time.Minute)}, nil, nil)
+	}
+
+	if len(events) != 1 || events[0].Rule != "long" || events[0].Value != -50 {
+		t.Fatalf("events = %+v, expected long to fire at -50%%", events)
+	}
+	if _, err := json.Marshal(events); err != nil {
+		t.Errorf("events cannot be written: %s", err)
+	}
+}
+
+// testWatchSource hands out process summaries with the given RSS in turn, the last one over and over
+type testWatchSource struct {
+	LiveSource
+	listOfRss []uint64
+	summaries int
+}
+
+func (this *testWatchSource) GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error) {
+	rss := this.listOfRss[len(this.listOfRss)-1]
+	if this.summaries < len(this.listOfRss) {
+		rss = this.listOfRss[this.summaries]
+	}
+	this.summaries++
+	return &ProcessSummary{Pid: pid, Rss: rss}, nil
+}
+
+func TestWatch(t *testing.T) {
+	var tests = []struct {
+		name      string
+		listOfRss []uint64
+		options   watchOptions
+		signaled  bool
+		expected  error
+		summaries int
+	}{
+		{"count reached", []uint64{50}, watchOptions{interval: time.Millisecond, count: 3}, false, nil, 3},
+		{"exit on alert", []uint64{50, 200, 50}, watchOptions{interval: time.Millisecond, exitOnAlert: true}, false, errAlertFired, 2},
+		{"fired then count reached", []uint64{200, 50}, watchOptions{interval: time.Millisecond, count: 2}, false, errAlertFired, 2},
+		{"signal", []uint64{50}, watchOptions{interval: time.Hour}, true, nil, 1},
+		//e.g. a supervisor stopping the watchdog after an alert
+		{"signal after an alert", []uint64{200}, watchOptions{interval: time.Hour}, true, errAlertFired, 1},
+	}
+
+	for _, test := range tests {
+		config := AlertConfig{Rules: []AlertRule{{Name: "rss", Metric: ALERT_METRIC_PROCESS_RSS, Threshold: 100}}}
+		var output bytes.Buffer
+		notifier, err := NewAlertNotifier(&config, &output)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		signals := make(chan os.Signal, 1)
+		if test.signaled {
+			signals <- syscall.SIGTERM
+		}
+		source := testWatchSource{listOfRss: test.listOfRss}
+
+		if err := watch(&source, int32(os.Getpid()), &config, notifier, test.options, signals); err != test.expected {
+			t.Errorf("%s: watch = %v, expected %v", test.name, err, test.expected)
+		}
+		if source.summaries != test.summaries {
+			t.Errorf("%s: %d evaluations, expected %d", test.name, source.summaries, test.summaries)
+		}
+		if fired := strings.Contains(output.String(), `"state":"firing"`); fired != (test.expected == errAlertFired) {
+			t.Errorf("%s: event lines [%s]", test.name, output.String())
+		}
+	}
+}
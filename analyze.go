@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"io/ioutil"
+	"os"
+)
+
+// LoadFrame assembles a frame out of a thread dump and a copy of /proc/<pid>/smaps taken on another machine. Everything
+// else, e.g. the per-thread counters or the JVM diagnostics, is left empty.
+func LoadFrame(threadDumpPath string, smapsPath string) (*correlate.Frame, error) {
+	threadDump, err := ioutil.ReadFile(threadDumpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	smaps, err := ioutil.ReadFile(smapsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfo, err := os.Stat(threadDumpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &correlate.Frame{Timestamp: fileInfo.ModTime(), ThreadDump: string(threadDump), Smaps: string(smaps), Tasks: make(map[int]*correlate.TaskCapture)}, nil
+}
+
+// FrameSource feeds the TUI with a single frame
+type FrameSource struct {
+	pid   int32
+	name  string
+	frame *correlate.Frame
+}
+
+func NewFrameSource(pid int32, name string, frame *correlate.Frame) *FrameSource {
+	return &FrameSource{pid: pid, name: name, frame: frame}
+}
+
+func (this *FrameSource) GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error) {
+	return frameSummary(this.frame, this.pid, this.name, nil)
+}
+
+func (this *FrameSource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	return correlate.BuildSnapshot(this.pid, this.frame)
+}
+
+func (this *FrameSource) GetPerfData(pid int32) (*hsperf.PerfData, error) {
+	if len(this.frame.PerfData) == 0 {
+		return nil, fmt.Errorf("no hsperfdata was given")
+	}
+
+	return hsperf.ParsePerfData(this.frame.PerfData)
+}
+
+func (this *FrameSource) SampleThread(pid int32, tid int) (*ThreadSample, error) {
+	return sampleFrameThread(this.frame, tid)
+}
@@ -1,8 +1,10 @@
-package main
+// Package attach is a client of the HotSpot attach listener, i.e. the unix socket jcmd and jstack talk to.
+package attach
 
 import (
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/procfs"
 	"golang.org/x/sys/unix"
 	"net"
 	"os"
@@ -11,20 +13,37 @@ import (
 	"time"
 )
 
-type JavaThread struct {
-	threadname	 string
+// GetJavaThreadDump asks the target JVM for a thread dump, see jvmdump.ParseThreadDump
+func GetJavaThreadDump(targetPid int32) (string, error) {
+	return ExecuteCommand(targetPid, "threaddump", "", "", "  ")
+}
 
-	nid 		 int
+// ExecuteJcmd runs a diagnostic command, e.g. GC.heap_info, in the target JVM and returns its output
+func ExecuteJcmd(targetPid int32, command string) (string, error) {
+	res, err := ExecuteCommand(targetPid, "jcmd", command, "", "")
+	if err != nil {
+		return "", err
+	}
 
-	tid 		 string
+	//the reply starts with the return code of the command
+	var statusLine, output = res, ""
+	if i := strings.Index(res, "\n"); i >= 0 {
+		statusLine, output = res[:i], res[i+1:]
+	}
+	status, err := strconv.Atoi(strings.TrimSpace(statusLine))
+	if err != nil {
+		return "", fmt.Errorf("malformed reply to jcmd %s: [%s]", command, statusLine)
+	}
+	if status != 0 {
+		return "", fmt.Errorf("jcmd %s failed with status %d: %s", command, status, strings.TrimSpace(output))
+	}
 
-	stackPtr	 uint64
+	return output, nil
 }
 
-const THREAD_REGEX = `\"(?P<threadName>[^\"]+)\".*tid=(?P<tid>0x[0-9a-f]+).*nid=(?P<nid>0x[0-9a-f]+).*\[(?P<stackPtr>0x[0-9a-f]+)\]`
-
-func GetJavaThreadDump(targetPid int32) (string, error) {
-	var path string = fmt.Sprintf("/tmp/.java_pid%d", targetPid)
+// ExecuteCommand sends a raw command of the attach protocol, e.g. threaddump or jcmd, with up to 3 arguments
+func ExecuteCommand(targetPid int32, command string, args ...string) (string, error) {
+	var path string = SocketPath(targetPid)
 	var exist, _ = checkFileExists(path)
 
 	if(!exist) {
@@ -46,16 +65,17 @@ func GetJavaThreadDump(targetPid int32) (string, error) {
 
 
 	sendString(socket,"1")
-	sendString(socket, "threaddump")
-	var args[3]string
-	args[0] = ""
-	sendString(socket, args[0])
-	args[1] = ""
-	sendString(socket, args[1])
-	args[2] = "  "
-	sendString(socket, args[2])
+	sendString(socket, command)
+	//the protocol always expects 3 arguments
+	for i := 0; i < 3; i++ {
+		var arg = ""
+		if i < len(args) {
+			arg = args[i]
+		}
+		sendString(socket, arg)
+	}
 
-	glog.V(3).Infof("Asked for dump, waiting for reply...\n")
+	glog.V(3).Infof("Asked for %s, waiting for reply...\n", command)
 
 
 	res := readString(socket)
@@ -65,16 +85,21 @@ func GetJavaThreadDump(targetPid int32) (string, error) {
 	return res, nil
 }
 
+// SocketPath is where the attach listener of the target JVM listens
+func SocketPath(pid int32) (string) {
+	return procfs.TmpPath(fmt.Sprintf(".java_pid%d", pid))
+}
+
 func startServer(pid int32, udsPath string) (error) {
 	glog.V(3).Infof("Socket file does not exist. Asking process to start server...\n")
 
-	var path string = fmt.Sprintf("/proc/%d/cwd/.attach_pid%d", pid, pid)
+	var path string = procfs.ProcPath(pid, "cwd", fmt.Sprintf(".attach_pid%d", pid))
 
 	file, _ := os.OpenFile(path, os.O_RDWR | os.O_CREATE, 0666)
 	file.Write([]byte(""))
 	file.Close()
 
-	proc, err := searchProcessByPid(pid)
+	proc, err := procfs.SearchProcessByPid(pid)
 
 	if err != nil {
 		glog.Errorf("proc [%d] cannot be found! Cause: [%s]", pid, err)
@@ -114,7 +139,7 @@ func checkFileExists(path string) (bool, error) {
 	if os.IsNotExist(err) {
 		return false, nil
 	}
-	return err != nil, err
+	return err == nil, err
 }
 
 
@@ -148,40 +173,3 @@ func readString(socket net.Conn)(res string) {
 
 	return result
 }
-
-func parseJavaThreadInfo(jstackOutput string) (map[int]JavaThread) {
-	lines := strings.Split(jstackOutput, "\n")
-	var result = make(map[int]JavaThread)
-	for _, line := range lines {
-		params := ParseRegexByGroup(THREAD_REGEX, line)
-		if len(params) > 0 {
-
-			assembleJavaThreadInfo := func (paramsMap map[string]string) (JavaThread, error) {
-				jthread := JavaThread{}
-
-				jthread.threadname = paramsMap["threadName"]
-				jthread.tid = paramsMap["tid"]
-				nid, err := strconv.ParseUint(paramsMap["nid"], 0, 16)
-				if err != nil {
-					glog.V(3).Infof("Parsing jthread.tid has failed: ", err)
-					return jthread, err
-				}
-				jthread.nid = int(nid)
-				jthread.stackPtr, err = strconv.ParseUint(paramsMap["stackPtr"], 0, 64)
-				if err != nil {
-					glog.V(3).Infof("Parsing jthread.stackPtr has failed: ", err)
-					return jthread, err
-				}
-				return jthread, nil
-			}
-
-			glog.V(0).Infof("%s\n", line)
-
-			javaThread,_ := assembleJavaThreadInfo(params)
-
-			result[javaThread.nid] = javaThread
-		}
-	}
-
-	return result
-}
\ No newline at end of file
@@ -0,0 +1,151 @@
+package attach
+
+import (
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/shirou/gopsutil/process"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JavaProcess describes a JVM found on this host which ptop could attach to
+type JavaProcess struct {
+	Pid        int32
+	User       string
+	MainClass  string
+	Uptime     time.Duration
+	// whether the attach listener socket has already been created by the target JVM
+	Attachable bool
+}
+
+// DiscoverJavaProcesses finds running JVMs via the /tmp/hsperfdata_<user>/<pid> files and, for JVMs started with
+// -XX:-UsePerfData, via their command lines.
+func DiscoverJavaProcesses() ([]JavaProcess, error) {
+	var candidates = make(map[int32]string)
+
+	hsperfDirs, _ := filepath.Glob(procfs.TmpPath(hsperf.HSPERFDATA_DIR_PREFIX + "*"))
+	for _, hsperfDir := range hsperfDirs {
+		user := strings.TrimPrefix(filepath.Base(hsperfDir), hsperf.HSPERFDATA_DIR_PREFIX)
+
+		files, err := ioutil.ReadDir(hsperfDir)
+		if err != nil {
+			glog.V(3).Infof("Skipping %s. Cause: [%s]", hsperfDir, err)
+			continue
+		}
+		for _, file := range files {
+			pid, err := strconv.ParseInt(file.Name(), 10, 32)
+			if err != nil {
+				continue
+			}
+			candidates[int32(pid)] = user
+		}
+	}
+
+	listOfProcesses, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	for _, proc := range listOfProcesses {
+		if _, ok := candidates[proc.Pid]; ok {
+			continue
+		}
+		name, _ := proc.Name()
+		if name == "java" {
+			candidates[proc.Pid] = ""
+		}
+	}
+
+	var result []JavaProcess
+	for pid, user := range candidates {
+		//hsperfdata files of crashed JVMs are left behind
+		exist, _ := process.PidExists(pid)
+		if !exist {
+			continue
+		}
+
+		jproc, err := newJavaProcess(pid, user)
+		if err != nil {
+			glog.V(3).Infof("Skipping pid %d. Cause: [%s]", pid, err)
+			continue
+		}
+		result = append(result, *jproc)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Pid < result[j].Pid })
+
+	return result, nil
+}
+
+func newJavaProcess(pid int32, user string) (*JavaProcess, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	jproc := JavaProcess{Pid: pid, User: user}
+
+	if jproc.User == "" {
+		jproc.User, _ = proc.Username()
+	}
+
+	cmdline, err := proc.CmdlineSlice()
+	if err != nil {
+		return nil, err
+	}
+	jproc.MainClass = parseMainClass(cmdline)
+
+	createTime, err := proc.CreateTime()
+	if err == nil {
+		jproc.Uptime = time.Since(time.Unix(0, createTime*int64(time.Millisecond)))
+	}
+
+	jproc.Attachable, _ = checkFileExists(SocketPath(pid))
+
+	return &jproc, nil
+}
+
+// launcher options which consume the following argument
+var javaOptionsWithArgument = map[string]bool{
+	"-cp":                   true,
+	"-classpath":            true,
+	"--class-path":          true,
+	"-p":                    true,
+	"--module-path":         true,
+	"--upgrade-module-path": true,
+	"--add-modules":         true,
+	"--limit-modules":       true,
+	"--add-reads":           true,
+	"--add-exports":         true,
+	"--add-opens":           true,
+	"--patch-module":        true,
+}
+
+// parseMainClass returns the main class, jar or module of a java launcher command line
+func parseMainClass(cmdline []string) string {
+	for i := 1; i < len(cmdline); i++ {
+		arg := cmdline[i]
+
+		switch {
+		case arg == "-jar" || arg == "-m" || arg == "--module":
+			if i+1 < len(cmdline) {
+				return cmdline[i+1]
+			}
+			return ""
+		case strings.HasPrefix(arg, "--module="):
+			return strings.TrimPrefix(arg, "--module=")
+		case javaOptionsWithArgument[arg]:
+			i++
+		case strings.HasPrefix(arg, "-"):
+			continue
+		default:
+			return arg
+		}
+	}
+
+	return ""
+}
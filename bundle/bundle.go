@@ -0,0 +1,225 @@
+// Package bundle captures a diagnostic bundle of a JVM, i.e. a tar.gz of its thread dumps, of its /proc files and of the
+// outputs of a few jcmd commands, which is what is usually asked for when a JVM misbehaves.
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/nmt"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_THREAD_DUMPS = 3
+
+const DEFAULT_THREAD_DUMP_INTERVAL = 5 * time.Second
+
+// files of /proc/<pid> copied once into the bundle
+var listOfProcessFiles = []string{"status", "limits", "smaps", "smaps_rollup", "io", "stat", "cgroup"}
+
+// files of /proc/<pid>/task/<tid> copied along each thread dump, so that CPU and I/O deltas can be computed between dumps
+var listOfTaskFiles = []string{"stat", "io", "comm"}
+
+// Options tells what goes into a bundle
+type Options struct {
+	// number of thread dumps, taken Interval apart
+	ThreadDumps    int
+	Interval       time.Duration
+	// GC.class_histogram, which triggers a full GC
+	ClassHistogram bool
+	// VM.native_memory, if the JVM runs with -XX:NativeMemoryTracking
+	NativeMemory   bool
+}
+
+func DefaultOptions() *Options {
+	return &Options{ThreadDumps: DEFAULT_THREAD_DUMPS, Interval: DEFAULT_THREAD_DUMP_INTERVAL, NativeMemory: true}
+}
+
+// bundleWriter adds entries under a common directory, and records what could not be captured
+type bundleWriter struct {
+	tarWriter *tar.Writer
+	root      string
+	failures  []string
+}
+
+func (this *bundleWriter) add(name string, contents []byte) error {
+	header := tar.Header{Name: this.root + "/" + name, Mode: 0644, Size: int64(len(contents)), ModTime: time.Now(), Typeflag: tar.TypeReg}
+	if err := this.tarWriter.WriteHeader(&header); err != nil {
+		return err
+	}
+	_, err := this.tarWriter.Write(contents)
+	return err
+}
+
+func (this *bundleWriter) fail(name string, err error) {
+	glog.V(3).Infof("bundle %s Cause: [%s]", name, err)
+	this.failures = append(this.failures, fmt.Sprintf("%s: %s", name, err))
+}
+
+// Capture writes the bundle of a process to dir and returns its path. It takes at least (ThreadDumps - 1) * Interval.
+// Whatever cannot be captured is listed in the MANIFEST of the bundle rather than failing it.
+func Capture(pid int32, dir string, options *Options) (string, error) {
+	startedAt := time.Now()
+	root := fmt.Sprintf("ptop-%d-%s", pid, startedAt.Format("20060102-150405"))
+	path := filepath.Join(dir, root+".tar.gz")
+
+	//written aside and renamed once complete, so that a watcher of dir never picks up a partial bundle
+	file, err := os.Create(path + ".partial")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(path + ".partial")
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	writer := &bundleWriter{tarWriter: tar.NewWriter(gzipWriter), root: root}
+
+	if err := capture(pid, writer, options); err != nil {
+		return "", err
+	}
+
+	hostname, _ := os.Hostname()
+	manifest := fmt.Sprintf("pid: %d\nhost: %s\nstarted: %s\nfinished: %s\nthread dumps: %d, %s apart\n", pid, hostname,
+		startedAt.Format(time.RFC3339), time.Now().Format(time.RFC3339), options.ThreadDumps, options.Interval)
+	if len(writer.failures) > 0 {
+		manifest += "\nnot captured:\n  " + strings.Join(writer.failures, "\n  ") + "\n"
+	}
+	if err := writer.add("MANIFEST", []byte(manifest)); err != nil {
+		return "", err
+	}
+
+	if err := writer.tarWriter.Close(); err != nil {
+		return "", err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(path+".partial", path)
+}
+
+func capture(pid int32, writer *bundleWriter, options *Options) error {
+	if _, err := procfs.SearchProcessByPid(pid); err != nil {
+		return err
+	}
+
+	for _, name := range listOfProcessFiles {
+		if err := copyFile(writer, procfs.ProcPath(pid, name), name); err != nil {
+			return err
+		}
+	}
+
+	if cmdline, err := ioutil.ReadFile(procfs.ProcPath(pid, "cmdline")); err == nil {
+		if err := writer.add("cmdline", []byte(strings.Join(RedactArguments(splitNul(cmdline)), "\n")+"\n")); err != nil {
+			return err
+		}
+	} else {
+		writer.fail("cmdline", err)
+	}
+
+	if environ, err := ioutil.ReadFile(procfs.ProcPath(pid, "environ")); err == nil {
+		if err := writer.add("environ", []byte(strings.Join(RedactEnvironment(splitNul(environ)), "\n")+"\n")); err != nil {
+			return err
+		}
+	} else {
+		writer.fail("environ", err)
+	}
+
+	for i := 1; i <= options.ThreadDumps; i++ {
+		if i > 1 {
+			time.Sleep(options.Interval)
+		}
+
+		if threadDump, err := attach.GetJavaThreadDump(pid); err == nil {
+			if err := writer.add(fmt.Sprintf("threaddump-%d.txt", i), []byte(threadDump)); err != nil {
+				return err
+			}
+		} else {
+			writer.fail(fmt.Sprintf("threaddump-%d.txt", i), err)
+		}
+
+		if err := copyTasks(writer, pid, fmt.Sprintf("task-%d", i)); err != nil {
+			return err
+		}
+	}
+
+	if options.NativeMemory {
+		if output, err := nmt.GetNativeMemoryOutput(pid); err == nil {
+			if err := writer.add("jcmd/VM.native_memory.txt", []byte(output)); err != nil {
+				return err
+			}
+		} else {
+			writer.fail("jcmd/VM.native_memory.txt", err)
+		}
+	}
+
+	if options.ClassHistogram {
+		if output, err := attach.ExecuteJcmd(pid, "GC.class_histogram"); err == nil {
+			if err := writer.add("jcmd/GC.class_histogram.txt", []byte(output)); err != nil {
+				return err
+			}
+		} else {
+			writer.fail("jcmd/GC.class_histogram.txt", err)
+		}
+	}
+
+	return nil
+}
+
+//copyFile adds a file as name, a file which cannot be read is recorded as a failure
+func copyFile(writer *bundleWriter, path string, name string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		writer.fail(name, err)
+		return nil
+	}
+	return writer.add(name, contents)
+}
+
+//copyTasks adds the files of every thread under dir/<tid>/
+func copyTasks(writer *bundleWriter, pid int32, dir string) error {
+	listOfTasks, err := ioutil.ReadDir(procfs.ProcPath(pid, "task"))
+	if err != nil {
+		writer.fail(dir, err)
+		return nil
+	}
+
+	var tids []int
+	for _, task := range listOfTasks {
+		if tid, err := strconv.Atoi(task.Name()); err == nil {
+			tids = append(tids, tid)
+		}
+	}
+	sort.Ints(tids)
+
+	for _, tid := range tids {
+		for _, name := range listOfTaskFiles {
+			contents, err := ioutil.ReadFile(procfs.TaskPath(pid, int32(tid), name))
+			if err != nil {
+				//thread has exited meanwhile
+				continue
+			}
+			if err := writer.add(fmt.Sprintf("%s/%d/%s", dir, tid, name), contents); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func splitNul(contents []byte) []string {
+	return strings.Split(strings.TrimRight(string(contents), "\x00"), "\x00")
+}
@@ -1 +1,313 @@
 package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/shirou/gopsutil/process"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// parseTargets resolves the command line arguments into the list of pids to be monitored. Targets are given
+// either as explicit pids or via -name <regex>, which is matched against process names and command lines.
+// The rules given via -rules, if any, and when threads are flagged as stuck are returned along.
+func parseTargets(args []string) ([]int32, *AlertConfig, *StuckOptions, error) {
+	flags := flag.NewFlagSet("ptop", flag.ContinueOnError)
+	namePattern := flags.String("name", "", "regular expression matched against process name and cmdline")
+	rulesPath := flags.String("rules", "", "rules file evaluated on every refresh")
+	stuckOptions := addStuckFlags(flags)
+
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := stuckOptions.check(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	var alertConfig *AlertConfig
+	if *rulesPath != "" {
+		var err error
+		alertConfig, err = LoadAlertConfig(*rulesPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var pids []int32
+	for _, arg := range flags.Args() {
+		parsedPid, err := strconv.ParseInt(arg, 10, 32)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid pid [%s]", arg)
+		}
+		pids = append(pids, int32(parsedPid))
+	}
+
+	if *namePattern != "" {
+		matchedPids, err := procfs.SearchProcessesByName(*namePattern)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		pids = append(pids, matchedPids...)
+	}
+
+	pids = uniquePids(pids)
+	if len(pids) == 0 {
+		return nil, nil, nil, fmt.Errorf("no target process found")
+	}
+
+	return pids, alertConfig, stuckOptions, nil
+}
+
+// StuckOptions tells when the TUI flags a thread as stuck, see jvmdump.StuckDetector
+type StuckOptions struct {
+	// successive thread dumps the top frames must stay the same in
+	Dumps     int
+	// innermost frames compared
+	TopFrames int
+}
+
+func defaultStuckOptions() *StuckOptions {
+	return &StuckOptions{Dumps: jvmdump.DEFAULT_STUCK_DUMPS, TopFrames: jvmdump.DEFAULT_STUCK_TOP_FRAMES}
+}
+
+// addStuckFlags defines -stuck-dumps and -stuck-frames on flags
+func addStuckFlags(flags *flag.FlagSet) *StuckOptions {
+	options := defaultStuckOptions()
+	flags.IntVar(&options.Dumps, "stuck-dumps", options.Dumps, "successive thread dumps a RUNNABLE or BLOCKED thread must keep its top frames in to be flagged as stuck")
+	flags.IntVar(&options.TopFrames, "stuck-frames", options.TopFrames, "innermost frames compared between thread dumps to flag stuck threads")
+	return options
+}
+
+func (this *StuckOptions) check() error {
+	if this.Dumps < 2 {
+		return fmt.Errorf("invalid stuck dumps [%d], at least 2 thread dumps are compared", this.Dumps)
+	}
+	if this.TopFrames < 1 {
+		return fmt.Errorf("invalid stuck frames [%d], at least 1 frame is compared", this.TopFrames)
+	}
+	return nil
+}
+
+func uniquePids(pids []int32) []int32 {
+	var seen = make(map[int32]bool)
+	var result []int32
+
+	for _, pid := range pids {
+		if !seen[pid] {
+			seen[pid] = true
+			result = append(result, pid)
+		}
+	}
+
+	return result
+}
+
+// runList prints the JVMs found on this host, i.e. `ptop list`
+func runList(args []string) error {
+	if len(args) != 0 {
+		return usageErrorf("ptop list takes no argument")
+	}
+
+	listOfJavaProcesses, err := attach.DiscoverJavaProcesses()
+	if err != nil {
+		return err
+	}
+
+	PrintJavaProcesses(listOfJavaProcesses)
+	return nil
+}
+
+// runPicker offers the discovered JVMs in the TUI when ptop is started without any target
+func runPicker() []int32 {
+	listOfJavaProcesses, err := attach.DiscoverJavaProcesses()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return nil
+	}
+
+	if len(listOfJavaProcesses) == 0 {
+		fmt.Fprintf(os.Stderr, "no Java process found\n")
+		return nil
+	}
+
+	return pickJavaProcesses(listOfJavaProcesses)
+}
+
+// usageError is a mistake in the arguments of a command, which the usage is printed after
+type usageError struct {
+	err error
+}
+
+func (this usageError) Error() string {
+	return this.err.Error()
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// flagError makes the error of parsing the flags a usage error, but for -h, after which the defaults were printed already
+func flagError(err error) error {
+	if err == flag.ErrHelp {
+		return err
+	}
+	return usageError{err}
+}
+
+// parseInterspersed parses flags given before, between or after the positional arguments, which are returned
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, flagError(err)
+	}
+
+	var positional []string
+	for flags.NArg() > 0 {
+		positional = append(positional, flags.Arg(0))
+		if err := flags.Parse(flags.Args()[1:]); err != nil {
+			return nil, flagError(err)
+		}
+	}
+
+	return positional, nil
+}
+
+// runRecord captures the given process into a recording, i.e. `ptop record <pid> -o <file>`, until -count frames are
+// written, the process is gone or ptop is interrupted
+func runRecord(args []string) error {
+	flags := flag.NewFlagSet("ptop record", flag.ContinueOnError)
+	output := flags.String("o", "", "recording file to write")
+	interval := flags.Duration("interval", DEFAULT_RECORD_INTERVAL_IN_SECOND * time.Second, "time between two frames")
+	count := flags.Int("count", 0, "number of frames to record, 0 for no limit")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *output == "" {
+		return usageErrorf("a pid and -o <file> are required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	pid := int32(parsedPid)
+
+	header := RecordingHeader{Pid: pid, StartedAt: time.Now(), Interval: *interval}
+	if proc, err := process.NewProcess(pid); err == nil {
+		header.Name, _ = proc.Name()
+	}
+	header.Hostname, _ = os.Hostname()
+
+	writer, err := CreateRecording(*output, &header)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for recorded := 0; *count == 0 || recorded < *count; {
+		frame, err := correlate.CaptureFrame(pid)
+		if err != nil {
+			if _, searchErr := procfs.SearchProcessByPid(pid); searchErr != nil {
+				fmt.Fprintf(os.Stderr, "process %d is gone, %d frames recorded\n", pid, recorded)
+				return nil
+			}
+			glog.Warningf("CaptureFrame(%d) Cause: [%s]", pid, err)
+		} else {
+			if err := writer.WriteFrame(frame); err != nil {
+				return err
+			}
+			recorded++
+			fmt.Fprintf(os.Stderr, "recorded frame %d at %s\n", recorded, frame.Timestamp.Format("15:04:05"))
+			if recorded == *count {
+				return nil
+			}
+		}
+
+		select {
+		case <-signals:
+			fmt.Fprintf(os.Stderr, "interrupted, %d frames recorded\n", recorded)
+			return nil
+		case <-ticker.C:
+		}
+	}
+
+	return nil
+}
+
+// runReplay opens the TUI over a recording, i.e. `ptop replay <file>`
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("ptop replay", flag.ContinueOnError)
+	stuckOptions := addStuckFlags(flags)
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if err := stuckOptions.check(); err != nil {
+		return usageError{err}
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a recording file is required")
+	}
+
+	recording, err := OpenRecording(positional[0])
+	if err != nil {
+		return err
+	}
+	defer recording.Close()
+
+	tuiLoop([]int32{recording.Header.Pid}, NewReplaySource(recording), nil, stuckOptions)
+
+	return nil
+}
+
+// runAnalyze runs the association over artifacts captured elsewhere, i.e. `ptop analyze -threaddump <file> -smaps <file>`,
+// and shows the result in the TUI, or prints it with -print
+func runAnalyze(args []string) error {
+	flags := flag.NewFlagSet("ptop analyze", flag.ContinueOnError)
+	threadDumpPath := flags.String("threaddump", "", "thread dump, as written by jstack or jcmd Thread.print")
+	smapsPath := flags.String("smaps", "", "copy of /proc/<pid>/smaps taken along with the thread dump")
+	pid := flags.Int("pid", ANALYZE_DEFAULT_PID, "pid of the process the artifacts were taken from, for display only")
+	printOnly := flags.Bool("print", false, "print the mappings instead of opening the TUI")
+
+	if err := flags.Parse(args); err != nil {
+		return flagError(err)
+	}
+	if *threadDumpPath == "" || *smapsPath == "" {
+		return usageErrorf("-threaddump and -smaps are required")
+	}
+
+	frame, err := LoadFrame(*threadDumpPath, *smapsPath)
+	if err != nil {
+		return err
+	}
+
+	if *printOnly {
+		snapshot, err := correlate.BuildSnapshot(int32(*pid), frame)
+		if err != nil {
+			return err
+		}
+		PrintMemorySegments(snapshot.Segments)
+		return nil
+	}
+
+	//a single thread dump, nothing is ever stuck
+	tuiLoop([]int32{int32(*pid)}, NewFrameSource(int32(*pid), *threadDumpPath, frame), nil, defaultStuckOptions())
+
+	return nil
+}
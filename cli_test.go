@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseTargetsStuckOptions(t *testing.T) {
+	pids, _, stuckOptions, err := parseTargets([]string{"1234"})
+	if err != nil || len(pids) != 1 || pids[0] != 1234 {
+		t.Fatalf("parseTargets = %v, %v", pids, err)
+	}
+	if stuckOptions.Dumps != jvmdump.DEFAULT_STUCK_DUMPS || stuckOptions.TopFrames != jvmdump.DEFAULT_STUCK_TOP_FRAMES {
+		t.Errorf("default stuck options = %+v", stuckOptions)
+	}
+
+	_, _, stuckOptions, err = parseTargets([]string{"-stuck-dumps", "5", "-stuck-frames", "8", "1234"})
+	if err != nil || stuckOptions.Dumps != 5 || stuckOptions.TopFrames != 8 {
+		t.Errorf("stuck options = %+v, %v", stuckOptions, err)
+	}
+
+	for _, args := range [][]string{{"-stuck-dumps", "1", "1234"}, {"-stuck-frames", "0", "1234"}} {
+		if _, _, _, err := parseTargets(args); err == nil {
+			t.Errorf("parseTargets(%v) did not fail", args)
+		}
+	}
+}
+
+func TestCommandUsageErrors(t *testing.T) {
+	listOfTests := []struct {
+		command string
+		args    []string
+		usage   bool
+	}{
+		{"serve", nil, true},
+		{"serve", []string{"-no-such-flag", "1234"}, true},
+		{"serve", []string{"-thread-labels", "tid", "1234"}, true},
+		{"hot", []string{"-sort", "rss", "1234"}, true},
+		{"flame", []string{"-weight", "wall", "1234"}, true},
+		{"otlp", []string{"-protocol", "udp", "1234"}, true},
+		{"replay", []string{"-stuck-dumps", "1", "test.ptoprec"}, true},
+		{"list", []string{"1234"}, true},
+		//the arguments are right, the command fails
+		{"replay", []string{filepath.Join(t.TempDir(), "missing.ptoprec")}, false},
+	}
+
+	for _, test := range listOfTests {
+		err := commands[test.command](test.args)
+		if err == nil {
+			t.Errorf("ptop %s %v did not fail", test.command, test.args)
+			continue
+		}
+		if _, usage := err.(usageError); usage != test.usage {
+			t.Errorf("ptop %s %v failed with [%s], usage error %t, expected %t", test.command, test.args, err, usage, test.usage)
+		}
+	}
+
+	if err := commands["serve"]([]string{"-h"}); err != flag.ErrHelp {
+		t.Errorf("ptop serve -h = %v, expected flag.ErrHelp", err)
+	}
+}
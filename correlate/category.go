@@ -0,0 +1,269 @@
+package correlate
+
+import (
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/internal/util"
+	"github.com/mcfongtw/go-ptop/nmt"
+	"strconv"
+	"strings"
+)
+
+// Memory categories a JVM mapping is classified into
+const (
+	CATEGORY_JAVA_HEAP      = "Java Heap"
+	CATEGORY_METASPACE      = "Metaspace"
+	CATEGORY_CODE_CACHE     = "Code Cache"
+	CATEGORY_THREAD_STACK   = "Thread Stack"
+	CATEGORY_GC             = "GC"
+	CATEGORY_DIRECT_BUFFER  = "Direct Buffer"
+	CATEGORY_MALLOC_ARENA   = "Malloc Arena"
+	CATEGORY_SHARED_LIBRARY = "Shared Library"
+	CATEGORY_MAPPED_FILE    = "Mapped File"
+	CATEGORY_OTHER          = "Other"
+)
+
+// display order of the categories in the summary
+var ListOfMemoryCategories = []string{CATEGORY_JAVA_HEAP, CATEGORY_METASPACE, CATEGORY_CODE_CACHE, CATEGORY_THREAD_STACK, CATEGORY_GC,
+	CATEGORY_DIRECT_BUFFER, CATEGORY_MALLOC_ARENA, CATEGORY_SHARED_LIBRARY, CATEGORY_MAPPED_FILE, CATEGORY_OTHER}
+
+// NMT category names of the JDK 8 to 17 reports, mapped to ptop categories
+var nmtCategoryMapping = map[string]string{
+	"Java Heap":    CATEGORY_JAVA_HEAP,
+	"Class":        CATEGORY_METASPACE,
+	"Metaspace":    CATEGORY_METASPACE,
+	"Code":         CATEGORY_CODE_CACHE,
+	"Thread":       CATEGORY_THREAD_STACK,
+	"Thread Stack": CATEGORY_THREAD_STACK,
+	"GC":           CATEGORY_GC,
+	// direct ByteBuffers are malloc'ed and accounted as Other since JDK 11
+	"Other":        CATEGORY_DIRECT_BUFFER,
+}
+
+// glibc reserves each non-main malloc arena as a 64MB aligned block of 64MB
+const MALLOC_ARENA_SIZE_IN_KB = 64 * 1024
+
+const HEAP_INFO_REGEX = `total \d+K, used \d+K \[(?P<start>0x[0-9a-f]+),.*(?P<stop>0x[0-9a-f]+)\)`
+
+const CODE_CACHE_BOUNDS_REGEX = `bounds \[(?P<start>0x[0-9a-f]+), 0x[0-9a-f]+, (?P<stop>0x[0-9a-f]+)\]`
+
+// AddressRange is a half-open range of virtual addresses
+type AddressRange struct {
+	Start uint64
+	Stop  uint64
+}
+
+// Contains tells whether addr falls within the range
+func (this AddressRange) Contains(addr uint64) bool {
+	return addr >= this.Start && addr < this.Stop
+}
+
+// JvmMemoryLayout gathers what the JVM tells about its own address space
+type JvmMemoryLayout struct {
+	HeapRanges      []AddressRange
+	CodeCacheRanges []AddressRange
+	// nil unless the JVM runs with -XX:NativeMemoryTracking
+	NativeMemory    *nmt.NativeMemoryReport
+}
+
+// JvmDiagnostics are the raw outputs of the diagnostic commands the memory layout is derived from, empty if a command failed
+type JvmDiagnostics struct {
+	HeapInfo     string
+	CodeCache    string
+	NativeMemory string
+}
+
+// GetJvmMemoryLayout collects the layout over the attach socket. It is best effort: whatever cannot be retrieved is left empty.
+func GetJvmMemoryLayout(pid int32) *JvmMemoryLayout {
+	return ParseJvmMemoryLayout(GetJvmDiagnostics(pid))
+}
+
+// GetJvmDiagnostics runs the jcmd commands the memory layout of the JVM is derived from
+func GetJvmDiagnostics(pid int32) *JvmDiagnostics {
+	diagnostics := JvmDiagnostics{}
+	var err error
+
+	diagnostics.HeapInfo, err = attach.ExecuteJcmd(pid, "GC.heap_info")
+	if err != nil {
+		glog.Warningf("GC.heap_info Cause: [%s]", err)
+	}
+
+	diagnostics.CodeCache, err = attach.ExecuteJcmd(pid, "Compiler.codecache")
+	if err != nil {
+		glog.Warningf("Compiler.codecache Cause: [%s]", err)
+	}
+
+	diagnostics.NativeMemory, err = nmt.GetNativeMemoryOutput(pid)
+	if err != nil {
+		glog.Warningf("VM.native_memory Cause: [%s]", err)
+	}
+
+	return &diagnostics
+}
+
+// ParseJvmMemoryLayout derives the heap, code cache and NMT ranges from the output of the jcmd commands
+func ParseJvmMemoryLayout(diagnostics *JvmDiagnostics) *JvmMemoryLayout {
+	layout := JvmMemoryLayout{}
+
+	layout.HeapRanges = parseAddressRanges(HEAP_INFO_REGEX, diagnostics.HeapInfo)
+	layout.CodeCacheRanges = parseAddressRanges(CODE_CACHE_BOUNDS_REGEX, diagnostics.CodeCache)
+
+	if diagnostics.NativeMemory != "" {
+		var err error
+		layout.NativeMemory, err = nmt.ParseNativeMemoryReport(diagnostics.NativeMemory)
+		if err != nil && err != nmt.ErrNmtNotEnabled {
+			glog.Warningf("ParseNativeMemoryReport Cause: [%s]", err)
+		}
+	}
+
+	return &layout
+}
+
+func parseAddressRanges(regEx string, output string) []AddressRange {
+	var ranges []AddressRange
+
+	for _, line := range strings.Split(output, "\n") {
+		params := util.ParseRegexByGroup(regEx, line)
+		if len(params) == 0 {
+			continue
+		}
+		start, err := strconv.ParseUint(params["start"], 0, 64)
+		if err != nil {
+			continue
+		}
+		stop, err := strconv.ParseUint(params["stop"], 0, 64)
+		if err != nil {
+			continue
+		}
+		ranges = append(ranges, AddressRange{Start: start, Stop: stop})
+	}
+
+	return ranges
+}
+
+// ClassifyMemorySegments assigns a memory category to every segment. Segments must be sorted by address, as in smaps.
+func ClassifyMemorySegments(listOfMemorySegments *[]TaskMemorySegment, layout *JvmMemoryLayout) {
+	inRanges := func(ranges []AddressRange, addr uint64) bool {
+		for _, addrRange := range ranges {
+			if addrRange.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for i := 0; i < len(*listOfMemorySegments); i++ {
+		segment := &((*listOfMemorySegments)[i])
+
+		segment.Category = ""
+		if layout.NativeMemory != nil {
+			for _, region := range layout.NativeMemory.Regions {
+				if (AddressRange{Start: region.Start, Stop: region.Stop}).Contains(segment.StackStart) {
+					if category, ok := nmtCategoryMapping[region.Category]; ok {
+						segment.Category = category
+					}
+					break
+				}
+			}
+		}
+		if segment.Category != "" {
+			continue
+		}
+
+		switch {
+		case inRanges(layout.HeapRanges, segment.StackStart):
+			segment.Category = CATEGORY_JAVA_HEAP
+		case inRanges(layout.CodeCacheRanges, segment.StackStart):
+			segment.Category = CATEGORY_CODE_CACHE
+		case segment.FrameType == "JavaThread" || segment.Path == "[stack]":
+			segment.Category = CATEGORY_THREAD_STACK
+		case segment.Path == "[heap]":
+			segment.Category = CATEGORY_MALLOC_ARENA
+		case strings.HasPrefix(segment.Path, "/"):
+			if strings.Contains(segment.Path, ".so") {
+				segment.Category = CATEGORY_SHARED_LIBRARY
+			} else {
+				segment.Category = CATEGORY_MAPPED_FILE
+			}
+		case isMallocArena(listOfMemorySegments, i):
+			segment.Category = CATEGORY_MALLOC_ARENA
+		default:
+			segment.Category = CATEGORY_OTHER
+		}
+	}
+}
+
+// isMallocArena tells whether the i-th segment is part of a malloc arena, i.e. the rw-p head or the ---p tail of a 64MB aligned block
+func isMallocArena(listOfMemorySegments *[]TaskMemorySegment, i int) bool {
+	isAnonymous := func(segment TaskMemorySegment) bool {
+		return !strings.HasPrefix(segment.Path, "/") && !strings.HasPrefix(segment.Path, "[")
+	}
+	isArena := func(head TaskMemorySegment, tail *TaskMemorySegment) bool {
+		if !isAnonymous(head) || head.StackStart%(MALLOC_ARENA_SIZE_IN_KB*1024) != 0 {
+			return false
+		}
+		if head.Size == MALLOC_ARENA_SIZE_IN_KB {
+			return true
+		}
+		return tail != nil && isAnonymous(*tail) && tail.FramePerm == "---p" && tail.StackStart == head.StackStop &&
+			head.Size+tail.Size == MALLOC_ARENA_SIZE_IN_KB
+	}
+
+	segments := *listOfMemorySegments
+	var next *TaskMemorySegment
+	if i+1 < len(segments) {
+		next = &segments[i+1]
+	}
+	if isArena(segments[i], next) {
+		return true
+	}
+
+	return i > 0 && isArena(segments[i-1], &segments[i])
+}
+
+// MemoryCategorySummary aggregates the segments of one category, in kB
+type MemoryCategorySummary struct {
+	Category     string
+	Mappings     int
+	Size         uint64
+	Rss          uint64
+	Pss          uint64
+	// committed memory reported by NMT for this category, if NMT is enabled
+	NmtCommitted uint64
+	HasNmt       bool
+}
+
+// SummarizeMemoryCategories adds up the segments of each category, next to the committed memory NMT reports for it
+func SummarizeMemoryCategories(listOfMemorySegments *[]TaskMemorySegment, nativeMemory *nmt.NativeMemoryReport) []MemoryCategorySummary {
+	var summaries = make(map[string]*MemoryCategorySummary)
+	for _, category := range ListOfMemoryCategories {
+		summaries[category] = &MemoryCategorySummary{Category: category}
+	}
+
+	for _, segment := range *listOfMemorySegments {
+		summary, ok := summaries[segment.Category]
+		if !ok {
+			summary = summaries[CATEGORY_OTHER]
+		}
+		summary.Mappings++
+		summary.Size += segment.Size
+		summary.Rss += segment.Rss
+		summary.Pss += segment.Pss
+	}
+
+	if nativeMemory != nil {
+		for _, nmtCategory := range nativeMemory.Categories {
+			if category, ok := nmtCategoryMapping[nmtCategory.Name]; ok {
+				summaries[category].NmtCommitted += nmtCategory.Committed
+				summaries[category].HasNmt = true
+			}
+		}
+	}
+
+	var result []MemoryCategorySummary
+	for _, category := range ListOfMemoryCategories {
+		result = append(result, *summaries[category])
+	}
+
+	return result
+}
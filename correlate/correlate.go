@@ -0,0 +1,193 @@
+// Package correlate ties the Java threads of a thread dump to the kernel threads and to the mappings holding their stacks,
+// and classifies the other mappings into JVM memory categories.
+package correlate
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"github.com/shirou/gopsutil/process"
+	"strings"
+	"time"
+)
+
+// TaskMemorySegment is a mapping, along with the thread whose stack it holds if any
+type TaskMemorySegment struct {
+	smaps.ProcessMemorySegment
+	TaskID	   int    `json:"taskID"`
+	ReadCount  uint64 `json:"readCount"`
+	WriteCount uint64 `json:"writeCount"`
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+	//cumulative user and system time of the thread, in clock ticks
+	CpuTicks   uint64 `json:"cpuTicks"`
+	State      string `json:"state"`
+}
+
+// NewTaskMemorySegment wraps a mapping not yet associated with any thread
+func NewTaskMemorySegment(segment smaps.ProcessMemorySegment)(TaskMemorySegment) {
+	var ret = TaskMemorySegment{}
+
+	ret.ProcessMemorySegment = segment
+
+	return ret
+}
+
+// KernelThreadsFromThreadDump takes the kernel threads and their stacks from the nid and the last Java frame of the Java threads
+func KernelThreadsFromThreadDump(pid int32, mapOfJavaThread map[int]jvmdump.JavaThread)(*[]procfs.KernelThread, error) {
+	var listOfKernelThreads []procfs.KernelThread
+
+	for tid, jthread := range mapOfJavaThread {
+		lwp := procfs.KernelThread{}
+		lwp.Pid = int(pid)
+		lwp.Tid = tid
+		lwp.StartStack = jthread.StackPtr
+
+
+		listOfKernelThreads = append(listOfKernelThreads, lwp)
+	}
+
+	return &listOfKernelThreads, nil
+}
+
+// AssociateKernelThreadAndJavaThread finds the mapping holding the stack of every kernel thread, and names the ones of the Java
+// threads after them, along with their I/O counters
+func AssociateKernelThreadAndJavaThread(listOfKernelThreads *[]procfs.KernelThread, mapOfJavaThreads map[int]jvmdump.JavaThread, listOfMemorySegments *[]smaps.ProcessMemorySegment, mapOfIoStats map[int]*process.IOCountersStat)(*[]TaskMemorySegment) {
+	var foundSegments = make(map[int]*TaskMemorySegment)
+	var listOfTaskSegments []TaskMemorySegment
+
+	//Copy ProcessMemorySegment to TaskMemorySegment
+	for i := 0; i < len(*listOfMemorySegments); i++ {
+		segment := (*listOfMemorySegments)[i]
+		listOfTaskSegments = append(listOfTaskSegments, NewTaskMemorySegment(segment))
+	}
+
+	//associate KernelThreads and ProcessMemorySegment
+	for i := 0; i < len(*listOfKernelThreads); i++ {
+		kthread := (*listOfKernelThreads)[i]
+
+		for j := 0; j < len(listOfTaskSegments); j++ {
+			//call by reference of ProcessMemorySegment
+			segment := &((listOfTaskSegments)[j])
+			if kthread.StartStack >= segment.StackStart && kthread.StartStack <= segment.StackStop {
+				foundSegments[kthread.Tid] = segment
+				break
+			}
+		}
+	}
+	glog.V(0).Infof("associated memory segments: %v\n", len(foundSegments))
+
+
+	//associate ProcessMemorySegment and JavaThread
+	for tid, segment := range foundSegments {
+		jthread, ok := mapOfJavaThreads[tid]
+		if ok {
+			glog.V(0).Infof("Found java thread (%v) : %v\n", tid, jthread)
+			segment.FrameType = "JavaThread"
+			segment.Path = jthread.ThreadName
+			segment.TaskID = jthread.Nid
+			segment.State = jthread.State
+
+			ioStat, ok := mapOfIoStats[segment.TaskID]
+			if !ok {
+				glog.Warningf("I/O counters of thread %d NOT found", segment.TaskID)
+				continue
+			}
+			segment.WriteCount = ioStat.WriteCount
+			segment.ReadCount = ioStat.ReadCount
+			segment.WriteBytes = ioStat.WriteBytes
+			segment.ReadBytes = ioStat.ReadBytes
+
+
+		} else {
+			glog.Warningf("java thread (%v) NOT found\n", tid)
+
+		}
+
+	}
+
+	return &listOfTaskSegments
+}
+
+////////////////////////////////////////////////////////////////
+
+
+// Snapshot is everything ptop gathers about a process on one refresh
+type Snapshot struct {
+	Pid       int32
+	Timestamp time.Time
+	Segments  *[]TaskMemorySegment
+	Layout    *JvmMemoryLayout
+	//Java threads of the thread dump by nid, including the ones whose stack was not found in the mappings
+	Threads   map[int]jvmdump.JavaThread
+}
+
+// BuildSnapshot runs the association over a frame, whether it was just captured or read back from a recording
+func BuildSnapshot(pid int32, frame *Frame) (*Snapshot, error) {
+	mapOfJavaThread, err := jvmdump.ParseThreadDump(strings.NewReader(frame.ThreadDump))
+
+	if err != nil {
+		glog.Errorf("ParseThreadDump Cause: [%s]", err)
+		return nil, err
+	}
+
+	for key, jthread := range mapOfJavaThread {
+		glog.V(0).Infof("key : %d, val: %s\n", key, jthread)
+
+	}
+	////////////////////////////////////
+
+	listOfMemorySegment, err := smaps.Parse(context.Background(), strings.NewReader(frame.Smaps))
+
+	if err != nil {
+		glog.Errorf("smaps.Parse Cause: [%s]", err)
+		return nil, err
+	}
+
+	//for i := 0; i < len(*listOfMemorySegment); i++ {
+	//	mmap := (*listOfMemorySegment)[i]
+	//	glog.Infof("Ref: %v, RSS : %v \t PSS : %v \t anon : %v \t size %v \t Stack Start : %v \t Stack Stop : %v \t Path: %v\n", mmap.Rss, mmap.Pss, mmap.Anonymous, mmap.Referenced, mmap.Size, mmap.StackStart, mmap.StackStop, mmap.Path)
+	//}
+
+	////////////////////////////////////
+
+	listOfKernelThreads, err := KernelThreadsFromThreadDump(pid, mapOfJavaThread)
+
+	if err != nil {
+		glog.Errorf("KernelThreadsFromThreadDump Cause: [%s]", err)
+		return nil, err
+	}
+
+	for i := 0; i < len(*listOfKernelThreads); i++ {
+		kthread := (*listOfKernelThreads)[i]
+		glog.V(0).Infof("tid : %v, start stack : 0x%016x\n", kthread.Tid, kthread.StartStack)
+
+	}
+
+	///////////////////////////////////////
+
+	listOfTaskSegment := AssociateKernelThreadAndJavaThread(listOfKernelThreads, mapOfJavaThread, listOfMemorySegment, frame.IoStats())
+
+	mapOfCpuTicks := frame.CpuTicks()
+	for i := 0; i < len(*listOfTaskSegment); i++ {
+		segment := &((*listOfTaskSegment)[i])
+		if segment.FrameType == "JavaThread" {
+			segment.CpuTicks = mapOfCpuTicks[segment.TaskID]
+		}
+	}
+
+	//printMemorySegments(listOfTaskSegment)
+
+	///////////////////////////////////////
+
+	layout := ParseJvmMemoryLayout(&frame.Diagnostics)
+	if layout.NativeMemory != nil {
+		layout.NativeMemory.Timestamp = frame.Timestamp
+	}
+
+	ClassifyMemorySegments(listOfTaskSegment, layout)
+
+	return &Snapshot{Pid: pid, Timestamp: frame.Timestamp, Segments: listOfTaskSegment, Layout: layout, Threads: mapOfJavaThread}, nil
+}
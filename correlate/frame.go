@@ -0,0 +1,137 @@
+package correlate
+
+import (
+	"context"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"github.com/shirou/gopsutil/process"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TaskCapture holds the raw /proc/<pid>/task/<tid>/stat and io files
+type TaskCapture struct {
+	Stat string
+	Io   string
+}
+
+// Frame is everything ptop reads about a process on one refresh, kept raw so that a replay goes through the same parsers
+type Frame struct {
+	Timestamp   time.Time
+	ThreadDump  string
+	Smaps       string
+	// empty before Linux 4.14
+	SmapsRollup string
+	Io          string
+	Tasks       map[int]*TaskCapture
+	Diagnostics JvmDiagnostics
+	// raw hsperfdata, if any
+	PerfData    []byte `json:",omitempty"`
+}
+
+// CaptureFrame reads the thread dump, the mappings, the per-thread counters and the JVM diagnostics of a process
+func CaptureFrame(pid int32) (*Frame, error) {
+	frame := Frame{Timestamp: time.Now(), Tasks: make(map[int]*TaskCapture)}
+	var err error
+
+	frame.ThreadDump, err = attach.GetJavaThreadDump(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	frame.Smaps, err = procfs.ReadFileAsString(procfs.ProcPath(pid, "smaps"))
+	if err != nil {
+		return nil, err
+	}
+
+	frame.SmapsRollup, err = procfs.ReadFileAsString(procfs.ProcPath(pid, "smaps_rollup"))
+	if err != nil && !os.IsNotExist(err) {
+		glog.V(3).Infof("smaps_rollup Cause: [%s]", err)
+	}
+
+	frame.Io, err = procfs.ReadFileAsString(procfs.ProcPath(pid, "io"))
+	if err != nil {
+		glog.V(3).Infof("io Cause: [%s]", err)
+	}
+
+	listOfTasks, err := ioutil.ReadDir(procfs.ProcPath(pid, "task"))
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range listOfTasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		stat, err := procfs.ReadFileAsString(procfs.TaskPath(pid, int32(tid), "stat"))
+		if err != nil {
+			//thread has exited meanwhile
+			continue
+		}
+		ioContents, _ := procfs.ReadFileAsString(procfs.TaskPath(pid, int32(tid), "io"))
+		frame.Tasks[tid] = &TaskCapture{Stat: stat, Io: ioContents}
+	}
+
+	frame.Diagnostics = *GetJvmDiagnostics(pid)
+
+	if path, err := hsperf.PerfDataPath(pid); err == nil {
+		frame.PerfData, _ = ioutil.ReadFile(path)
+	}
+
+	return &frame, nil
+}
+
+// IoStats returns the I/O counters of the captured threads by tid
+func (this *Frame) IoStats() map[int]*process.IOCountersStat {
+	var result = make(map[int]*process.IOCountersStat)
+
+	for tid, task := range this.Tasks {
+		if task.Io == "" {
+			continue
+		}
+		ioStat, err := procfs.ParseIoCounters(task.Io)
+		if err != nil {
+			glog.V(3).Infof("ParseIoCounters(%d) Cause: [%s]", tid, err)
+			continue
+		}
+		result[tid] = ioStat
+	}
+
+	return result
+}
+
+// CpuTicks returns the CPU time of the captured threads by tid
+func (this *Frame) CpuTicks() map[int]uint64 {
+	var result = make(map[int]uint64)
+
+	for tid, task := range this.Tasks {
+		cpuTicks, err := procfs.ParseCpuTicks(task.Stat)
+		if err != nil {
+			glog.V(3).Infof("ParseCpuTicks(%d) Cause: [%s]", tid, err)
+			continue
+		}
+		result[tid] = cpuTicks
+	}
+
+	return result
+}
+
+// Rollup sums the memory figures of the process, from smaps_rollup if it was captured
+func (this *Frame) Rollup() (*smaps.MemoryRollup, error) {
+	if this.SmapsRollup != "" {
+		return smaps.ParseRollup(strings.NewReader(this.SmapsRollup))
+	}
+
+	listOfMemorySegments, err := smaps.Parse(context.Background(), strings.NewReader(this.Smaps))
+	if err != nil {
+		return nil, err
+	}
+	return smaps.SumMemorySegments(listOfMemorySegments), nil
+}
@@ -0,0 +1,204 @@
+package correlate
+
+import (
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pid of the fake process of testdata/proc
+const TEST_PID = 4242
+
+// replies of the fake JVM to the jcmd commands ptop runs, from testdata/jcmd
+var testJcmdReplies = map[string]string{
+	"GC.heap_info":                      "GC.heap_info.txt",
+	"Compiler.codecache":                "Compiler.codecache.txt",
+	"VM.native_memory detail scale=KB":  "VM.native_memory_detail.txt",
+	"VM.native_memory summary scale=KB": "VM.native_memory_summary.txt",
+}
+
+// useTestdataRoots points the procfs root at testdata/proc, and the tmp root at a fresh directory holding the
+// hsperfdata of testdata/tmp and the attach socket of a fake JVM answering from testdata
+func useTestdataRoots(t *testing.T) {
+	procRoot, err := filepath.Abs(filepath.Join("testdata", "proc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hsperfdataDir, err := filepath.Abs(filepath.Join("testdata", "tmp", "hsperfdata_ptop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the socket is not checked in, hence a tmp root of its own
+	tmpRoot, err := ioutil.TempDir("", "ptop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(hsperfdataDir, filepath.Join(tmpRoot, "hsperfdata_ptop")); err != nil {
+		t.Fatal(err)
+	}
+
+	procfs.SetProcRoot(procRoot)
+	procfs.SetTmpRoot(tmpRoot)
+
+	listener, err := net.Listen("unix", procfs.TmpPath(".java_pid4242"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serveAttach(listener)
+
+	t.Cleanup(func() {
+		listener.Close()
+		os.RemoveAll(tmpRoot)
+		procfs.SetProcRoot(procfs.DEFAULT_PROC_ROOT)
+		procfs.SetTmpRoot(procfs.DEFAULT_TMP_ROOT)
+		os.Unsetenv("HOST_PROC")
+	})
+}
+
+// serveAttach answers the attach protocol: a version, a command and 3 arguments, each NUL terminated
+func serveAttach(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		var request string
+		buffer := make([]byte, 4096)
+		for strings.Count(request, "\x00") < 5 {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				break
+			}
+			request += string(buffer[:n])
+		}
+		parts := strings.Split(request, "\x00")
+
+		var reply = "1\nunknown command\n"
+		if len(parts) >= 3 {
+			switch parts[1] {
+			case "threaddump":
+				if contents, err := ioutil.ReadFile(filepath.Join("testdata", "threaddump.txt")); err == nil {
+					reply = "0\n" + string(contents)
+				}
+			case "jcmd":
+				if file, ok := testJcmdReplies[parts[2]]; ok {
+					if contents, err := ioutil.ReadFile(filepath.Join("testdata", "jcmd", file)); err == nil {
+						reply = "0\n" + string(contents)
+					}
+				}
+			}
+		}
+
+		conn.Write([]byte(reply))
+		conn.Close()
+	}
+}
+
+func TestCaptureFrame(t *testing.T) {
+	useTestdataRoots(t)
+
+	frame, err := CaptureFrame(TEST_PID)
+	if err != nil {
+		t.Fatalf("CaptureFrame failed: %s", err)
+	}
+
+	if !strings.Contains(frame.ThreadDump, `"worker-1"`) {
+		t.Errorf("thread dump not captured: [%s]", frame.ThreadDump)
+	}
+	if !strings.HasPrefix(frame.Smaps, "00400000-00401000") || !strings.Contains(frame.SmapsRollup, "[rollup]") || !strings.Contains(frame.Io, "read_bytes") {
+		t.Errorf("process files not captured from testdata/proc")
+	}
+	if len(frame.Tasks) != 3 {
+		t.Errorf("%d tasks captured, expected 3", len(frame.Tasks))
+	}
+	if frame.Diagnostics.HeapInfo == "" || frame.Diagnostics.CodeCache == "" || !strings.Contains(frame.Diagnostics.NativeMemory, "Total:") {
+		t.Errorf("diagnostics = %+v", frame.Diagnostics)
+	}
+
+	perfData, err := hsperf.ParsePerfData(frame.PerfData)
+	if err != nil {
+		t.Fatalf("hsperfdata not captured from testdata/tmp: %s", err)
+	}
+	if perfData.String("java.property.java.vm.name") != "OpenJDK 64-Bit Server VM" || perfData.Long("java.threads.live") != 3 {
+		t.Errorf("hsperfdata = %+v", perfData.Entries)
+	}
+
+	if cpuTicks := frame.CpuTicks(); cpuTicks[4242] != 180 || cpuTicks[4250] != 25 || cpuTicks[4251] != 412 {
+		t.Errorf("CpuTicks = %v", cpuTicks)
+	}
+	rollup, err := frame.Rollup()
+	if err != nil || rollup.Rss != 136020 || rollup.PssAnon != 134248 {
+		t.Errorf("Rollup = %+v, %v", rollup, err)
+	}
+}
+
+func TestBuildSnapshot(t *testing.T) {
+	useTestdataRoots(t)
+
+	frame, err := CaptureFrame(TEST_PID)
+	if err != nil {
+		t.Fatalf("CaptureFrame failed: %s", err)
+	}
+	snapshot, err := BuildSnapshot(TEST_PID, frame)
+	if err != nil {
+		t.Fatalf("BuildSnapshot failed: %s", err)
+	}
+
+	//the GC and periodic task threads have no last Java frame, and are left out of the thread dump
+	if len(snapshot.Threads) != 2 {
+		t.Errorf("%d threads parsed, expected 2", len(snapshot.Threads))
+	}
+
+	type expectedSegment struct {
+		path     string
+		category string
+		taskID   int
+	}
+	var expected = []expectedSegment{
+		{"/usr/lib/jvm/java-17-openjdk/bin/java", CATEGORY_MAPPED_FILE, 0},
+		{"[heap]", CATEGORY_MALLOC_ARENA, 0},
+		{"", CATEGORY_JAVA_HEAP, 0},
+		{"", CATEGORY_MALLOC_ARENA, 0},
+		{"", CATEGORY_CODE_CACHE, 0},
+		{"main", CATEGORY_THREAD_STACK, 4242},
+		{"worker-1", CATEGORY_THREAD_STACK, 4250},
+		{"/usr/lib/jvm/java-17-openjdk/lib/server/libjvm.so", CATEGORY_SHARED_LIBRARY, 0},
+		{"", CATEGORY_OTHER, 0},
+		{"[stack]", CATEGORY_THREAD_STACK, 0},
+	}
+
+	segments := *snapshot.Segments
+	if len(segments) != len(expected) {
+		t.Fatalf("%d segments, expected %d", len(segments), len(expected))
+	}
+	for i, segment := range segments {
+		if segment.Path != expected[i].path || segment.Category != expected[i].category || segment.TaskID != expected[i].taskID {
+			t.Errorf("segment %d = [%s] %s of %d, expected [%s] %s of %d", i, segment.Path, segment.Category, segment.TaskID,
+				expected[i].path, expected[i].category, expected[i].taskID)
+		}
+	}
+
+	main := segments[5]
+	if main.FrameType != "JavaThread" || main.State != "RUNNABLE" || main.CpuTicks != 180 || main.ReadBytes != 4096000 || main.ReadCount != 200 {
+		t.Errorf("main thread = %+v", main)
+	}
+	worker := segments[6]
+	if worker.State != "TIMED_WAITING" || worker.CpuTicks != 25 || worker.WriteBytes != 409600 || worker.WriteCount != 20 {
+		t.Errorf("worker thread = %+v", worker)
+	}
+
+	//NMT detail is not enabled, the summary is used instead
+	if snapshot.Layout.NativeMemory == nil || snapshot.Layout.NativeMemory.Total.Committed != 140000 {
+		t.Errorf("NMT summary = %+v", snapshot.Layout.NativeMemory)
+	}
+	if len(snapshot.Layout.HeapRanges) != 1 || snapshot.Layout.HeapRanges[0] != (AddressRange{0xe0000000, 0x100000000}) {
+		t.Errorf("heap ranges = %+v", snapshot.Layout.HeapRanges)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/bundle"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BundleConfig is the "bundle" section of the rules file, which tells where and what the bundles are captured
+type BundleConfig struct {
+	// default the working directory
+	Dir            string       `json:"dir"`
+	ThreadDumps    int          `json:"threadDumps"`
+	Interval       RuleDuration `json:"interval"`
+	ClassHistogram bool         `json:"classHistogram"`
+	// default true
+	NativeMemory   *bool        `json:"nmt"`
+}
+
+func (this *BundleConfig) options() *bundle.Options {
+	options := bundle.DefaultOptions()
+	if this == nil {
+		return options
+	}
+
+	if this.ThreadDumps > 0 {
+		options.ThreadDumps = this.ThreadDumps
+	}
+	if this.Interval > 0 {
+		options.Interval = time.Duration(this.Interval)
+	}
+	options.ClassHistogram = this.ClassHistogram
+	if this.NativeMemory != nil {
+		options.NativeMemory = *this.NativeMemory
+	}
+
+	return options
+}
+
+func (this *BundleConfig) dir() string {
+	if this == nil || this.Dir == "" {
+		return "."
+	}
+	return this.Dir
+}
+
+// BundleCapturer captures bundles in the background, one at a time per process, as a burst of alerts would otherwise
+// attach to the same JVM over and over
+type BundleCapturer struct {
+	dir        string
+	options    *bundle.Options
+	mutex      sync.Mutex
+	inProgress map[int32]bool
+	running    sync.WaitGroup
+	// outcome of the latest capture, shown by the TUI
+	status     string
+}
+
+func NewBundleCapturer(config *BundleConfig) *BundleCapturer {
+	return &BundleCapturer{dir: config.dir(), options: config.options(), inProgress: make(map[int32]bool)}
+}
+
+// Trigger starts capturing a bundle of pid, unless one is in progress already, and tells whether it did
+func (this *BundleCapturer) Trigger(pid int32, reason string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.inProgress[pid] {
+		return false
+	}
+	this.inProgress[pid] = true
+	this.status = fmt.Sprintf("capturing bundle of %d", pid)
+
+	this.running.Add(1)
+	go func() {
+		defer this.running.Done()
+		path, err := bundle.Capture(pid, this.dir, this.options)
+
+		this.mutex.Lock()
+		defer this.mutex.Unlock()
+		delete(this.inProgress, pid)
+		if err != nil {
+			glog.Errorf("bundle of %d on %s Cause: [%s]", pid, reason, err)
+			this.status = fmt.Sprintf("bundle of %d failed: %s", pid, err)
+			return
+		}
+		glog.Infof("bundle of %d on %s written to %s", pid, reason, path)
+		this.status = fmt.Sprintf("bundle of %d written to %s", pid, path)
+	}()
+
+	return true
+}
+
+// Wait waits for the captures in progress
+func (this *BundleCapturer) Wait() {
+	this.running.Wait()
+}
+
+func (this *BundleCapturer) Status() string {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.status
+}
+
+// runBundle captures a bundle on demand, i.e. `ptop bundle <pid>`
+func runBundle(args []string) error {
+	defaults := bundle.DefaultOptions()
+
+	flags := flag.NewFlagSet("ptop bundle", flag.ContinueOnError)
+	dir := flags.String("dir", ".", "directory the bundle is written to")
+	threadDumps := flags.Int("thread-dumps", defaults.ThreadDumps, "number of thread dumps")
+	interval := flags.Duration("interval", defaults.Interval, "time between two thread dumps")
+	classHistogram := flags.Bool("class-histogram", false, "add GC.class_histogram, which triggers a full GC")
+	nativeMemory := flags.Bool("nmt", defaults.NativeMemory, "add VM.native_memory, if enabled in the JVM")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	if *threadDumps < 1 {
+		return usageErrorf("at least one thread dump is required")
+	}
+
+	options := &bundle.Options{ThreadDumps: *threadDumps, Interval: *interval, ClassHistogram: *classHistogram, NativeMemory: *nativeMemory}
+	fmt.Fprintf(os.Stderr, "capturing bundle of %d, %d thread dumps %s apart\n", parsedPid, options.ThreadDumps, options.Interval)
+	path, err := bundle.Capture(int32(parsedPid), *dir, options)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "%s\n", path)
+	return nil
+}
@@ -0,0 +1,116 @@
+// Package flame aggregates sampled stacks into flame graphs, written either in the folded format of Brendan Gregg's
+// FlameGraph tools or as a standalone interactive SVG.
+package flame
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Profile is a set of stacks, root frame first, with their summed weights
+type Profile struct {
+	weights map[string]int64
+	frames  map[string][]string
+}
+
+func NewProfile() *Profile {
+	return &Profile{weights: make(map[string]int64), frames: make(map[string][]string)}
+}
+
+// Add adds weight to the stack, which frames are given root first. Semicolons, which separate the frames in the
+// folded format, are replaced within a frame.
+func (this *Profile) Add(frames []string, weight int64) {
+	if len(frames) == 0 || weight <= 0 {
+		return
+	}
+
+	var sanitized = make([]string, len(frames))
+	for i, frame := range frames {
+		sanitized[i] = strings.Replace(frame, ";", ":", -1)
+	}
+
+	key := strings.Join(sanitized, ";")
+	this.weights[key] += weight
+	this.frames[key] = sanitized
+}
+
+// Total is the sum of the weights of all stacks
+func (this *Profile) Total() int64 {
+	var total int64 = 0
+	for _, weight := range this.weights {
+		total += weight
+	}
+	return total
+}
+
+// WriteFolded writes one "frame;frame;frame weight" line per stack, sorted by stack
+func (this *Profile) WriteFolded(writer io.Writer) error {
+	var keys []string
+	for key := range this.weights {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(writer, "%s %d\n", key, this.weights[key]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// node is a frame of the merged call tree, its weight including the ones of its descendants
+type node struct {
+	name     string
+	weight   int64
+	children map[string]*node
+}
+
+func (this *node) child(name string) *node {
+	if child, ok := this.children[name]; ok {
+		return child
+	}
+	child := &node{name: name, children: make(map[string]*node)}
+	this.children[name] = child
+	return child
+}
+
+// sortedChildren orders the children by name, as flamegraph.pl does, so that the graphs of two profiles compare
+func (this *node) sortedChildren() []*node {
+	var result []*node
+	for _, child := range this.children {
+		result = append(result, child)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+func (this *node) depth() int {
+	var deepest = 0
+	for _, child := range this.children {
+		if depth := child.depth(); depth > deepest {
+			deepest = depth
+		}
+	}
+	return deepest + 1
+}
+
+func (this *Profile) tree() *node {
+	root := &node{name: "all", children: make(map[string]*node)}
+
+	for key, weight := range this.weights {
+		current := root
+		current.weight += weight
+		for _, frame := range this.frames[key] {
+			current = current.child(frame)
+			current.weight += weight
+		}
+	}
+
+	return root
+}
@@ -0,0 +1,76 @@
+package flame
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestProfileWriteFolded(t *testing.T) {
+	profile := NewProfile()
+	profile.Add([]string{"java.lang.Thread.run", "com.acme.Worker.run", "com.acme.Worker.poll"}, 3)
+	profile.Add([]string{"java.lang.Thread.run", "com.acme.Worker.run"}, 1)
+	profile.Add([]string{"java.lang.Thread.run", "com.acme.Worker.run", "com.acme.Worker.poll"}, 2)
+	//a semicolon would split the frame in two
+	profile.Add([]string{"java.lang.Thread.run", "com.acme.Worker.lambda;1"}, 4)
+	//ignored
+	profile.Add([]string{"java.lang.Thread.run"}, 0)
+	profile.Add(nil, 5)
+
+	var buffer bytes.Buffer
+	if err := profile.WriteFolded(&buffer); err != nil {
+		t.Fatalf("WriteFolded() Cause: [%s]", err)
+	}
+
+	expected := "java.lang.Thread.run;com.acme.Worker.lambda:1 4\n" +
+		"java.lang.Thread.run;com.acme.Worker.run 1\n" +
+		"java.lang.Thread.run;com.acme.Worker.run;com.acme.Worker.poll 5\n"
+	if buffer.String() != expected {
+		t.Errorf("WriteFolded() = %q, expected %q", buffer.String(), expected)
+	}
+	if profile.Total() != 10 {
+		t.Errorf("Total() = %d, expected 10", profile.Total())
+	}
+}
+
+func TestProfileTree(t *testing.T) {
+	profile := NewProfile()
+	profile.Add([]string{"main", "a", "b"}, 3)
+	profile.Add([]string{"main", "a"}, 1)
+	profile.Add([]string{"main", "c"}, 2)
+
+	root := profile.tree()
+	if root.weight != 6 || root.depth() != 4 {
+		t.Fatalf("root weight = %d, depth = %d, expected 6 and 4", root.weight, root.depth())
+	}
+
+	main := root.children["main"]
+	var names []string
+	for _, child := range main.sortedChildren() {
+		names = append(names, child.name)
+	}
+	if strings.Join(names, ",") != "a,c" {
+		t.Errorf("children of main = %v, expected [a c]", names)
+	}
+	if main.children["a"].weight != 4 || main.children["a"].children["b"].weight != 3 || main.children["c"].weight != 2 {
+		t.Errorf("weights of a, a;b and c = %d, %d and %d, expected 4, 3 and 2", main.children["a"].weight,
+			main.children["a"].children["b"].weight, main.children["c"].weight)
+	}
+}
+
+func TestProfileWriteSVG(t *testing.T) {
+	profile := NewProfile()
+	profile.Add([]string{"main", "com.acme.Main.<init>"}, 2)
+
+	var buffer bytes.Buffer
+	if err := profile.WriteSVG(&buffer, "ptop <4242>", "samples"); err != nil {
+		t.Fatalf("WriteSVG() Cause: [%s]", err)
+	}
+
+	svg := buffer.String()
+	for _, expected := range []string{"<svg ", "ptop &lt;4242&gt;", "com.acme.Main.&lt;init&gt;", "</svg>\n"} {
+		if !strings.Contains(svg, expected) {
+			t.Errorf("WriteSVG() does not contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package flame
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"io"
+)
+
+const SVG_WIDTH = 1200
+
+const SVG_FRAME_HEIGHT = 16
+
+const SVG_FONT_SIZE = 12
+
+// average width of a character of the font, to truncate the labels
+const SVG_CHAR_WIDTH = 7
+
+const SVG_PAD = 10
+
+// room for the title on top and for the details of the hovered frame at the bottom
+const SVG_PAD_TOP = 2 * SVG_FRAME_HEIGHT + SVG_PAD
+
+const SVG_PAD_BOTTOM = 2 * SVG_FRAME_HEIGHT
+
+// frames narrower than that are not drawn
+const SVG_MIN_WIDTH = 0.1
+
+// click to zoom into a frame, on the root or on "Reset Zoom" to zoom out; hover to show the details of a frame
+const SVG_SCRIPT = `
+var frames = document.querySelectorAll("g.f");
+var details = document.getElementById("details");
+function fit(g, x, w) {
+	var r = g.querySelector("rect"), t = g.querySelector("text");
+	r.setAttribute("x", x);
+	r.setAttribute("width", w);
+	t.setAttribute("x", x + 3);
+	var name = g.getAttribute("data-n"), chars = Math.floor((w - 6) / %d);
+	t.textContent = chars < 3 ? "" : (name.length <= chars ? name : name.substring(0, chars - 2) + "..");
+}
+function zoom(target) {
+	var x0 = +target.getAttribute("data-x"), w0 = +target.getAttribute("data-w"), d0 = +target.getAttribute("data-d");
+	var scale = (%d - 2 * %d) / w0, epsilon = 1e-6;
+	frames.forEach(function (g) {
+		var x = +g.getAttribute("data-x"), w = +g.getAttribute("data-w"), d = +g.getAttribute("data-d");
+		if (d < d0 && x <= x0 + epsilon && x + w + epsilon >= x0 + w0) {
+			fit(g, %d, %d - 2 * %d);
+			g.style.display = "";
+			g.style.opacity = 0.5;
+		} else if (d >= d0 && x + epsilon >= x0 && x + w <= x0 + w0 + epsilon) {
+			fit(g, %d + (x - x0) * scale, w * scale);
+			g.style.display = w * scale < %g ? "none" : "";
+			g.style.opacity = 1;
+		} else {
+			g.style.display = "none";
+		}
+	});
+}
+frames.forEach(function (g) {
+	g.addEventListener("click", function () { zoom(g); });
+	g.addEventListener("mouseover", function () { details.textContent = g.querySelector("title").textContent; });
+	g.addEventListener("mouseout", function () { details.textContent = " "; });
+});
+document.getElementById("reset").addEventListener("click", function () { zoom(frames[0]); });
+`
+
+// WriteSVG draws the profile as a flame graph, the root at the bottom, unit naming the weights, e.g. "samples" or "ms"
+func (this *Profile) WriteSVG(writer io.Writer, title string, unit string) error {
+	root := this.tree()
+	depth := root.depth()
+	height := SVG_PAD_TOP + depth*SVG_FRAME_HEIGHT + SVG_PAD_BOTTOM
+
+	buffered := bufio.NewWriter(writer)
+
+	fmt.Fprintf(buffered, "<?xml version=\"1.0\" standalone=\"no\"?>\n")
+	fmt.Fprintf(buffered, "<svg version=\"1.1\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" xmlns=\"http://www.w3.org/2000/svg\">\n", SVG_WIDTH, height, SVG_WIDTH, height)
+	fmt.Fprintf(buffered, "<style>text { font-family: Verdana, sans-serif; font-size: %dpx; fill: #000; } g.f { cursor: pointer; } g.f:hover rect { stroke: #000; stroke-width: 0.5; } #reset { cursor: pointer; }</style>\n", SVG_FONT_SIZE)
+	fmt.Fprintf(buffered, "<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#f8f8f8\"/>\n", SVG_WIDTH, height)
+	fmt.Fprintf(buffered, "<text x=\"%d\" y=\"%d\" text-anchor=\"middle\" style=\"font-size: %dpx\">%s</text>\n", SVG_WIDTH/2, SVG_FRAME_HEIGHT+4, SVG_FONT_SIZE+5, html.EscapeString(title))
+	fmt.Fprintf(buffered, "<text id=\"reset\" x=\"%d\" y=\"%d\">Reset Zoom</text>\n", SVG_PAD, SVG_FRAME_HEIGHT+4)
+	fmt.Fprintf(buffered, "<text id=\"details\" x=\"%d\" y=\"%d\"> </text>\n", SVG_PAD, height-SVG_FRAME_HEIGHT/2)
+
+	if root.weight > 0 {
+		scale := float64(SVG_WIDTH-2*SVG_PAD) / float64(root.weight)
+		writeFrame(buffered, root, SVG_PAD, 0, scale, height, root.weight, unit)
+	}
+
+	fmt.Fprintf(buffered, "<script type=\"text/ecmascript\"><![CDATA[%s]]></script>\n", fmt.Sprintf(SVG_SCRIPT, SVG_CHAR_WIDTH,
+		SVG_WIDTH, SVG_PAD, SVG_PAD, SVG_WIDTH, SVG_PAD, SVG_PAD, SVG_MIN_WIDTH))
+	fmt.Fprintf(buffered, "</svg>\n")
+
+	return buffered.Flush()
+}
+
+// writeFrame draws a frame and its descendants, which are laid out left to right from x
+func writeFrame(writer io.Writer, frame *node, x float64, depth int, scale float64, height int, total int64, unit string) {
+	width := float64(frame.weight) * scale
+	if width < SVG_MIN_WIDTH {
+		return
+	}
+	y := height - SVG_PAD_BOTTOM - (depth+1)*SVG_FRAME_HEIGHT
+
+	name := html.EscapeString(frame.name)
+	fmt.Fprintf(writer, "<g class=\"f\" data-n=\"%s\" data-x=\"%.2f\" data-w=\"%.2f\" data-d=\"%d\">", name, x, width, depth)
+	fmt.Fprintf(writer, "<title>%s (%d %s, %.2f%%)</title>", name, frame.weight, html.EscapeString(unit), float64(frame.weight)/float64(total)*100)
+	fmt.Fprintf(writer, "<rect x=\"%.2f\" y=\"%d\" width=\"%.2f\" height=\"%d\" fill=\"%s\" rx=\"2\"/>", x, y, width, SVG_FRAME_HEIGHT-1, frameColor(frame.name))
+	fmt.Fprintf(writer, "<text x=\"%.2f\" y=\"%d\">%s</text></g>\n", x+3, y+SVG_FRAME_HEIGHT-4, html.EscapeString(truncateLabel(frame.name, width)))
+
+	for _, child := range frame.sortedChildren() {
+		writeFrame(writer, child, x, depth+1, scale, height, total, unit)
+		x += float64(child.weight) * scale
+	}
+}
+
+func truncateLabel(name string, width float64) string {
+	chars := int((width - 6) / SVG_CHAR_WIDTH)
+	if chars < 3 {
+		return ""
+	}
+	if len(name) <= chars {
+		return name
+	}
+	return name[:chars-2] + ".."
+}
+
+// frameColor picks a warm color after the name, so that a frame keeps its color across graphs
+func frameColor(name string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	value := hash.Sum32()
+
+	red := 205 + value%50
+	green := (value >> 8) % 230
+	blue := (value >> 16) % 55
+	return fmt.Sprintf("rgb(%d,%d,%d)", red, green, blue)
+}
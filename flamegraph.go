@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mcfongtw/go-ptop/flame"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/shirou/gopsutil/process"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// thread dumps per second, each of them pausing the JVM at a safepoint
+const DEFAULT_FLAME_FREQUENCY = 5
+
+const DEFAULT_FLAME_DURATION = 30 * time.Second
+
+// How the samples are weighted
+const (
+	// one per thread and sample, i.e. wall-clock time
+	FLAME_WEIGHT_SAMPLES = "samples"
+	// likewise, the stacks being rooted at the state of the thread
+	FLAME_WEIGHT_STATE   = "state"
+	// CPU time of the thread since the previous sample in ms, attributed to its current stack
+	FLAME_WEIGHT_CPU     = "cpu"
+)
+
+// StackProfiler folds the stacks of the successive samples of a process
+type StackProfiler struct {
+	weight       string
+	runnableOnly bool
+	profile      *flame.Profile
+}
+
+func NewStackProfiler(weight string, runnableOnly bool) (*StackProfiler, error) {
+	switch weight {
+	case FLAME_WEIGHT_SAMPLES, FLAME_WEIGHT_STATE, FLAME_WEIGHT_CPU:
+	default:
+		return nil, fmt.Errorf("unknown weight [%s], expected %s, %s or %s", weight, FLAME_WEIGHT_SAMPLES, FLAME_WEIGHT_STATE, FLAME_WEIGHT_CPU)
+	}
+
+	return &StackProfiler{weight: weight, runnableOnly: runnableOnly, profile: flame.NewProfile()}, nil
+}
+
+// Add folds the stacks of curr, prev being the sample before, nil for the first one
+func (this *StackProfiler) Add(prev *StackSample, curr *StackSample) {
+	if this.weight == FLAME_WEIGHT_CPU && prev == nil {
+		//the first sample is the baseline of the CPU deltas
+		return
+	}
+
+	for nid, jthread := range curr.Threads {
+		if len(jthread.Frames) == 0 || (this.runnableOnly && jthread.State != jvmdump.THREAD_STATE_RUNNABLE) {
+			continue
+		}
+
+		var weight int64 = 1
+		if this.weight == FLAME_WEIGHT_CPU {
+			weight = int64(cpuSecondsBetween(prev.Counters[nid], curr.Counters[nid]) * 1000)
+		}
+
+		var frames []string
+		if this.weight == FLAME_WEIGHT_STATE {
+			state := jthread.State
+			if state == "" {
+				state = "UNKNOWN"
+			}
+			frames = append(frames, state)
+		}
+		//thread dumps list the innermost frame first
+		for i := len(jthread.Frames) - 1; i >= 0; i-- {
+			frames = append(frames, jvmdump.ParseStackFrame(jthread.Frames[i]).Function)
+		}
+
+		this.profile.Add(frames, weight)
+	}
+}
+
+// Unit names the weights of the profile
+func (this *StackProfiler) Unit() string {
+	if this.weight == FLAME_WEIGHT_CPU {
+		return "ms"
+	}
+	return "samples"
+}
+
+// runFlame samples the stacks of a process and writes them as a flame graph, i.e. `ptop flame -svg out.svg <pid>`
+func runFlame(args []string) error {
+	flags := flag.NewFlagSet("ptop flame", flag.ContinueOnError)
+	frequency := flags.Float64("frequency", DEFAULT_FLAME_FREQUENCY, "thread dumps per second")
+	duration := flags.Duration("duration", DEFAULT_FLAME_DURATION, "time the stacks are sampled over, the sampling stops on interrupt too")
+	weight := flags.String("weight", FLAME_WEIGHT_SAMPLES, "weight of the samples: samples, state or cpu")
+	runnableOnly := flags.Bool("runnable-only", false, "only sample the RUNNABLE threads")
+	foldedPath := flags.String("folded", "", "file the folded stacks are written to, - for stdout")
+	svgPath := flags.String("svg", "", "file the flame graph is written to")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	pid := int32(parsedPid)
+	if *frequency <= 0 {
+		return usageErrorf("frequency must be positive")
+	}
+	if *foldedPath == "" && *svgPath == "" {
+		*foldedPath = "-"
+	}
+
+	profiler, err := NewStackProfiler(*weight, *runnableOnly)
+	if err != nil {
+		return usageError{err}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	fmt.Fprintf(os.Stderr, "sampling process %d at %g Hz for %s\n", pid, *frequency, *duration)
+	startedAt := time.Now()
+	taken, samplingErr := SampleStacks(pid, time.Duration(float64(time.Second) / *frequency), *duration, signals, profiler.Add)
+	if taken == 0 {
+		if samplingErr != nil {
+			return samplingErr
+		}
+		return fmt.Errorf("no sample could be taken")
+	}
+	fmt.Fprintf(os.Stderr, "%d samples taken over %s\n", taken, time.Since(startedAt).Round(time.Second))
+
+	if *foldedPath != "" {
+		if err := writeOutput(*foldedPath, profiler.profile.WriteFolded); err != nil {
+			return err
+		}
+	}
+
+	if *svgPath != "" {
+		var name string
+		if proc, err := process.NewProcess(pid); err == nil {
+			name, _ = proc.Name()
+		}
+		title := fmt.Sprintf("ptop %d (%s), %s weighted, %d samples over %s", pid, name, *weight, taken, time.Since(startedAt).Round(time.Second))
+		if *runnableOnly {
+			title += ", RUNNABLE threads only"
+		}
+		err := writeOutput(*svgPath, func(writer io.Writer) error {
+			return profiler.profile.WriteSVG(writer, title, profiler.Unit())
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	//what was sampled before the process exited is written anyway
+	return samplingErr
+}
+
+// writeOutput has write fill the file at path, or stdout for -
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"testing"
+	"time"
+)
+
+var stackStartedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// stackSamples are two samples a second apart: worker-1 is RUNNABLE and spends 0.5s on CPU in between, worker-2 is
+// WAITING and spends 10ms, and the VM Thread has no Java frame
+func stackSamples() []*StackSample {
+	threads := hotDump(
+		jvmdump.JavaThread{Nid: 10, ThreadName: "worker-1", State: jvmdump.THREAD_STATE_RUNNABLE, Frames: []string{
+			"com.acme.Worker.poll(Worker.java:30)",
+			"com.acme.Worker.run(Worker.java:12)",
+			"java.lang.Thread.run(java.base@17.0.9/Thread.java:833)"}},
+		jvmdump.JavaThread{Nid: 11, ThreadName: "worker-2", State: jvmdump.THREAD_STATE_WAITING, Frames: []string{
+			"java.lang.Object.wait(java.base@17.0.9/Native Method)",
+			"com.acme.Worker.run(Worker.java:12)",
+			"java.lang.Thread.run(java.base@17.0.9/Thread.java:833)"}},
+		jvmdump.JavaThread{Nid: 12, ThreadName: "VM Thread"})
+
+	return []*StackSample{
+		{Timestamp: stackStartedAt, Threads: threads,
+			Counters: map[int]*ThreadSample{10: hotSample(100, 0, 0), 11: hotSample(100, 0, 0), 12: hotSample(100, 0, 0)}},
+		{Timestamp: stackStartedAt.Add(time.Second), Threads: threads,
+			Counters: map[int]*ThreadSample{10: hotSample(150, 0, 0), 11: hotSample(101, 0, 0), 12: hotSample(300, 0, 0)}},
+	}
+}
+
+func TestStackProfiler(t *testing.T) {
+	listOfTests := []struct {
+		weight       string
+		runnableOnly bool
+		expected     string
+	}{
+		{FLAME_WEIGHT_SAMPLES, false,
+			"java.lang.Thread.run;com.acme.Worker.run;com.acme.Worker.poll 2\n" +
+				"java.lang.Thread.run;com.acme.Worker.run;java.lang.Object.wait 2\n"},
+		{FLAME_WEIGHT_SAMPLES, true,
+			"java.lang.Thread.run;com.acme.Worker.run;com.acme.Worker.poll 2\n"},
+		{FLAME_WEIGHT_STATE, false,
+			"RUNNABLE;java.lang.Thread.run;com.acme.Worker.run;com.acme.Worker.poll 2\n" +
+				"WAITING;java.lang.Thread.run;com.acme.Worker.run;java.lang.Object.wait 2\n"},
+		//the first sample is the baseline
+		{FLAME_WEIGHT_CPU, false,
+			"java.lang.Thread.run;com.acme.Worker.run;com.acme.Worker.poll 500\n" +
+				"java.lang.Thread.run;com.acme.Worker.run;java.lang.Object.wait 10\n"},
+	}
+
+	for _, test := range listOfTests {
+		profiler, err := NewStackProfiler(test.weight, test.runnableOnly)
+		if err != nil {
+			t.Fatalf("NewStackProfiler(%s) Cause: [%s]", test.weight, err)
+		}
+
+		var prev *StackSample
+		for _, sample := range stackSamples() {
+			profiler.Add(prev, sample)
+			prev = sample
+		}
+
+		var buffer bytes.Buffer
+		profiler.profile.WriteFolded(&buffer)
+		if buffer.String() != test.expected {
+			t.Errorf("weight %s, runnable only %t: folded = %q, expected %q", test.weight, test.runnableOnly, buffer.String(), test.expected)
+		}
+	}
+
+	if _, err := NewStackProfiler("wall", false); err == nil {
+		t.Errorf("NewStackProfiler(wall) expected an error")
+	}
+}
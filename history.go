@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"time"
+)
+
+// number of samples kept per metric
+const HISTORY_LENGTH = 120
+
+// USER_HZ, the unit of the utime and stime fields of /proc/<pid>/task/<tid>/stat
+const CLOCK_TICKS_PER_SECOND = 100
+
+// MetricHistory is a bounded ring buffer of samples
+type MetricHistory struct {
+	values []float64
+	next   int
+	full   bool
+}
+
+func (this *MetricHistory) Add(value float64) {
+	if this.values == nil {
+		this.values = make([]float64, HISTORY_LENGTH)
+	}
+
+	this.values[this.next] = value
+	this.next = (this.next + 1) % HISTORY_LENGTH
+	if this.next == 0 {
+		this.full = true
+	}
+}
+
+// Values returns the samples, oldest first
+func (this *MetricHistory) Values() []float64 {
+	if !this.full {
+		return append([]float64{}, this.values[:this.next]...)
+	}
+	return append(append([]float64{}, this.values[this.next:]...), this.values[:this.next]...)
+}
+
+// Last returns the latest sample, or 0 if there is none
+func (this *MetricHistory) Last() float64 {
+	if this.next == 0 && !this.full {
+		return 0
+	}
+	return this.values[(this.next+HISTORY_LENGTH-1)%HISTORY_LENGTH]
+}
+
+// ThreadSample is a raw reading of the cumulative counters of a thread
+type ThreadSample struct {
+	CpuTicks   uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+	sampledAt  time.Time
+}
+
+// SampleThread reads the CPU time and I/O counters of a thread
+func SampleThread(pid int32, tid int) (*ThreadSample, error) {
+	cpuTicks, err := procfs.GetThreadCpuTicks(pid, int32(tid))
+	if err != nil {
+		return nil, err
+	}
+
+	ioStat, err := procfs.GetThreadIoStat(pid, int32(tid))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ThreadSample{CpuTicks: cpuTicks, ReadBytes: ioStat.ReadBytes, WriteBytes: ioStat.WriteBytes, sampledAt: time.Now()}, nil
+}
+
+type ThreadHistory struct {
+	// CPU usage in percent of one core
+	Cpu    MetricHistory
+	// read and written bytes per second
+	IoRate MetricHistory
+	// likewise, split by direction
+	ReadRate  MetricHistory
+	WriteRate MetricHistory
+
+	last   *ThreadSample
+}
+
+// ProcessHistory keeps the trends of a process and of its threads
+type ProcessHistory struct {
+	// in kB
+	Rss     MetricHistory
+	Pss     MetricHistory
+	Threads map[int]*ThreadHistory
+}
+
+func NewProcessHistory() *ProcessHistory {
+	return &ProcessHistory{Threads: make(map[int]*ThreadHistory)}
+}
+
+func (this *ProcessHistory) RecordRollup(rollup *smaps.MemoryRollup) {
+	this.Rss.Add(float64(rollup.Rss))
+	this.Pss.Add(float64(rollup.Pss))
+}
+
+// RecordThread turns the cumulative counters of a sample into rates against the previous sample of the same thread
+func (this *ProcessHistory) RecordThread(tid int, sample *ThreadSample) {
+	history, ok := this.Threads[tid]
+	if !ok {
+		history = &ThreadHistory{}
+		this.Threads[tid] = history
+	}
+
+	if last := history.last; last != nil && sample.CpuTicks >= last.CpuTicks &&
+		sample.ReadBytes >= last.ReadBytes && sample.WriteBytes >= last.WriteBytes {
+		elapsed := sample.sampledAt.Sub(last.sampledAt).Seconds()
+		if elapsed > 0 {
+			history.Cpu.Add(float64(sample.CpuTicks-last.CpuTicks) / CLOCK_TICKS_PER_SECOND / elapsed * 100)
+			history.IoRate.Add(float64(sample.ReadBytes-last.ReadBytes+sample.WriteBytes-last.WriteBytes) / elapsed)
+			history.ReadRate.Add(float64(sample.ReadBytes-last.ReadBytes) / elapsed)
+			history.WriteRate.Add(float64(sample.WriteBytes-last.WriteBytes) / elapsed)
+		}
+	}
+	history.last = sample
+}
+
+// ThreadHistory returns the trends of a thread, or an empty history if it has not been sampled yet
+func (this *ProcessHistory) ThreadHistory(tid int) *ThreadHistory {
+	if history, ok := this.Threads[tid]; ok {
+		return history
+	}
+	return &ThreadHistory{}
+}
+
+// Prune forgets the threads which are gone
+func (this *ProcessHistory) Prune(liveTids map[int]bool) {
+	for tid := range this.Threads {
+		if !liveTids[tid] {
+			delete(this.Threads, tid)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/shirou/gopsutil/process"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const DEFAULT_HOT_INTERVAL = 5 * time.Second
+
+const DEFAULT_HOT_SNAPSHOTS = 10
+
+const DEFAULT_HOT_THREADS = 3
+
+// innermost frames a stack is compared and printed by
+const DEFAULT_HOT_FRAMES = 20
+
+const (
+	HOT_SORT_CPU = "cpu"
+	HOT_SORT_IO  = "io"
+)
+
+// StackCount is a stack seen in Count of the snapshots of a thread
+type StackCount struct {
+	Frames []string
+	Count  int
+}
+
+// HotThread is a thread of the report, with what it did over the interval
+type HotThread struct {
+	Tid        int
+	Name       string
+	CpuSeconds float64
+	ReadBytes  uint64
+	WriteBytes uint64
+	// number of snapshots the thread was seen in each state
+	States     map[string]int
+	// most frequent first
+	Stacks     []StackCount
+}
+
+// HotThreadsReport tells which threads of a process were the busiest over an interval and what they were running
+type HotThreadsReport struct {
+	Pid       int32
+	Name      string
+	Hostname  string
+	StartedAt time.Time
+	Elapsed   time.Duration
+	// thread dumps taken over requested
+	Snapshots int
+	Requested int
+	SortBy    string
+	Threads   []HotThread
+}
+
+// CollectHotThreads samples the CPU and I/O counters of every thread of the process over the interval, takes snapshots
+// thread dumps along, and keeps the topN busiest threads by cpu or io
+func CollectHotThreads(pid int32, interval time.Duration, snapshots int, topN int, maxFrames int, sortBy string) (*HotThreadsReport, error) {
+	report := HotThreadsReport{Pid: pid, StartedAt: time.Now(), Requested: snapshots, SortBy: sortBy}
+	if proc, err := process.NewProcess(pid); err == nil {
+		report.Name, _ = proc.Name()
+	}
+	report.Hostname, _ = os.Hostname()
+
+	first, err := SampleTasks(pid)
+	if err != nil {
+		return nil, err
+	}
+	last := first
+
+	var listOfThreadDumps []map[int]jvmdump.JavaThread
+	for i := 0; i < snapshots; i++ {
+		time.Sleep(interval / time.Duration(snapshots))
+
+		sample, err := TakeStackSample(pid)
+		if err != nil {
+			glog.Warningf("TakeStackSample(%d) Cause: [%s]", pid, err)
+			counters, err := SampleTasks(pid)
+			if err != nil {
+				return nil, err
+			}
+			last = counters
+			continue
+		}
+		listOfThreadDumps = append(listOfThreadDumps, sample.Threads)
+		last = sample.Counters
+	}
+	report.Elapsed = time.Since(report.StartedAt)
+	report.Snapshots = len(listOfThreadDumps)
+
+	report.Threads = RankHotThreads(first, last, listOfThreadDumps, topN, maxFrames, sortBy)
+	for i := range report.Threads {
+		if report.Threads[i].Name == "" {
+			report.Threads[i].Name = taskName(pid, report.Threads[i].Tid, nil)
+		}
+	}
+
+	return &report, nil
+}
+
+// RankHotThreads keeps the topN busiest threads by cpu or io between the first and the last samples of the tasks, along
+// with their states and stacks in the thread dumps taken meanwhile. The threads missing from the latest thread dump, e.g.
+// the GC threads, are left unnamed.
+func RankHotThreads(first map[int]*ThreadSample, last map[int]*ThreadSample, listOfThreadDumps []map[int]jvmdump.JavaThread,
+	topN int, maxFrames int, sortBy string) []HotThread {
+	var listOfThreads []HotThread
+	for tid, end := range last {
+		start, ok := first[tid]
+		if !ok {
+			//started during the interval, its counters are all its own
+			start = &ThreadSample{}
+		}
+
+		hotThread := HotThread{Tid: tid, CpuSeconds: cpuSecondsBetween(start, end), States: make(map[string]int)}
+		if end.ReadBytes >= start.ReadBytes && end.WriteBytes >= start.WriteBytes {
+			hotThread.ReadBytes = end.ReadBytes - start.ReadBytes
+			hotThread.WriteBytes = end.WriteBytes - start.WriteBytes
+		}
+		if hotThread.score(sortBy) > 0 {
+			listOfThreads = append(listOfThreads, hotThread)
+		}
+	}
+
+	sort.Slice(listOfThreads, func(i, j int) bool {
+		if listOfThreads[i].score(sortBy) != listOfThreads[j].score(sortBy) {
+			return listOfThreads[i].score(sortBy) > listOfThreads[j].score(sortBy)
+		}
+		return listOfThreads[i].Tid < listOfThreads[j].Tid
+	})
+	if topN > 0 && len(listOfThreads) > topN {
+		listOfThreads = listOfThreads[:topN]
+	}
+
+	var latestThreads map[int]jvmdump.JavaThread
+	if len(listOfThreadDumps) > 0 {
+		latestThreads = listOfThreadDumps[len(listOfThreadDumps)-1]
+	}
+	for i := range listOfThreads {
+		listOfThreads[i].Name = latestThreads[listOfThreads[i].Tid].ThreadName
+		listOfThreads[i].countStacks(listOfThreadDumps, maxFrames)
+	}
+
+	return listOfThreads
+}
+
+func (this *HotThread) score(sortBy string) float64 {
+	if sortBy == HOT_SORT_IO {
+		return float64(this.ReadBytes + this.WriteBytes)
+	}
+	return this.CpuSeconds
+}
+
+// countStacks groups the stacks of the thread across the thread dumps, by their maxFrames innermost frames
+func (this *HotThread) countStacks(listOfThreadDumps []map[int]jvmdump.JavaThread, maxFrames int) {
+	var counts = make(map[string]*StackCount)
+
+	for _, threads := range listOfThreadDumps {
+		jthread, ok := threads[this.Tid]
+		if !ok {
+			continue
+		}
+		if jthread.State != "" {
+			this.States[jthread.State]++
+		}
+
+		frames := jthread.Frames
+		if maxFrames > 0 && len(frames) > maxFrames {
+			frames = frames[:maxFrames]
+		}
+		key := strings.Join(frames, "\n")
+		if _, ok := counts[key]; !ok {
+			counts[key] = &StackCount{Frames: frames}
+		}
+		counts[key].Count++
+	}
+
+	this.Stacks = nil
+	for _, count := range counts {
+		this.Stacks = append(this.Stacks, *count)
+	}
+	sort.Slice(this.Stacks, func(i, j int) bool {
+		if this.Stacks[i].Count != this.Stacks[j].Count {
+			return this.Stacks[i].Count > this.Stacks[j].Count
+		}
+		if len(this.Stacks[i].Frames) != len(this.Stacks[j].Frames) {
+			return len(this.Stacks[i].Frames) > len(this.Stacks[j].Frames)
+		}
+		//so that the report reads the same from one run to the next
+		return strings.Join(this.Stacks[i].Frames, "\n") < strings.Join(this.Stacks[j].Frames, "\n")
+	})
+}
+
+// Write prints the report in the layout of the hot threads API of Elasticsearch
+func (this *HotThreadsReport) Write(writer io.Writer) {
+	elapsed := this.Elapsed.Seconds()
+
+	fmt.Fprintf(writer, "::: {%s}{%d}{%s}\n", this.Name, this.Pid, this.Hostname)
+	fmt.Fprintf(writer, "   Hot threads at %s, interval=%s, snapshots=%d/%d, busiestThreads=%d, sort=%s:\n\n",
+		this.StartedAt.Format(time.RFC3339), this.Elapsed.Round(time.Millisecond), this.Snapshots, this.Requested, len(this.Threads), this.SortBy)
+
+	if len(this.Threads) == 0 {
+		fmt.Fprintf(writer, "   no busy thread\n")
+		return
+	}
+
+	for _, hotThread := range this.Threads {
+		fmt.Fprintf(writer, "   %.1f%% (%.2fs out of %.2fs) cpu usage by thread '%s' (nid %d / 0x%x)\n",
+			hotThread.CpuSeconds/elapsed*100, hotThread.CpuSeconds, elapsed, hotThread.Name, hotThread.Tid, hotThread.Tid)
+		fmt.Fprintf(writer, "     I/O read %s/s, write %s/s\n", StringfyBytes(float64(hotThread.ReadBytes)/elapsed), StringfyBytes(float64(hotThread.WriteBytes)/elapsed))
+
+		if len(hotThread.States) > 0 {
+			var states []string
+			for _, state := range jvmdump.ListOfThreadStates {
+				if count, ok := hotThread.States[state]; ok {
+					states = append(states, fmt.Sprintf("%s %d/%d", state, count, this.Snapshots))
+				}
+			}
+			fmt.Fprintf(writer, "     states: %s\n", strings.Join(states, ", "))
+		}
+
+		if len(hotThread.Stacks) == 0 {
+			fmt.Fprintf(writer, "     no Java stack\n")
+		}
+		for _, stack := range hotThread.Stacks {
+			if len(stack.Frames) == 0 {
+				fmt.Fprintf(writer, "     %d/%d snapshots without Java frame\n", stack.Count, this.Snapshots)
+				continue
+			}
+			fmt.Fprintf(writer, "     %d/%d snapshots sharing following %d elements\n", stack.Count, this.Snapshots, len(stack.Frames))
+			for _, frame := range stack.Frames {
+				fmt.Fprintf(writer, "       %s\n", frame)
+			}
+		}
+		fmt.Fprintf(writer, "\n")
+	}
+}
+
+// runHot prints the busiest threads of a process, i.e. `ptop hot <pid>`
+func runHot(args []string) error {
+	flags := flag.NewFlagSet("ptop hot", flag.ContinueOnError)
+	interval := flags.Duration("interval", DEFAULT_HOT_INTERVAL, "time the threads are sampled over")
+	snapshots := flags.Int("snapshots", DEFAULT_HOT_SNAPSHOTS, "thread dumps taken over the interval")
+	threads := flags.Int("threads", DEFAULT_HOT_THREADS, "number of threads reported, 0 for all busy threads")
+	frames := flags.Int("frames", DEFAULT_HOT_FRAMES, "innermost frames of the stacks compared and printed, 0 for all")
+	sortBy := flags.String("sort", HOT_SORT_CPU, "what the threads are ranked by: cpu or io")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	if *sortBy != HOT_SORT_CPU && *sortBy != HOT_SORT_IO {
+		return usageErrorf("unknown sort [%s], expected %s or %s", *sortBy, HOT_SORT_CPU, HOT_SORT_IO)
+	}
+	if *snapshots < 1 || *interval <= 0 {
+		return usageErrorf("at least one snapshot over a positive interval is required")
+	}
+
+	report, err := CollectHotThreads(int32(parsedPid), *interval, *snapshots, *threads, *frames, *sortBy)
+	if err != nil {
+		return err
+	}
+
+	report.Write(os.Stdout)
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func hotSample(cpuTicks uint64, readBytes uint64, writeBytes uint64) *ThreadSample {
+	return &ThreadSample{CpuTicks: cpuTicks, ReadBytes: readBytes, WriteBytes: writeBytes}
+}
+
+func hotDump(listOfThreads ...jvmdump.JavaThread) map[int]jvmdump.JavaThread {
+	var threads = make(map[int]jvmdump.JavaThread)
+	for _, jthread := range listOfThreads {
+		threads[jthread.Nid] = jthread
+	}
+	return threads
+}
+
+// samples of 5 threads over the interval: 10 is the busiest on CPU, 11 on I/O, 12 started meanwhile, 13 idled and 14
+// is a GC thread, missing from the thread dumps
+var hotFirst = map[int]*ThreadSample{
+	10: hotSample(100, 0, 0),
+	11: hotSample(100, 1000, 1000),
+	13: hotSample(100, 50, 0),
+	14: hotSample(100, 0, 0),
+}
+
+var hotLast = map[int]*ThreadSample{
+	10: hotSample(400, 0, 0),
+	11: hotSample(110, 500000, 201000),
+	12: hotSample(150, 4096, 0),
+	13: hotSample(100, 50, 0),
+	14: hotSample(120, 0, 0),
+}
+
+func TestRankHotThreads(t *testing.T) {
+	var tests = []struct {
+		sortBy   string
+		topN     int
+		expected []HotThread
+	}{
+		{HOT_SORT_CPU, 0, []HotThread{
+			{Tid: 10, Name: "main", CpuSeconds: 3},
+			{Tid: 12, Name: "worker-2", CpuSeconds: 1.5, ReadBytes: 4096},
+			{Tid: 14, CpuSeconds: 0.2},
+			{Tid: 11, Name: "worker-1", CpuSeconds: 0.1, ReadBytes: 499000, WriteBytes: 200000},
+		}},
+		{HOT_SORT_CPU, 2, []HotThread{
+			{Tid: 10, Name: "main", CpuSeconds: 3},
+			{Tid: 12, Name: "worker-2", CpuSeconds: 1.5, ReadBytes: 4096},
+		}},
+		{HOT_SORT_IO, 0, []HotThread{
+			{Tid: 11, Name: "worker-1", CpuSeconds: 0.1, ReadBytes: 499000, WriteBytes: 200000},
+			{Tid: 12, Name: "worker-2", CpuSeconds: 1.5, ReadBytes: 4096},
+		}},
+	}
+
+	dump := hotDump(jvmdump.JavaThread{Nid: 10, ThreadName: "main"}, jvmdump.JavaThread{Nid: 11, ThreadName: "worker-1"},
+		jvmdump.JavaThread{Nid: 12, ThreadName: "worker-2"}, jvmdump.JavaThread{Nid: 13, ThreadName: "idle"})
+
+	for _, test := range tests {
+		var ranked []HotThread
+		for _, hotThread := range RankHotThreads(hotFirst, hotLast, []map[int]jvmdump.JavaThread{dump}, test.topN, 0, test.sortBy) {
+			//states and stacks are tested along with countStacks
+			ranked = append(ranked, HotThread{Tid: hotThread.Tid, Name: hotThread.Name, CpuSeconds: hotThread.CpuSeconds,
+				ReadBytes: hotThread.ReadBytes, WriteBytes: hotThread.WriteBytes})
+		}
+		if !reflect.DeepEqual(ranked, test.expected) {
+			t.Errorf("sort by %s, top %d: threads =\n%+v\nexpected\n%+v", test.sortBy, test.topN, ranked, test.expected)
+		}
+	}
+}
+
+func TestRankHotThreadsStacks(t *testing.T) {
+	spinning := []string{"com.example.Spin.loop(Spin.java:10)", "com.example.Spin.run(Spin.java:5)", "java.lang.Thread.run(Thread.java:833)"}
+	//differs from spinning past its 2 innermost frames only
+	spinningElsewhere := []string{"com.example.Spin.loop(Spin.java:10)", "com.example.Spin.run(Spin.java:5)", "com.example.Main.main(Main.java:3)"}
+	writing := []string{"java.io.FileOutputStream.writeBytes(java.base@17.0.9/Native Method)", "com.example.Spin.flush(Spin.java:20)"}
+
+	listOfThreadDumps := []map[int]jvmdump.JavaThread{
+		hotDump(jvmdump.JavaThread{Nid: 10, ThreadName: "main", State: jvmdump.THREAD_STATE_RUNNABLE, Frames: spinning}),
+		hotDump(jvmdump.JavaThread{Nid: 10, ThreadName: "main", State: jvmdump.THREAD_STATE_RUNNABLE, Frames: writing}),
+		hotDump(jvmdump.JavaThread{Nid: 10, ThreadName: "main", State: jvmdump.THREAD_STATE_BLOCKED, Frames: spinningElsewhere}),
+		//missed by a thread dump, e.g. not at a safepoint yet
+		hotDump(),
+	}
+	last := map[int]*ThreadSample{10: hotSample(400, 0, 0)}
+
+	var tests = []struct {
+		maxFrames int
+		expected  []StackCount
+	}{
+		{2, []StackCount{{Frames: spinning[:2], Count: 2}, {Frames: writing, Count: 1}}},
+		{0, []StackCount{{Frames: spinningElsewhere, Count: 1}, {Frames: spinning, Count: 1}, {Frames: writing, Count: 1}}},
+	}
+
+	for _, test := range tests {
+		ranked := RankHotThreads(hotFirst, last, listOfThreadDumps, 0, test.maxFrames, HOT_SORT_CPU)
+		if len(ranked) != 1 {
+			t.Fatalf("threads = %+v", ranked)
+		}
+		if states := ranked[0].States; !reflect.DeepEqual(states, map[string]int{jvmdump.THREAD_STATE_RUNNABLE: 2, jvmdump.THREAD_STATE_BLOCKED: 1}) {
+			t.Errorf("states = %v", states)
+		}
+
+		//as many times seen, the longer stacks come first
+		if stacks := ranked[0].Stacks; !reflect.DeepEqual(stacks, test.expected) {
+			t.Errorf("%d frames: stacks =\n%+v\nexpected\n%+v", test.maxFrames, stacks, test.expected)
+		}
+	}
+}
+
+func TestHotThreadsReportWrite(t *testing.T) {
+	report := HotThreadsReport{Pid: 4242, Name: "java", Hostname: "host", StartedAt: time.Date(2026, 10, 19, 13, 0, 0, 0, time.UTC),
+		Elapsed: 2 * time.Second, Snapshots: 2, Requested: 3, SortBy: HOT_SORT_CPU,
+		Threads: []HotThread{
+			{Tid: 4242, Name: "main", CpuSeconds: 1.5, ReadBytes: 2048, States: map[string]int{jvmdump.THREAD_STATE_RUNNABLE: 2},
+				Stacks: []StackCount{{Frames: []string{"com.example.Main.spin(Main.java:10)", "com.example.Main.main(Main.java:3)"}, Count: 2}}},
+			{Tid: 4250, Name: "GC Thread#0", CpuSeconds: 0.5, States: map[string]int{}},
+		}}
+
+	var output bytes.Buffer
+	report.Write(&output)
+
+	for _, expected := range []string{
+		"::: {java}{4242}{host}\n",
+		"   Hot threads at 2026-10-19T13:00:00Z, interval=2s, snapshots=2/3, busiestThreads=2, sort=cpu:\n",
+		"   75.0% (1.50s out of 2.00s) cpu usage by thread 'main' (nid 4242 / 0x1092)\n",
+		"     I/O read 1.0 kB/s, write 0.0 B/s\n     states: RUNNABLE 2/2\n",
+		"     2/2 snapshots sharing following 2 elements\n       com.example.Main.spin(Main.java:10)\n       com.example.Main.main(Main.java:3)\n",
+		"   25.0% (0.50s out of 2.00s) cpu usage by thread 'GC Thread#0' (nid 4250 / 0x109a)\n     I/O read 0.0 B/s, write 0.0 B/s\n     no Java stack\n",
+	} {
+		if !strings.Contains(output.String(), expected) {
+			t.Errorf("report misses [%s]:\n%s", expected, output.String())
+		}
+	}
+
+	var empty bytes.Buffer
+	(&HotThreadsReport{Elapsed: time.Second}).Write(&empty)
+	if !strings.Contains(empty.String(), "no busy thread") {
+		t.Errorf("empty report = [%s]", empty.String())
+	}
+}
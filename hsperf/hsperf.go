@@ -0,0 +1,184 @@
+// Package hsperf decodes the hsperfdata files HotSpot JVMs export their performance counters through.
+package hsperf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+)
+
+// hsperfdata files are found at <tmp root>/hsperfdata_<user>/<pid>
+const HSPERFDATA_DIR_PREFIX = "hsperfdata_"
+
+// Layout of the hsperfdata file, see hotspot/share/runtime/perfMemory.hpp and perfData.hpp
+const (
+	PERFDATA_MAGIC         = 0xcafec0c0
+	PERFDATA_MAJOR_VERSION = 2
+
+	PERFDATA_PROLOGUE_SIZE = 32
+	PERFDATA_ENTRY_SIZE    = 20
+)
+
+// Basic types of a PerfData entry, as in the JVM's BasicType signature chars
+const (
+	PERFDATA_TYPE_BYTE = 'B'
+	PERFDATA_TYPE_LONG = 'J'
+)
+
+// Units of a PerfData entry
+const (
+	PERFDATA_UNITS_NONE   = 1
+	PERFDATA_UNITS_BYTES  = 2
+	PERFDATA_UNITS_TICKS  = 3
+	PERFDATA_UNITS_EVENTS = 4
+	PERFDATA_UNITS_STRING = 5
+	PERFDATA_UNITS_HERTZ  = 6
+)
+
+// PerfDataEntry is one counter of the hsperfdata file
+type PerfDataEntry struct {
+	Name        string
+	Type        byte
+	Units       byte
+	Variability byte
+
+	// set for scalar 'J' entries
+	LongValue   int64
+	// set for 'B' vectors, which are NUL terminated strings
+	StringValue string
+}
+
+// PerfData is a snapshot of the performance counters a JVM exports via its hsperfdata file
+type PerfData struct {
+	ModTimeStamp int64
+	Entries      map[string]PerfDataEntry
+}
+
+// GetPerfData reads the counters of the given JVM from /tmp/hsperfdata_<user>/<pid>, without attaching to it
+func GetPerfData(pid int32) (*PerfData, error) {
+	path, err := PerfDataPath(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePerfData(contents)
+}
+
+// PerfDataPath returns the hsperfdata file of the given JVM
+func PerfDataPath(pid int32) (string, error) {
+	matches, _ := filepath.Glob(procfs.TmpPath(HSPERFDATA_DIR_PREFIX + "*", strconv.Itoa(int(pid))))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("hsperfdata of pid %d not found", pid)
+	}
+
+	return matches[0], nil
+}
+
+// ParsePerfData decodes an hsperfdata v2 file
+func ParsePerfData(data []byte) (*PerfData, error) {
+	if len(data) < PERFDATA_PROLOGUE_SIZE {
+		return nil, fmt.Errorf("hsperfdata is truncated: %d bytes", len(data))
+	}
+
+	if magic := binary.BigEndian.Uint32(data[0:4]); magic != PERFDATA_MAGIC {
+		return nil, fmt.Errorf("invalid hsperfdata magic 0x%x", magic)
+	}
+
+	var byteOrder binary.ByteOrder = binary.BigEndian
+	if data[4] != 0 {
+		byteOrder = binary.LittleEndian
+	}
+
+	if major := data[5]; major != PERFDATA_MAJOR_VERSION {
+		return nil, fmt.Errorf("unsupported hsperfdata version %d.%d", major, data[6])
+	}
+
+	//data[7] is the accessible flag, data[8:16] used and overflow sizes
+	perfData := PerfData{Entries: make(map[string]PerfDataEntry)}
+	perfData.ModTimeStamp = int64(byteOrder.Uint64(data[16:24]))
+	entryOffset := int(byteOrder.Uint32(data[24:28]))
+	numEntries := int(byteOrder.Uint32(data[28:32]))
+
+	for i := 0; i < numEntries; i++ {
+		if entryOffset+PERFDATA_ENTRY_SIZE > len(data) {
+			return nil, fmt.Errorf("hsperfdata entry %d is out of bounds", i)
+		}
+		header := data[entryOffset : entryOffset+PERFDATA_ENTRY_SIZE]
+
+		entryLength := int(byteOrder.Uint32(header[0:4]))
+		nameOffset := int(byteOrder.Uint32(header[4:8]))
+		vectorLength := int(byteOrder.Uint32(header[8:12]))
+		dataOffset := int(byteOrder.Uint32(header[16:20]))
+
+		if entryLength <= 0 || entryOffset+entryLength > len(data) {
+			return nil, fmt.Errorf("hsperfdata entry %d has invalid length %d", i, entryLength)
+		}
+		entry := data[entryOffset : entryOffset+entryLength]
+
+		var perfEntry = PerfDataEntry{Type: header[12], Units: header[14], Variability: header[15]}
+
+		if nameOffset >= len(entry) || dataOffset > len(entry) {
+			return nil, fmt.Errorf("hsperfdata entry %d has invalid offsets", i)
+		}
+		perfEntry.Name = cString(entry[nameOffset:])
+
+		switch {
+		case vectorLength == 0 && perfEntry.Type == PERFDATA_TYPE_LONG:
+			if dataOffset+8 > len(entry) {
+				return nil, fmt.Errorf("hsperfdata entry %s is truncated", perfEntry.Name)
+			}
+			perfEntry.LongValue = int64(byteOrder.Uint64(entry[dataOffset : dataOffset+8]))
+		case vectorLength > 0 && perfEntry.Type == PERFDATA_TYPE_BYTE:
+			if dataOffset+vectorLength > len(entry) {
+				return nil, fmt.Errorf("hsperfdata entry %s is truncated", perfEntry.Name)
+			}
+			perfEntry.StringValue = cString(entry[dataOffset : dataOffset+vectorLength])
+		}
+
+		perfData.Entries[perfEntry.Name] = perfEntry
+		entryOffset += entryLength
+	}
+
+	return &perfData, nil
+}
+
+func cString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return string(data[:i])
+	}
+	return string(data)
+}
+
+// Long returns the value of a scalar counter, or 0 if the JVM does not export it
+func (this *PerfData) Long(name string) int64 {
+	return this.Entries[name].LongValue
+}
+
+// String returns the value of a string counter, or an empty string if it is missing
+func (this *PerfData) String(name string) string {
+	return this.Entries[name].StringValue
+}
+
+// Seconds converts a counter in ticks to seconds, using the JVM's high resolution timer frequency
+func (this *PerfData) Seconds(name string) float64 {
+	frequency := this.Long("sun.os.hrt.frequency")
+	if frequency == 0 {
+		return 0
+	}
+	return float64(this.Long(name)) / float64(frequency)
+}
+
+// Has reports whether the JVM exports the given counter, as the set differs between collectors and JDK versions
+func (this *PerfData) Has(name string) bool {
+	_, ok := this.Entries[name]
+	return ok
+}
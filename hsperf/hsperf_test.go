@@ -0,0 +1,170 @@
+package hsperf
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+type testEntry struct {
+	name        string
+	units       byte
+	variability byte
+	long        int64
+	str         string
+}
+
+// buildPerfData lays out an hsperfdata v2 file the way HotSpot does: a prologue, then one entry after the other, each
+// being a header, the NUL terminated name and the 8 bytes aligned data
+func buildPerfData(byteOrder binary.ByteOrder, modTimeStamp int64, listOfEntries []testEntry) []byte {
+	var body []byte
+	for _, entry := range listOfEntries {
+		name := append([]byte(entry.name), 0)
+		dataOffset := align(PERFDATA_ENTRY_SIZE + len(name))
+
+		var dataType byte = PERFDATA_TYPE_LONG
+		var vectorLength = 0
+		var value []byte
+		if entry.str != "" {
+			dataType = PERFDATA_TYPE_BYTE
+			value = append([]byte(entry.str), 0)
+			vectorLength = len(value)
+		} else {
+			value = make([]byte, 8)
+			byteOrder.PutUint64(value, uint64(entry.long))
+		}
+		entryLength := align(dataOffset + len(value))
+
+		buffer := make([]byte, entryLength)
+		byteOrder.PutUint32(buffer[0:4], uint32(entryLength))
+		byteOrder.PutUint32(buffer[4:8], PERFDATA_ENTRY_SIZE)
+		byteOrder.PutUint32(buffer[8:12], uint32(vectorLength))
+		buffer[12] = dataType
+		buffer[14] = entry.units
+		buffer[15] = entry.variability
+		byteOrder.PutUint32(buffer[16:20], uint32(dataOffset))
+		copy(buffer[PERFDATA_ENTRY_SIZE:], name)
+		copy(buffer[dataOffset:], value)
+
+		body = append(body, buffer...)
+	}
+
+	prologue := make([]byte, PERFDATA_PROLOGUE_SIZE)
+	//the magic is always big endian, whatever the byte order of the rest
+	binary.BigEndian.PutUint32(prologue[0:4], PERFDATA_MAGIC)
+	if byteOrder == binary.LittleEndian {
+		prologue[4] = 1
+	}
+	prologue[5] = PERFDATA_MAJOR_VERSION
+	prologue[7] = 1
+	byteOrder.PutUint32(prologue[8:12], uint32(PERFDATA_PROLOGUE_SIZE+len(body)))
+	byteOrder.PutUint64(prologue[16:24], uint64(modTimeStamp))
+	byteOrder.PutUint32(prologue[24:28], PERFDATA_PROLOGUE_SIZE)
+	byteOrder.PutUint32(prologue[28:32], uint32(len(listOfEntries)))
+
+	return append(prologue, body...)
+}
+
+func align(offset int) int {
+	return (offset + 7) &^ 7
+}
+
+var listOfTestEntries = []testEntry{
+	{name: "sun.os.hrt.frequency", units: PERFDATA_UNITS_HERTZ, variability: 1, long: 1000000000},
+	{name: "sun.gc.generation.0.space.0.used", units: PERFDATA_UNITS_BYTES, variability: 3, long: 52428800},
+	{name: "sun.rt.safepointTime", units: PERFDATA_UNITS_TICKS, variability: 2, long: 2500000000},
+	{name: "java.property.java.vm.name", units: PERFDATA_UNITS_STRING, variability: 1, str: "OpenJDK 64-Bit Server VM"},
+	{name: "sun.rt.negative", units: PERFDATA_UNITS_NONE, variability: 3, long: -42},
+}
+
+func TestParsePerfData(t *testing.T) {
+	for _, byteOrder := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		perfData, err := ParsePerfData(buildPerfData(byteOrder, 123456789, listOfTestEntries))
+		if err != nil {
+			t.Fatalf("%s: ParsePerfData failed: %s", byteOrder, err)
+		}
+
+		if perfData.ModTimeStamp != 123456789 {
+			t.Errorf("%s: ModTimeStamp = %d, expected 123456789", byteOrder, perfData.ModTimeStamp)
+		}
+		if len(perfData.Entries) != len(listOfTestEntries) {
+			t.Errorf("%s: %d entries parsed, expected %d", byteOrder, len(perfData.Entries), len(listOfTestEntries))
+		}
+
+		for _, expected := range listOfTestEntries {
+			entry, ok := perfData.Entries[expected.name]
+			if !ok {
+				t.Errorf("%s: entry %s is missing", byteOrder, expected.name)
+				continue
+			}
+			if entry.Units != expected.units || entry.Variability != expected.variability {
+				t.Errorf("%s: entry %s has units %d and variability %d, expected %d and %d", byteOrder, expected.name,
+					entry.Units, entry.Variability, expected.units, expected.variability)
+			}
+			if expected.str != "" {
+				if entry.Type != PERFDATA_TYPE_BYTE || perfData.String(expected.name) != expected.str {
+					t.Errorf("%s: entry %s = %q (type %c), expected %q", byteOrder, expected.name, entry.StringValue, entry.Type, expected.str)
+				}
+			} else if entry.Type != PERFDATA_TYPE_LONG || perfData.Long(expected.name) != expected.long {
+				t.Errorf("%s: entry %s = %d (type %c), expected %d", byteOrder, expected.name, entry.LongValue, entry.Type, expected.long)
+			}
+		}
+
+		if seconds := perfData.Seconds("sun.rt.safepointTime"); seconds != 2.5 {
+			t.Errorf("%s: Seconds(sun.rt.safepointTime) = %g, expected 2.5", byteOrder, seconds)
+		}
+		if perfData.Has("sun.rt.missing") || perfData.Long("sun.rt.missing") != 0 {
+			t.Errorf("%s: a missing counter is reported", byteOrder)
+		}
+	}
+}
+
+func TestParsePerfDataInvalid(t *testing.T) {
+	valid := buildPerfData(binary.LittleEndian, 0, listOfTestEntries)
+
+	badMagic := append([]byte(nil), valid...)
+	badMagic[0] = 0
+	badVersion := append([]byte(nil), valid...)
+	badVersion[5] = 1
+	badEntryLength := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badEntryLength[PERFDATA_PROLOGUE_SIZE:], 0)
+	badNameOffset := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badNameOffset[PERFDATA_PROLOGUE_SIZE+4:], 0xffffffff)
+	badDataOffset := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(badDataOffset[PERFDATA_PROLOGUE_SIZE+16:], 0xfffffff0)
+	tooManyEntries := append([]byte(nil), valid...)
+	binary.LittleEndian.PutUint32(tooManyEntries[28:32], uint32(len(listOfTestEntries)+1))
+
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", nil, "truncated"},
+		{"prologue only", valid[:PERFDATA_PROLOGUE_SIZE-1], "truncated"},
+		{"magic", badMagic, "magic"},
+		{"version", badVersion, "version"},
+		{"entry length", badEntryLength, "invalid length"},
+		{"name offset", badNameOffset, "invalid offsets"},
+		{"data offset", badDataOffset, "invalid offsets"},
+		{"entry count", tooManyEntries, "out of bounds"},
+	}
+
+	for _, test := range tests {
+		_, err := ParsePerfData(test.data)
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("%s: error = %v, expected one about %q", test.name, err, test.expected)
+		}
+	}
+}
+
+// TestParsePerfDataTruncated parses every prefix of a valid file, as seen while the JVM is starting or exiting
+func TestParsePerfDataTruncated(t *testing.T) {
+	valid := buildPerfData(binary.BigEndian, 0, listOfTestEntries)
+
+	for length := 0; length < len(valid); length++ {
+		if _, err := ParsePerfData(valid[:length]); err == nil {
+			t.Errorf("%d of %d bytes: no error", length, len(valid))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+// Package util holds helpers shared by the parsers of ptop.
+package util
+
+import (
+	"regexp"
+)
+
+// ParseRegexByGroup matches expr against regEx and returns the submatches by group name, or an empty map if it does not match
+func ParseRegexByGroup(regEx, expr string) (paramsMap map[string]string) {
+	return ParseCompiledRegexByGroup(regexp.MustCompile(regEx), expr)
+}
+
+// ParseCompiledRegexByGroup is ParseRegexByGroup for a regex compiled once, as by the parsers run on every line of a file
+func ParseCompiledRegexByGroup(compRegEx *regexp.Regexp, expr string) (paramsMap map[string]string) {
+	match := compRegEx.FindStringSubmatch(expr)
+
+	paramsMap = make(map[string]string)
+	for i, name := range compRegEx.SubexpNames() {
+		if i > 0 && i <= len(match) {
+			paramsMap[name] = match[i]
+		}
+	}
+	return paramsMap
+}
@@ -0,0 +1,121 @@
+package jvmdump
+
+import (
+	"sort"
+	"strings"
+)
+
+// number of innermost frames two stacks are compared by
+const DEFAULT_STUCK_TOP_FRAMES = 5
+
+// number of successive thread dumps a stack must stay the same in for its thread to be stuck
+const DEFAULT_STUCK_DUMPS = 3
+
+// Kinds of change of a thread between two thread dumps
+const (
+	THREAD_NEW           = "new"
+	THREAD_EXITED        = "exited"
+	THREAD_STATE_CHANGED = "state changed"
+)
+
+// order the kinds of change are listed in
+var listOfThreadChanges = []string{THREAD_NEW, THREAD_EXITED, THREAD_STATE_CHANGED}
+
+// ThreadChange is a thread which appeared, exited or changed state between two thread dumps
+type ThreadChange struct {
+	// the current thread, or the previous one if exited
+	Thread    JavaThread
+	Change    string
+	// empty for a new thread
+	PrevState string
+}
+
+// DiffThreadDumps compares the threads of two thread dumps, as returned by ParseThreadDump, matched by nid. Unchanged
+// threads are left out; new threads come first, then exited ones, then the ones whose state changed.
+func DiffThreadDumps(prev map[int]JavaThread, curr map[int]JavaThread) []ThreadChange {
+	var result []ThreadChange
+
+	for nid, jthread := range curr {
+		prevThread, ok := prev[nid]
+		if !ok {
+			result = append(result, ThreadChange{Thread: jthread, Change: THREAD_NEW})
+		} else if prevThread.State != jthread.State {
+			result = append(result, ThreadChange{Thread: jthread, Change: THREAD_STATE_CHANGED, PrevState: prevThread.State})
+		}
+	}
+
+	for nid, jthread := range prev {
+		if _, ok := curr[nid]; !ok {
+			result = append(result, ThreadChange{Thread: jthread, Change: THREAD_EXITED, PrevState: jthread.State})
+		}
+	}
+
+	var order = make(map[string]int)
+	for i, change := range listOfThreadChanges {
+		order[change] = i
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Change != result[j].Change {
+			return order[result[i].Change] < order[result[j].Change]
+		}
+		return result[i].Thread.Nid < result[j].Thread.Nid
+	})
+
+	return result
+}
+
+// StuckDetector follows the stacks of the threads across successive thread dumps. A thread is stuck when its top
+// frames stayed the same in the last dumps while it was RUNNABLE or BLOCKED; waiting threads are left out, as the idle
+// threads of a pool never change either.
+type StuckDetector struct {
+	topFrames int
+	dumps     int
+	// top frames of the thread and the number of successive dumps they were seen in, by nid
+	stacks    map[int]string
+	counts    map[int]int
+}
+
+func NewStuckDetector(topFrames int, dumps int) *StuckDetector {
+	return &StuckDetector{topFrames: topFrames, dumps: dumps, stacks: make(map[int]string), counts: make(map[int]int)}
+}
+
+// Record follows the threads of a new thread dump, the threads which exited are forgotten
+func (this *StuckDetector) Record(threads map[int]JavaThread) {
+	for nid := range this.stacks {
+		if _, ok := threads[nid]; !ok {
+			delete(this.stacks, nid)
+			delete(this.counts, nid)
+		}
+	}
+
+	for nid, jthread := range threads {
+		frames := jthread.Frames
+		if len(frames) > this.topFrames {
+			frames = frames[:this.topFrames]
+		}
+		stack := strings.Join(frames, "\n")
+
+		if busy := jthread.State == THREAD_STATE_RUNNABLE || jthread.State == THREAD_STATE_BLOCKED; !busy || len(frames) == 0 {
+			delete(this.stacks, nid)
+			delete(this.counts, nid)
+			continue
+		}
+
+		if previous, ok := this.stacks[nid]; ok && previous == stack {
+			this.counts[nid]++
+		} else {
+			this.stacks[nid] = stack
+			this.counts[nid] = 1
+		}
+	}
+}
+
+// Stuck tells whether the thread kept its top frames over the last dumps
+func (this *StuckDetector) Stuck(nid int) bool {
+	return this.counts[nid] >= this.dumps
+}
+
+// Dumps returns the number of successive dumps the thread kept its top frames in
+func (this *StuckDetector) Dumps(nid int) int {
+	return this.counts[nid]
+}
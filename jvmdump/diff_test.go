@@ -0,0 +1,91 @@
+package jvmdump
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testThread(nid int, name string, state string, frames ...string) JavaThread {
+	return JavaThread{Nid: nid, ThreadName: name, State: state, Frames: frames}
+}
+
+func threadsOf(listOfThreads ...JavaThread) map[int]JavaThread {
+	var threads = make(map[int]JavaThread)
+	for _, jthread := range listOfThreads {
+		threads[jthread.Nid] = jthread
+	}
+	return threads
+}
+
+func TestDiffThreadDumps(t *testing.T) {
+	prev := threadsOf(
+		testThread(1, "main", THREAD_STATE_RUNNABLE),
+		testThread(2, "worker-1", THREAD_STATE_WAITING),
+		testThread(3, "worker-2", THREAD_STATE_RUNNABLE),
+		testThread(7, "worker-3", THREAD_STATE_BLOCKED),
+		testThread(9, "worker-4", THREAD_STATE_TIMED_WAITING))
+	curr := threadsOf(
+		testThread(1, "main", THREAD_STATE_RUNNABLE),
+		testThread(2, "worker-1", THREAD_STATE_RUNNABLE),
+		testThread(3, "worker-2", THREAD_STATE_BLOCKED),
+		testThread(8, "worker-5", THREAD_STATE_NEW),
+		testThread(4, "worker-6", THREAD_STATE_RUNNABLE))
+
+	expected := []ThreadChange{
+		{Thread: curr[4], Change: THREAD_NEW},
+		{Thread: curr[8], Change: THREAD_NEW},
+		{Thread: prev[7], Change: THREAD_EXITED, PrevState: THREAD_STATE_BLOCKED},
+		{Thread: prev[9], Change: THREAD_EXITED, PrevState: THREAD_STATE_TIMED_WAITING},
+		{Thread: curr[2], Change: THREAD_STATE_CHANGED, PrevState: THREAD_STATE_WAITING},
+		{Thread: curr[3], Change: THREAD_STATE_CHANGED, PrevState: THREAD_STATE_RUNNABLE},
+	}
+
+	if changes := DiffThreadDumps(prev, curr); !reflect.DeepEqual(changes, expected) {
+		t.Errorf("changes =\n%+v\nexpected\n%+v", changes, expected)
+	}
+	if changes := DiffThreadDumps(curr, curr); len(changes) != 0 {
+		t.Errorf("changes between identical dumps = %+v", changes)
+	}
+}
+
+func TestStuckDetector(t *testing.T) {
+	detector := NewStuckDetector(2, 3)
+
+	spinning := testThread(1, "spinning", THREAD_STATE_RUNNABLE, "com.example.Spin.loop(Spin.java:10)", "com.example.Spin.run(Spin.java:5)")
+	//the frames past the 2 innermost ones do not matter
+	deeper := testThread(1, "spinning", THREAD_STATE_RUNNABLE, "com.example.Spin.loop(Spin.java:10)", "com.example.Spin.run(Spin.java:5)",
+		"java.lang.Thread.run(Thread.java:833)")
+	moved := testThread(1, "spinning", THREAD_STATE_RUNNABLE, "com.example.Spin.loop(Spin.java:11)", "com.example.Spin.run(Spin.java:5)")
+	idle := testThread(2, "idle", THREAD_STATE_WAITING, "java.lang.Object.wait(Native Method)")
+	sleeping := testThread(3, "sleeping", THREAD_STATE_TIMED_WAITING, "java.lang.Thread.sleep(Native Method)")
+	locked := testThread(4, "locked", THREAD_STATE_BLOCKED, "com.example.Cache.get(Cache.java:20)")
+
+	var steps = []struct {
+		threads  map[int]JavaThread
+		expected map[int]int
+	}{
+		{threadsOf(spinning, idle, sleeping, locked), map[int]int{1: 1, 2: 0, 3: 0, 4: 1}},
+		{threadsOf(deeper, idle, sleeping, locked), map[int]int{1: 2, 2: 0, 3: 0, 4: 2}},
+		//stuck after 3 identical dumps, waiting threads never are
+		{threadsOf(spinning, idle, sleeping, locked), map[int]int{1: 3, 2: 0, 3: 0, 4: 3}},
+		//the top frames changed, the count starts over
+		{threadsOf(moved, idle, sleeping, locked), map[int]int{1: 1, 2: 0, 3: 0, 4: 4}},
+		//locked exited, its nid is forgotten
+		{threadsOf(moved, idle, sleeping), map[int]int{1: 2, 2: 0, 3: 0, 4: 0}},
+		//and a new thread reusing it starts from scratch
+		{threadsOf(moved, idle, sleeping, testThread(4, "reused", THREAD_STATE_BLOCKED, "com.example.Cache.get(Cache.java:20)")),
+			map[int]int{1: 3, 2: 0, 3: 0, 4: 1}},
+	}
+
+	for i, step := range steps {
+		detector.Record(step.threads)
+		for nid, dumps := range step.expected {
+			if detector.Dumps(nid) != dumps || detector.Stuck(nid) != (dumps >= 3) {
+				t.Errorf("dump %d: thread %d seen in %d dumps, stuck %v, expected %d", i, nid, detector.Dumps(nid), detector.Stuck(nid), dumps)
+			}
+		}
+	}
+	if len(detector.stacks) != 2 || len(detector.counts) != 2 {
+		t.Errorf("%d stacks and %d counts followed, expected 2 of the RUNNABLE and BLOCKED threads", len(detector.stacks), len(detector.counts))
+	}
+}
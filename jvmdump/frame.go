@@ -0,0 +1,66 @@
+package jvmdump
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StackFrame is a frame of a stack, e.g. "java.lang.Thread.sleep(java.base@17.0.2/Native Method)" as jstack prints it,
+// "java.base@17.0.2/java.lang.Thread.sleep(Native Method)" as StackTraceElement does, or "com.acme.Main.run(Main.java:12)"
+type StackFrame struct {
+	// module and version, since JDK 9, e.g. "java.base@17.0.2"
+	Module   string
+	// class and method, e.g. "java.lang.Thread.sleep"
+	Function string
+	// source file, empty for native methods or when unknown
+	File     string
+	// 0 when unknown
+	Line     int
+}
+
+// ParseStackFrame splits a frame of JavaThread.Frames into its parts. A frame it cannot make sense of is kept whole as the function.
+func ParseStackFrame(frame string) StackFrame {
+	result := StackFrame{Function: frame}
+
+	open := strings.LastIndex(frame, "(")
+	if open < 0 || !strings.HasSuffix(frame, ")") {
+		return result
+	}
+	location := frame[open+1 : len(frame)-1]
+	result.Function = frame[:open]
+
+	//jstack puts the module before the file, StackTraceElement before the class
+	result.Module, location = splitModule(location)
+	if module, function := splitModule(result.Function); module != "" {
+		result.Module, result.Function = module, function
+	}
+
+	switch {
+	case location == "Native Method" || location == "Unknown Source":
+	case strings.Contains(location, ":"):
+		colon := strings.LastIndex(location, ":")
+		result.File = location[:colon]
+		result.Line, _ = strconv.Atoi(location[colon+1:])
+	default:
+		result.File = location
+	}
+
+	return result
+}
+
+// splitModule splits the module prefix off a class name or a file. The prefix ends at the last slash, but for the
+// "/0x..." suffix of hidden classes, e.g. "com.acme.Main$$Lambda$14/0x0000000800c03000". A class loaded by a named
+// class loader outside of any module is prefixed with "app//", which is kept as "app".
+func splitModule(path string) (string, string) {
+	for end := len(path); end > 0; {
+		slash := strings.LastIndex(path[:end], "/")
+		if slash < 0 {
+			break
+		}
+		if !strings.HasPrefix(path[slash+1:], "0x") {
+			return strings.TrimSuffix(path[:slash], "/"), path[slash+1:]
+		}
+		end = slash
+	}
+	return "", path
+}
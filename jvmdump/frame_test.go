@@ -0,0 +1,37 @@
+package jvmdump
+
+import (
+	"testing"
+)
+
+func TestParseStackFrame(t *testing.T) {
+	listOfTests := []struct {
+		frame    string
+		expected StackFrame
+	}{
+		{"com.acme.Main.run(Main.java:12)",
+			StackFrame{Function: "com.acme.Main.run", File: "Main.java", Line: 12}},
+		{"java.lang.Thread.sleep(java.base@17.0.9/Native Method)",
+			StackFrame{Module: "java.base@17.0.9", Function: "java.lang.Thread.sleep"}},
+		{"java.io.FileInputStream.read(java.base@17.0.9/FileInputStream.java:276)",
+			StackFrame{Module: "java.base@17.0.9", Function: "java.io.FileInputStream.read", File: "FileInputStream.java", Line: 276}},
+		{"java.base@17.0.2/java.lang.Thread.sleep(Native Method)",
+			StackFrame{Module: "java.base@17.0.2", Function: "java.lang.Thread.sleep"}},
+		{"app//com.acme.Main.run(Main.java:12)",
+			StackFrame{Module: "app", Function: "com.acme.Main.run", File: "Main.java", Line: 12}},
+		{"com.acme.Main$$Lambda$14/0x0000000800c03000.run(Unknown Source)",
+			StackFrame{Function: "com.acme.Main$$Lambda$14/0x0000000800c03000.run"}},
+		{"jdk.internal.reflect.GeneratedMethodAccessor1.invoke(Unknown Source)",
+			StackFrame{Function: "jdk.internal.reflect.GeneratedMethodAccessor1.invoke"}},
+		{"com.acme.Main.run(Main.java)",
+			StackFrame{Function: "com.acme.Main.run", File: "Main.java"}},
+		{"- locked <0x000000076ab62208> (a java.lang.Object",
+			StackFrame{Function: "- locked <0x000000076ab62208> (a java.lang.Object"}},
+	}
+
+	for _, test := range listOfTests {
+		if actual := ParseStackFrame(test.frame); actual != test.expected {
+			t.Errorf("ParseStackFrame(%q) = %+v, expected %+v", test.frame, actual, test.expected)
+		}
+	}
+}
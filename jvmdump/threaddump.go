@@ -0,0 +1,141 @@
+// Package jvmdump parses the thread dumps of HotSpot JVMs, as returned over the attach socket or written by jstack.
+package jvmdump
+
+import (
+	"bufio"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/internal/util"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// JavaThread is a thread of a thread dump, with its native id and the address of its last Java frame
+type JavaThread struct {
+	ThreadName	 string
+
+	//id of the kernel thread
+	Nid 		 int
+
+	//address of the JavaThread structure in the JVM
+	Tid 		 string
+
+	//address of the last Java frame on the stack
+	StackPtr	 uint64
+
+	//java.lang.Thread.State, e.g. RUNNABLE or BLOCKED, empty if the dump does not tell
+	State		 string
+
+	//frames of the stack, innermost first, e.g. "java.lang.Thread.sleep(Native Method)"
+	Frames		 []string
+}
+
+// Thread states, as printed on the java.lang.Thread.State line of a thread dump
+const (
+	THREAD_STATE_NEW           = "NEW"
+	THREAD_STATE_RUNNABLE      = "RUNNABLE"
+	THREAD_STATE_BLOCKED       = "BLOCKED"
+	THREAD_STATE_WAITING       = "WAITING"
+	THREAD_STATE_TIMED_WAITING = "TIMED_WAITING"
+	THREAD_STATE_TERMINATED    = "TERMINATED"
+)
+
+// display order of the thread states
+var ListOfThreadStates = []string{THREAD_STATE_NEW, THREAD_STATE_RUNNABLE, THREAD_STATE_BLOCKED, THREAD_STATE_WAITING,
+	THREAD_STATE_TIMED_WAITING, THREAD_STATE_TERMINATED}
+
+//nid is printed in hex up to JDK 18, in decimal since
+const THREAD_REGEX = `\"(?P<threadName>[^\"]+)\".*tid=(?P<tid>0x[0-9a-f]+).*nid=(?P<nid>(?:0x[0-9a-f]+|[0-9]+)).*\[(?P<stackPtr>0x[0-9a-f]+)\]`
+
+//follows the header line of a thread, e.g. "   java.lang.Thread.State: WAITING (parking)"
+const THREAD_STATE_REGEX = `^\s+java\.lang\.Thread\.State: (?P<state>[A-Z_]+)`
+
+//compiled once, as they are matched against every line of every thread dump
+var threadRegex = regexp.MustCompile(THREAD_REGEX)
+var threadStateRegex = regexp.MustCompile(THREAD_STATE_REGEX)
+
+//prefix of the frame lines of a stack, once the indentation is trimmed
+const THREAD_FRAME_PREFIX = "at "
+
+//trailing counter of the threads of a pool, e.g. "-12" in "http-nio-8080-exec-12" or "0" in "C2 CompilerThread0"
+var threadCounterRegex = regexp.MustCompile(`[-_#: ]*[0-9]+$`)
+
+// ThreadPool names the pool a thread belongs to after its name, by stripping the trailing counter, so that
+// "pool-1-thread-3" gives "pool-1-thread". Threads without a counter are pools of their own.
+func ThreadPool(threadName string) string {
+	pool := threadCounterRegex.ReplaceAllString(threadName, "")
+	if pool == "" {
+		return threadName
+	}
+	return pool
+}
+
+// ParseThreadDump reads a thread dump and returns the threads by nid
+func ParseThreadDump(reader io.Reader) (map[int]JavaThread, error) {
+	var result = make(map[int]JavaThread)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	//nid of the thread whose stack is being read, -1 if its header was not parsed
+	lastNid := -1
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			if lastNid < 0 {
+				continue
+			}
+			jthread := result[lastNid]
+			if params := util.ParseCompiledRegexByGroup(threadStateRegex, line); len(params) > 0 {
+				jthread.State = params["state"]
+			} else if frame := strings.TrimSpace(line); strings.HasPrefix(frame, THREAD_FRAME_PREFIX) {
+				jthread.Frames = append(jthread.Frames, strings.TrimPrefix(frame, THREAD_FRAME_PREFIX))
+			}
+			result[lastNid] = jthread
+			continue
+		}
+		//a stack ends with a blank line or the header of the next thread
+		lastNid = -1
+
+		params := util.ParseCompiledRegexByGroup(threadRegex, line)
+		if len(params) > 0 {
+
+			assembleJavaThreadInfo := func (paramsMap map[string]string) (JavaThread, error) {
+				jthread := JavaThread{}
+
+				jthread.ThreadName = paramsMap["threadName"]
+				jthread.Tid = paramsMap["tid"]
+				//nid is the kernel tid, which is not bounded by 16 bits
+				nid, err := strconv.ParseUint(paramsMap["nid"], 0, 32)
+				if err != nil {
+					glog.V(3).Infof("Parsing jthread.Nid has failed: ", err)
+					return jthread, err
+				}
+				jthread.Nid = int(nid)
+				jthread.StackPtr, err = strconv.ParseUint(paramsMap["stackPtr"], 0, 64)
+				if err != nil {
+					glog.V(3).Infof("Parsing jthread.StackPtr has failed: ", err)
+					return jthread, err
+				}
+				return jthread, nil
+			}
+
+			glog.V(0).Infof("%s\n", line)
+
+			javaThread, err := assembleJavaThreadInfo(params)
+			if err != nil {
+				continue
+			}
+
+			result[javaThread.Nid] = javaThread
+			lastNid = javaThread.Nid
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
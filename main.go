@@ -4,21 +4,41 @@ import (
 	"flag"
 	"fmt"
 	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/procfs"
 	"os"
-	"strconv"
 )
 
 const DEFAULT_PROFILE_INTERVAL_IN_SECOND = 10
 
+const DEFAULT_RECORD_INTERVAL_IN_SECOND = 60
+
+// a snapshot attaches to the JVM, i.e. a thread dump at a safepoint and a few jcmd, hence at most once a minute by
+// default, as in the TUI
+const DEFAULT_SNAPSHOT_INTERVAL_IN_SECOND = 60
+
+//the TUI needs a non-zero pid, while a thread dump does not tell which process it was taken from
+const ANALYZE_DEFAULT_PID = 1
+
+// the subcommands, i.e. `ptop <command> <args>`, ptop without any of them being the TUI
+var commands = map[string]func([]string) error{
+	"list":    runList,
+	"record":  runRecord,
+	"replay":  runReplay,
+	"analyze": runAnalyze,
+	"serve":   runServe,
+	"otlp":    runOtlp,
+	"web":     runWeb,
+	"watch":   runWatch,
+	"bundle":  runBundle,
+	"hot":     runHot,
+	"flame":   runFlame,
+	"pprof":   runPprof,
+}
+
 func main() {
 
 	args := os.Args
 
-	if len(args) < 2 {
-		printUsage()
-		return
-	}
-
 	/*
 	  Ref: https://github.com/openshift/autoheal/pull/31/commits/d6f3c88cccea70c14b151f9163d267224aeb2acc
 	  This is needed to make `glog` believe that the flags have already been parsed, otherwise every log messages is prefixed by an error message stating the the flags haven't been
@@ -26,17 +46,71 @@ func main() {
 	*/
 	flag.CommandLine.Parse([]string{})
 
-	var parsedPid,_ = strconv.ParseInt(args[1], 10, 32)
-	var pid = int32(parsedPid)
+	procfs.ConfigureRootsFromEnv()
+
+	if len(args) >= 2 {
+		if command, ok := commands[args[1]]; ok {
+			err := command(args[2:])
+			glog.Flush()
+			if err == errAlertFired {
+				os.Exit(EXIT_CODE_ALERT)
+			}
+			if err != nil && err != flag.ErrHelp {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+				//a failure of the command itself is not a matter of usage
+				if _, ok := err.(usageError); ok {
+					printUsage()
+				}
+			}
+			return
+		}
+	}
+
+	var pids []int32
+	var alertConfig *AlertConfig
+	var stuckOptions = defaultStuckOptions()
+	if len(args) < 2 {
+		pids = runPicker()
+		if len(pids) == 0 {
+			return
+		}
+	} else {
+		var err error
+		pids, alertConfig, stuckOptions, err = parseTargets(args[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			printUsage()
+			return
+		}
+	}
 
-	tuiLoop(pid)
+	tuiLoop(pids, &LiveSource{}, alertConfig, stuckOptions)
 
 	//TODO: reoorg logger configuration, i.e. default log directory location etc
 	glog.Flush()
 }
 
 func printUsage() {
-	fmt.Fprintf(os.Stdout, "ptop <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop [-name <regex>] [-rules <file>] [-stuck-dumps <n>] [-stuck-frames <n>] [<pid> ...]\n")
+	fmt.Fprintf(os.Stdout, "ptop list\n")
+	fmt.Fprintf(os.Stdout, "ptop record [-interval <duration>] [-count <n>] -o <file> <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop replay [-stuck-dumps <n>] [-stuck-frames <n>] <file>\n")
+	fmt.Fprintf(os.Stdout, "ptop analyze -threaddump <file> -smaps <file> [-pid <pid>] [-print]\n")
+	fmt.Fprintf(os.Stdout, "ptop serve [-listen <addr>] [-thread-labels name|pool|none] [-max-threads <n>] [-min-interval <duration>] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop otlp [-protocol grpc|http] [-endpoint <host:port>] [-insecure] [-interval <duration>]\n")
+	fmt.Fprintf(os.Stdout, "          [-service-name <name>] [-host <name>] [-container-id <id>] [-resource <key=value,...>]\n")
+	fmt.Fprintf(os.Stdout, "          [-thread-labels name|pool|none] [-max-threads <n>] [-min-interval <duration>] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop web [-listen <addr>] [-interval <duration>] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop watch -rules <file> [-interval <duration>] [-snapshot-interval <duration>] [-count <n>] [-exit-on-alert] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop bundle [-dir <dir>] [-thread-dumps <n>] [-interval <duration>] [-class-histogram] [-nmt=false] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop hot [-interval <duration>] [-snapshots <n>] [-threads <n>] [-frames <n>] [-sort cpu|io] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop flame [-frequency <hz>] [-duration <duration>] [-weight samples|state|cpu] [-runnable-only]\n")
+	fmt.Fprintf(os.Stdout, "           [-folded <file>|-] [-svg <file>] <pid>\n")
+	fmt.Fprintf(os.Stdout, "ptop pprof [-frequency <hz>] [-duration <duration>] [-runnable-only] -o <file> <pid>\n")
+	fmt.Fprintf(os.Stdout, "\nEnvironment:\n")
+	fmt.Fprintf(os.Stdout, "  %s\troot of the procfs, default %s\n", procfs.PROC_ROOT_ENV, procfs.DEFAULT_PROC_ROOT)
+	fmt.Fprintf(os.Stdout, "  %s\troot of the hsperfdata and attach files, default %s\n", procfs.TMP_ROOT_ENV, procfs.DEFAULT_TMP_ROOT)
+	fmt.Fprintf(os.Stdout, "  OTEL_EXPORTER_OTLP_*, OTEL_RESOURCE_ATTRIBUTES\tread by ptop otlp, flags take precedence\n")
 }
 
 
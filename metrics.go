@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"sort"
+)
+
+// threads beyond this number are folded into THREAD_LABEL_OTHER
+const DEFAULT_EXPORT_MAX_THREADS = 100
+
+// How the per-thread metrics of the exporters are labelled
+const (
+	// one series per thread name, and its pool
+	THREAD_LABELS_NAME = "name"
+	// one series per pool, threads of a pool are summed
+	THREAD_LABELS_POOL = "pool"
+	// no per-thread metrics at all
+	THREAD_LABELS_NONE = "none"
+)
+
+// thread label of the threads beyond the -max-threads busiest ones
+const THREAD_LABEL_OTHER = "other"
+
+func checkThreadLabels(threadLabels string) error {
+	switch threadLabels {
+	case THREAD_LABELS_NAME, THREAD_LABELS_POOL, THREAD_LABELS_NONE:
+		return nil
+	}
+	return fmt.Errorf("invalid thread labels [%s], expected %s, %s or %s", threadLabels, THREAD_LABELS_NAME, THREAD_LABELS_POOL, THREAD_LABELS_NONE)
+}
+
+// ThreadMetrics are the counters of one Java thread, or of several of them summed under the same labels
+type ThreadMetrics struct {
+	Pool       string
+	// empty unless the threads are labelled by name
+	Thread     string
+
+	CpuSeconds float64
+	ReadBytes  uint64
+	WriteBytes uint64
+	// in kB
+	StackRss   uint64
+}
+
+// threadCounters are the cumulative counters of a thread, which only grow while it lives
+type threadCounters struct {
+	CpuTicks   uint64
+	ReadBytes  uint64
+	WriteBytes uint64
+}
+
+func (this *threadCounters) add(other threadCounters) {
+	this.CpuTicks += other.CpuTicks
+	this.ReadBytes += other.ReadBytes
+	this.WriteBytes += other.WriteBytes
+}
+
+// trackedThread is a Java thread followed across snapshots, along with the series it is counted in
+type trackedThread struct {
+	name   string
+	series ThreadMetrics
+	// counters when the thread joined its series, only the growth since is counted in it
+	base   threadCounters
+	last   threadCounters
+}
+
+func (this *trackedThread) counted() threadCounters {
+	return threadCounters{CpuTicks: this.last.CpuTicks - this.base.CpuTicks, ReadBytes: this.last.ReadBytes - this.base.ReadBytes,
+		WriteBytes: this.last.WriteBytes - this.base.WriteBytes}
+}
+
+// ThreadMetricsAggregator labels the counters of the Java threads of successive snapshots of a process according to
+// threadLabels. Exported as counters, the sums of a series must never decrease, or rate() takes the drop for a reset:
+// the counts of the threads which exit stay in their series, and a thread never moves to another series. With
+// THREAD_LABELS_NAME, the maxThreads thread names with the most CPU time on the first snapshot, then the first new names
+// until there are maxThreads, keep a series of their own for good; the other threads are summed as THREAD_LABEL_OTHER,
+// so that short-lived or numbered threads cannot blow up the number of series.
+type ThreadMetricsAggregator struct {
+	threadLabels string
+	maxThreads   int
+	// by nid
+	threads      map[int]*trackedThread
+	// thread names which have a series of their own
+	names        map[string]bool
+	// counts of the threads which left a series, by series
+	retired      map[ThreadMetrics]threadCounters
+}
+
+func NewThreadMetricsAggregator(threadLabels string, maxThreads int) (*ThreadMetricsAggregator, error) {
+	if err := checkThreadLabels(threadLabels); err != nil {
+		return nil, err
+	}
+
+	return &ThreadMetricsAggregator{threadLabels: threadLabels, maxThreads: maxThreads, threads: make(map[int]*trackedThread),
+		names: make(map[string]bool), retired: make(map[ThreadMetrics]threadCounters)}, nil
+}
+
+// Aggregate returns the metrics of every series seen so far, the busiest first. Threads missing from the mappings of the
+// snapshot, but not from its thread dump, keep counting with their last values.
+func (this *ThreadMetricsAggregator) Aggregate(snapshot *correlate.Snapshot) []ThreadMetrics {
+	if this.threadLabels == THREAD_LABELS_NONE {
+		return nil
+	}
+
+	for nid, tracked := range this.threads {
+		if _, ok := snapshot.Threads[nid]; !ok {
+			this.retire(tracked)
+			delete(this.threads, nid)
+		}
+	}
+
+	var listOfThreadSegments []correlate.TaskMemorySegment
+	var mapOfStackRss = make(map[int]uint64)
+	for _, segment := range *snapshot.Segments {
+		if segment.FrameType == "JavaThread" {
+			listOfThreadSegments = append(listOfThreadSegments, segment)
+			mapOfStackRss[segment.TaskID] += segment.Rss
+		}
+	}
+	//new names are given a series of their own by CPU time
+	sort.SliceStable(listOfThreadSegments, func(i, j int) bool {
+		return listOfThreadSegments[i].CpuTicks > listOfThreadSegments[j].CpuTicks
+	})
+
+	for _, segment := range listOfThreadSegments {
+		current := threadCounters{CpuTicks: segment.CpuTicks, ReadBytes: segment.ReadBytes, WriteBytes: segment.WriteBytes}
+
+		tracked, ok := this.threads[segment.TaskID]
+		if ok {
+			reused := current.CpuTicks < tracked.last.CpuTicks || current.ReadBytes < tracked.last.ReadBytes ||
+				current.WriteBytes < tracked.last.WriteBytes
+			if !reused && tracked.name == segment.Path {
+				tracked.last = current
+				continue
+			}
+
+			this.retire(tracked)
+			if !reused {
+				//renamed, what it counted so far stays under its former name
+				this.track(segment.TaskID, segment.Path, current, current)
+				continue
+			}
+		}
+		//nid seen for the first time, or reused by a new thread
+		this.track(segment.TaskID, segment.Path, threadCounters{}, current)
+	}
+
+	//summed in clock ticks, then converted, so that a series reads the same whatever threads it is made of
+	var mapOfCounters = make(map[ThreadMetrics]threadCounters)
+	var mapOfStackRssBySeries = make(map[ThreadMetrics]uint64)
+	for key, counters := range this.retired {
+		mapOfCounters[key] = counters
+	}
+	for nid, tracked := range this.threads {
+		counters := mapOfCounters[tracked.series]
+		counters.add(tracked.counted())
+		mapOfCounters[tracked.series] = counters
+		mapOfStackRssBySeries[tracked.series] += mapOfStackRss[nid]
+	}
+
+	var result []ThreadMetrics
+	for key, counters := range mapOfCounters {
+		metrics := key
+		metrics.CpuSeconds = float64(counters.CpuTicks) / CLOCK_TICKS_PER_SECOND
+		metrics.ReadBytes = counters.ReadBytes
+		metrics.WriteBytes = counters.WriteBytes
+		metrics.StackRss = mapOfStackRssBySeries[key]
+		result = append(result, metrics)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CpuSeconds != result[j].CpuSeconds {
+			return result[i].CpuSeconds > result[j].CpuSeconds
+		}
+		if result[i].Pool != result[j].Pool {
+			return result[i].Pool < result[j].Pool
+		}
+		return result[i].Thread < result[j].Thread
+	})
+
+	return result
+}
+
+// track starts following a thread in the series its name falls in
+func (this *ThreadMetricsAggregator) track(nid int, name string, base threadCounters, current threadCounters) {
+	series := ThreadMetrics{Pool: jvmdump.ThreadPool(name)}
+	if this.threadLabels == THREAD_LABELS_NAME {
+		if !this.names[name] && (this.maxThreads <= 0 || len(this.names) < this.maxThreads) {
+			this.names[name] = true
+		}
+		if this.names[name] {
+			series.Thread = name
+		} else {
+			series.Thread = THREAD_LABEL_OTHER
+		}
+	}
+
+	this.threads[nid] = &trackedThread{name: name, series: series, base: base, last: current}
+}
+
+// retire keeps what a thread counted in its series once it left it
+func (this *ThreadMetricsAggregator) retire(tracked *trackedThread) {
+	counters := this.retired[tracked.series]
+	counters.add(tracked.counted())
+	this.retired[tracked.series] = counters
+}
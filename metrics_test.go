@@ -0,0 +1,109 @@
+package main
+
+import (
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"github.com/shirou/gopsutil/process"
+	"testing"
+)
+
+type testThread struct {
+	nid        int
+	name       string
+	cpuTicks   uint64
+	readBytes  uint64
+	writeBytes uint64
+}
+
+func testSnapshot(listOfThreads ...testThread) *correlate.Snapshot {
+	snapshot := correlate.Snapshot{Threads: make(map[int]jvmdump.JavaThread), Layout: &correlate.JvmMemoryLayout{}}
+
+	var listOfSegments []correlate.TaskMemorySegment
+	for _, thread := range listOfThreads {
+		snapshot.Threads[thread.nid] = jvmdump.JavaThread{Nid: thread.nid, ThreadName: thread.name}
+
+		segment := correlate.TaskMemorySegment{TaskID: thread.nid, CpuTicks: thread.cpuTicks, ReadBytes: thread.readBytes, WriteBytes: thread.writeBytes}
+		segment.ProcessMemorySegment = smaps.ProcessMemorySegment{MemoryMapsStat: process.MemoryMapsStat{Path: thread.name, Rss: 8}, FrameType: "JavaThread"}
+		listOfSegments = append(listOfSegments, segment)
+	}
+	snapshot.Segments = &listOfSegments
+
+	return &snapshot
+}
+
+func metricsBySeries(listOfMetrics []ThreadMetrics) map[ThreadMetrics]ThreadMetrics {
+	var result = make(map[ThreadMetrics]ThreadMetrics)
+	for _, metrics := range listOfMetrics {
+		result[ThreadMetrics{Pool: metrics.Pool, Thread: metrics.Thread}] = metrics
+	}
+	return result
+}
+
+// checkMonotonic fails if a counter of a series decreased, or if a series went missing, since the previous scrape
+func checkMonotonic(t *testing.T, scrape int, previous map[ThreadMetrics]ThreadMetrics, current map[ThreadMetrics]ThreadMetrics) {
+	for key, prev := range previous {
+		curr, ok := current[key]
+		if !ok {
+			t.Errorf("scrape %d: series %+v is gone", scrape, key)
+			continue
+		}
+		if curr.CpuSeconds < prev.CpuSeconds || curr.ReadBytes < prev.ReadBytes || curr.WriteBytes < prev.WriteBytes {
+			t.Errorf("scrape %d: series %+v decreased from %+v to %+v", scrape, key, prev, curr)
+		}
+	}
+}
+
+func TestThreadMetricsAggregatorMonotonic(t *testing.T) {
+	var scrapes = [][]testThread{
+		{{1, "main", 500, 100, 10}, {2, "pool-1-thread-1", 300, 0, 0}, {3, "pool-1-thread-2", 100, 50, 5}, {4, "GC-worker", 10, 0, 0}},
+		//pool-1-thread-2 exits, GC-worker became the busiest
+		{{1, "main", 510, 100, 10}, {2, "pool-1-thread-1", 310, 0, 0}, {4, "GC-worker", 900, 0, 0}},
+		//a new thread shows up and outruns everyone
+		{{1, "main", 520, 100, 10}, {2, "pool-1-thread-1", 320, 0, 0}, {4, "GC-worker", 910, 0, 0}, {5, "pool-1-thread-3", 2000, 10, 0}},
+		//main is renamed, pool-1-thread-1 exits and its nid is reused
+		{{1, "main-renamed", 530, 100, 10}, {2, "pool-2-thread-1", 5, 0, 0}, {4, "GC-worker", 920, 0, 0}, {5, "pool-1-thread-3", 2100, 10, 0}},
+	}
+
+	for _, threadLabels := range []string{THREAD_LABELS_NAME, THREAD_LABELS_POOL} {
+		aggregator, err := NewThreadMetricsAggregator(threadLabels, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var previous map[ThreadMetrics]ThreadMetrics
+		for i, listOfThreads := range scrapes {
+			current := metricsBySeries(aggregator.Aggregate(testSnapshot(listOfThreads...)))
+			checkMonotonic(t, i, previous, current)
+			previous = current
+		}
+	}
+}
+
+func TestThreadMetricsAggregatorSeries(t *testing.T) {
+	aggregator, err := NewThreadMetricsAggregator(THREAD_LABELS_NAME, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the 2 busiest names of the first scrape keep their series
+	aggregator.Aggregate(testSnapshot(testThread{1, "main", 500, 0, 0}, testThread{2, "worker-1", 300, 0, 0}, testThread{3, "worker-2", 100, 0, 0}))
+	current := metricsBySeries(aggregator.Aggregate(testSnapshot(testThread{1, "main", 500, 0, 0}, testThread{2, "worker-1", 300, 0, 0},
+		testThread{3, "worker-2", 5000, 0, 0})))
+
+	if _, ok := current[ThreadMetrics{Pool: "worker", Thread: "worker-2"}]; ok {
+		t.Errorf("worker-2 was given a series of its own after the first scrape")
+	}
+	if other := current[ThreadMetrics{Pool: "worker", Thread: THREAD_LABEL_OTHER}]; other.CpuSeconds != 50 {
+		t.Errorf("other = %+v, expected 50s of CPU", other)
+	}
+	if main := current[ThreadMetrics{Pool: "main", Thread: "main"}]; main.CpuSeconds != 5 || main.StackRss != 8 {
+		t.Errorf("main = %+v", main)
+	}
+
+	//an exited thread stays counted in its series
+	current = metricsBySeries(aggregator.Aggregate(testSnapshot(testThread{1, "main", 600, 0, 0}, testThread{3, "worker-2", 5100, 0, 0})))
+	if worker := current[ThreadMetrics{Pool: "worker", Thread: "worker-1"}]; worker.CpuSeconds != 3 || worker.StackRss != 0 {
+		t.Errorf("worker-1 = %+v, expected 3s of CPU and no stack", worker)
+	}
+}
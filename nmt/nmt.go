@@ -0,0 +1,156 @@
+// Package nmt parses the Native Memory Tracking reports of jcmd VM.native_memory.
+package nmt
+
+import (
+	"errors"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/internal/util"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const NMT_TOTAL_REGEX = `^Total: reserved=(?P<reserved>\d+)KB, committed=(?P<committed>\d+)KB`
+
+const NMT_CATEGORY_REGEX = `^-\s+(?P<category>.+?) \(reserved=(?P<reserved>\d+)KB, committed=(?P<committed>\d+)KB\)`
+
+const NMT_REGION_REGEX = `^\[(?P<start>0x[0-9a-f]+) - (?P<stop>0x[0-9a-f]+)\] reserved (?:and committed )?\d+KB for (?P<category>.+?) from`
+
+// ErrNmtNotEnabled is returned when the JVM runs without -XX:NativeMemoryTracking
+var ErrNmtNotEnabled = errors.New("native memory tracking is not enabled")
+
+// NmtCategory is one category of the NMT summary, in kB
+type NmtCategory struct {
+	Name      string
+	Reserved  uint64
+	Committed uint64
+}
+
+// NmtRegion is a reserved virtual memory region listed in the NMT detail report
+type NmtRegion struct {
+	Start    uint64
+	Stop     uint64
+	Category string
+}
+
+// NativeMemoryReport is the parsed output of jcmd VM.native_memory
+type NativeMemoryReport struct {
+	Timestamp  time.Time
+	Total      NmtCategory
+	Categories []NmtCategory
+	// only available with -XX:NativeMemoryTracking=detail
+	Regions    []NmtRegion
+}
+
+// GetNativeMemoryReport asks the target JVM for its NMT report, preferring the detail level
+func GetNativeMemoryReport(pid int32) (*NativeMemoryReport, error) {
+	output, err := GetNativeMemoryOutput(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseNativeMemoryReport(output)
+}
+
+// GetNativeMemoryOutput returns the raw NMT report, at the detail level if available
+func GetNativeMemoryOutput(pid int32) (string, error) {
+	output, err := attach.ExecuteJcmd(pid, "VM.native_memory detail scale=KB")
+	if err != nil || strings.Contains(output, "not enabled") {
+		//running with -XX:NativeMemoryTracking=summary, or not at all
+		return attach.ExecuteJcmd(pid, "VM.native_memory summary scale=KB")
+	}
+
+	return output, nil
+}
+
+// ParseNativeMemoryReport parses the output of VM.native_memory detail
+func ParseNativeMemoryReport(output string) (*NativeMemoryReport, error) {
+	if strings.Contains(output, "Native memory tracking is not enabled") {
+		return nil, ErrNmtNotEnabled
+	}
+
+	parseCategory := func(name string, params map[string]string) (NmtCategory, error) {
+		category := NmtCategory{Name: name}
+		var err error
+
+		category.Reserved, err = strconv.ParseUint(params["reserved"], 10, 64)
+		if err != nil {
+			return category, err
+		}
+		category.Committed, err = strconv.ParseUint(params["committed"], 10, 64)
+		return category, err
+	}
+
+	var report = NativeMemoryReport{Timestamp: time.Now()}
+	for _, line := range strings.Split(output, "\n") {
+		if params := util.ParseRegexByGroup(NMT_TOTAL_REGEX, line); len(params) > 0 {
+			total, err := parseCategory("Total", params)
+			if err != nil {
+				return nil, err
+			}
+			report.Total = total
+		} else if params := util.ParseRegexByGroup(NMT_CATEGORY_REGEX, line); len(params) > 0 {
+			category, err := parseCategory(params["category"], params)
+			if err != nil {
+				return nil, err
+			}
+			report.Categories = append(report.Categories, category)
+		} else if params := util.ParseRegexByGroup(NMT_REGION_REGEX, line); len(params) > 0 {
+			start, err := strconv.ParseUint(params["start"], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			stop, err := strconv.ParseUint(params["stop"], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			report.Regions = append(report.Regions, NmtRegion{Start: start, Stop: stop, Category: params["category"]})
+		}
+	}
+
+	return &report, nil
+}
+
+// NmtCategoryDelta is a category of the current report along with its growth since a reference report, in kB
+type NmtCategoryDelta struct {
+	NmtCategory
+	ReservedDelta  int64
+	CommittedDelta int64
+}
+
+// DiffNativeMemoryReports compares current against reference, which is either the previous refresh or a baseline.
+// The total comes first; categories absent from reference are reported as fully grown, the ones absent from current,
+// e.g. after the JVM freed all of a category, come last as fully shrunk.
+func DiffNativeMemoryReports(current *NativeMemoryReport, reference *NativeMemoryReport) []NmtCategoryDelta {
+	var referenceCategories = make(map[string]NmtCategory)
+	if reference != nil {
+		referenceCategories[reference.Total.Name] = reference.Total
+		for _, category := range reference.Categories {
+			referenceCategories[category.Name] = category
+		}
+	}
+
+	var result []NmtCategoryDelta
+	for _, category := range append([]NmtCategory{current.Total}, current.Categories...) {
+		delta := NmtCategoryDelta{NmtCategory: category}
+		if reference != nil {
+			referenceCategory := referenceCategories[category.Name]
+			delta.ReservedDelta = int64(category.Reserved) - int64(referenceCategory.Reserved)
+			delta.CommittedDelta = int64(category.Committed) - int64(referenceCategory.Committed)
+		}
+		result = append(result, delta)
+		delete(referenceCategories, category.Name)
+	}
+
+	if reference != nil {
+		for _, category := range reference.Categories {
+			if _, ok := referenceCategories[category.Name]; !ok {
+				continue
+			}
+			result = append(result, NmtCategoryDelta{NmtCategory: NmtCategory{Name: category.Name},
+				ReservedDelta: -int64(category.Reserved), CommittedDelta: -int64(category.Committed)})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,143 @@
+package nmt
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readReport(t *testing.T, name string) *NativeMemoryReport {
+	output, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := ParseNativeMemoryReport(string(output))
+	if err != nil {
+		t.Fatalf("%s: ParseNativeMemoryReport failed: %s", name, err)
+	}
+	return report
+}
+
+func TestParseNativeMemoryReport(t *testing.T) {
+	var tests = []struct {
+		file       string
+		total      NmtCategory
+		categories []NmtCategory
+		regions    []NmtRegion
+	}{
+		{
+			file:  "jdk8-summary.txt",
+			total: NmtCategory{"Total", 1453636, 146380},
+			categories: []NmtCategory{
+				{"Java Heap", 262144, 16384},
+				{"Class", 1066093, 14573},
+				{"Thread", 20597, 20597},
+				{"Code", 249954, 4814},
+				{"GC", 10265, 265},
+				{"Compiler", 134, 134},
+				{"Internal", 3208, 3208},
+				{"Symbol", 4251, 4251},
+				{"Native Memory Tracking", 525, 525},
+				{"Arena Chunk", 187, 187},
+			},
+		},
+		{
+			file:  "jdk17-detail.txt",
+			total: NmtCategory{"Total", 1469066, 93518},
+			categories: []NmtCategory{
+				{"Java Heap", 262144, 16384},
+				{"Class", 1048866, 1250},
+				{"Thread", 22553, 1221},
+				{"Code", 247805, 7665},
+				{"GC", 57532, 45428},
+				{"Tracing", 32, 32},
+				{"Native Memory Tracking", 1034, 1034},
+				{"Shared class space", 12288, 12032},
+			},
+			//committed sub-regions are not listed
+			regions: []NmtRegion{
+				{0xf0000000, 0x100000000, "Java Heap"},
+				{0x7f7bf4000000, 0x7f7bf4bc0000, "Shared class space"},
+			},
+		},
+		{
+			file:  "jdk21-summary.txt",
+			total: NmtCategory{"Total", 5801806, 233294},
+			categories: []NmtCategory{
+				{"Java Heap", 4120576, 77824},
+				{"Class", 1048849, 721},
+				{"Thread", 41070, 2542},
+				{"Code", 249911, 8647},
+				{"GC", 225312, 95048},
+				{"Object Monitors", 1, 1},
+				{"Module", 223, 223},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		report := readReport(t, test.file)
+
+		if report.Total != test.total {
+			t.Errorf("%s: total = %+v, expected %+v", test.file, report.Total, test.total)
+		}
+		if !reflect.DeepEqual(report.Categories, test.categories) {
+			t.Errorf("%s: categories = %+v, expected %+v", test.file, report.Categories, test.categories)
+		}
+		if !reflect.DeepEqual(report.Regions, test.regions) {
+			t.Errorf("%s: regions = %+v, expected %+v", test.file, report.Regions, test.regions)
+		}
+	}
+}
+
+func TestParseNativeMemoryReportNotEnabled(t *testing.T) {
+	_, err := ParseNativeMemoryReport("4242:\nNative memory tracking is not enabled\n")
+	if err != ErrNmtNotEnabled {
+		t.Errorf("error = %v, expected %v", err, ErrNmtNotEnabled)
+	}
+}
+
+func TestDiffNativeMemoryReports(t *testing.T) {
+	reference := readReport(t, "jdk17-detail.txt")
+
+	//Tracing was freed, a new category showed up and the heap grew
+	current := &NativeMemoryReport{
+		Total: NmtCategory{"Total", 1469066, 100000},
+		Categories: []NmtCategory{
+			{"Java Heap", 262144, 20480},
+			{"Class", 1048866, 1250},
+			{"Thread", 22553, 1221},
+			{"Code", 247805, 7665},
+			{"GC", 57532, 45428},
+			{"Native Memory Tracking", 1034, 1034},
+			{"Shared class space", 12288, 12032},
+			{"Module", 223, 223},
+		},
+	}
+
+	expected := []NmtCategoryDelta{
+		{NmtCategory{"Total", 1469066, 100000}, 0, 100000 - 93518},
+		{NmtCategory{"Java Heap", 262144, 20480}, 0, 4096},
+		{NmtCategory{"Class", 1048866, 1250}, 0, 0},
+		{NmtCategory{"Thread", 22553, 1221}, 0, 0},
+		{NmtCategory{"Code", 247805, 7665}, 0, 0},
+		{NmtCategory{"GC", 57532, 45428}, 0, 0},
+		{NmtCategory{"Native Memory Tracking", 1034, 1034}, 0, 0},
+		{NmtCategory{"Shared class space", 12288, 12032}, 0, 0},
+		{NmtCategory{"Module", 223, 223}, 223, 223},
+		{NmtCategory{"Tracing", 0, 0}, -32, -32},
+	}
+
+	if actual := DiffNativeMemoryReports(current, reference); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("deltas = %+v, expected %+v", actual, expected)
+	}
+
+	//without a reference, nothing has grown
+	for _, delta := range DiffNativeMemoryReports(current, nil) {
+		if delta.ReservedDelta != 0 || delta.CommittedDelta != 0 {
+			t.Errorf("%s has a delta without a reference", delta.Name)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/shirou/gopsutil/process"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const DEFAULT_OTLP_INTERVAL_IN_SECOND = 60
+
+// Transports of the OTLP exporter
+const (
+	OTLP_PROTOCOL_GRPC = "grpc"
+	OTLP_PROTOCOL_HTTP = "http"
+)
+
+// instrumentation scope of the metrics
+const OTLP_METER_NAME = "github.com/mcfongtw/go-ptop"
+
+// OtlpExporter pushes the snapshots of a process to an OpenTelemetry collector. The snapshot is asked for on every
+// collection, i.e. once per -interval and on shutdown, from a CachedSource so that a collection does not always pause the
+// JVM.
+type OtlpExporter struct {
+	source       SnapshotSource
+	pid          int32
+	threadLabels string
+	//keeps the per-thread sums monotonic from one collection to the next, as cumulative temporality requires
+	aggregator   *ThreadMetricsAggregator
+}
+
+func NewOtlpExporter(source SnapshotSource, pid int32, threadLabels string, maxThreads int) (*OtlpExporter, error) {
+	aggregator, err := NewThreadMetricsAggregator(threadLabels, maxThreads)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OtlpExporter{source: source, pid: pid, threadLabels: threadLabels, aggregator: aggregator}, nil
+}
+
+// Register creates the instruments on meter, along with the callback observing them
+func (this *OtlpExporter) Register(meter metric.Meter) error {
+	threadCpu, err := meter.Float64ObservableCounter("ptop.thread.cpu.time", metric.WithUnit("s"),
+		metric.WithDescription("CPU time spent by the Java threads"))
+	if err != nil {
+		return err
+	}
+	threadRead, err := meter.Int64ObservableCounter("ptop.thread.io.read", metric.WithUnit("By"),
+		metric.WithDescription("Bytes read from storage by the Java threads"))
+	if err != nil {
+		return err
+	}
+	threadWrite, err := meter.Int64ObservableCounter("ptop.thread.io.write", metric.WithUnit("By"),
+		metric.WithDescription("Bytes written to storage by the Java threads"))
+	if err != nil {
+		return err
+	}
+	threadStackRss, err := meter.Int64ObservableGauge("ptop.thread.stack.rss", metric.WithUnit("By"),
+		metric.WithDescription("Resident set size of the stacks of the Java threads"))
+	if err != nil {
+		return err
+	}
+	threadStates, err := meter.Int64ObservableGauge("ptop.threads", metric.WithUnit("{thread}"),
+		metric.WithDescription("Java threads by state"))
+	if err != nil {
+		return err
+	}
+	memorySize, err := meter.Int64ObservableGauge("ptop.memory.size", metric.WithUnit("By"),
+		metric.WithDescription("Virtual size of the mappings by memory category"))
+	if err != nil {
+		return err
+	}
+	memoryRss, err := meter.Int64ObservableGauge("ptop.memory.rss", metric.WithUnit("By"),
+		metric.WithDescription("Resident set size of the mappings by memory category"))
+	if err != nil {
+		return err
+	}
+	memoryPss, err := meter.Int64ObservableGauge("ptop.memory.pss", metric.WithUnit("By"),
+		metric.WithDescription("Proportional set size of the mappings by memory category"))
+	if err != nil {
+		return err
+	}
+	memoryMappings, err := meter.Int64ObservableGauge("ptop.memory.mappings", metric.WithUnit("{mapping}"),
+		metric.WithDescription("Number of mappings by memory category"))
+	if err != nil {
+		return err
+	}
+	nmtCommitted, err := meter.Int64ObservableGauge("ptop.nmt.committed", metric.WithUnit("By"),
+		metric.WithDescription("Memory committed by memory category, as reported by native memory tracking"))
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		snapshot, err := this.source.GetSnapshot(this.pid)
+		if err != nil {
+			glog.Errorf("GetSnapshot(%d) Cause: [%s]", this.pid, err)
+			return err
+		}
+
+		for _, metrics := range this.aggregator.Aggregate(snapshot) {
+			var attributes = []attribute.KeyValue{attribute.String("thread.pool", metrics.Pool)}
+			if this.threadLabels == THREAD_LABELS_NAME {
+				attributes = append(attributes, semconv.ThreadName(metrics.Thread))
+			}
+			attributeSet := metric.WithAttributes(attributes...)
+
+			observer.ObserveFloat64(threadCpu, metrics.CpuSeconds, attributeSet)
+			observer.ObserveInt64(threadRead, int64(metrics.ReadBytes), attributeSet)
+			observer.ObserveInt64(threadWrite, int64(metrics.WriteBytes), attributeSet)
+			observer.ObserveInt64(threadStackRss, int64(metrics.StackRss * 1024), attributeSet)
+		}
+
+		var mapOfStateCounts = make(map[string]int64)
+		for _, state := range jvmdump.ListOfThreadStates {
+			mapOfStateCounts[state] = 0
+		}
+		for _, jthread := range snapshot.Threads {
+			if jthread.State != "" {
+				mapOfStateCounts[jthread.State]++
+			}
+		}
+		for state, count := range mapOfStateCounts {
+			observer.ObserveInt64(threadStates, count, metric.WithAttributes(attribute.String("thread.state", state)))
+		}
+
+		for _, summary := range correlate.SummarizeMemoryCategories(snapshot.Segments, snapshot.Layout.NativeMemory) {
+			attributeSet := metric.WithAttributes(attribute.String("memory.category", summary.Category))
+
+			observer.ObserveInt64(memorySize, int64(summary.Size * 1024), attributeSet)
+			observer.ObserveInt64(memoryRss, int64(summary.Rss * 1024), attributeSet)
+			observer.ObserveInt64(memoryPss, int64(summary.Pss * 1024), attributeSet)
+			observer.ObserveInt64(memoryMappings, int64(summary.Mappings), attributeSet)
+			if summary.HasNmt {
+				observer.ObserveInt64(nmtCommitted, int64(summary.NmtCommitted * 1024), attributeSet)
+			}
+		}
+
+		return nil
+	}, threadCpu, threadRead, threadWrite, threadStackRss, threadStates, memorySize, memoryRss, memoryPss, memoryMappings, nmtCommitted)
+
+	return err
+}
+
+// newOtlpMetricExporter connects to the collector. Without an endpoint, the OTEL_EXPORTER_OTLP_* environment variables, or
+// the defaults of the protocol, i.e. localhost:4317 or localhost:4318, apply.
+func newOtlpMetricExporter(ctx context.Context, protocol string, endpoint string, insecure bool) (sdkmetric.Exporter, error) {
+	switch protocol {
+	case OTLP_PROTOCOL_GRPC:
+		var options []otlpmetricgrpc.Option
+		if endpoint != "" {
+			options = append(options, otlpmetricgrpc.WithEndpoint(endpoint))
+		}
+		if insecure {
+			options = append(options, otlpmetricgrpc.WithInsecure())
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+
+	case OTLP_PROTOCOL_HTTP:
+		var options []otlpmetrichttp.Option
+		if endpoint != "" {
+			options = append(options, otlpmetrichttp.WithEndpoint(endpoint))
+		}
+		if insecure {
+			options = append(options, otlpmetrichttp.WithInsecure())
+		}
+		return otlpmetrichttp.New(ctx, options...)
+	}
+
+	return nil, fmt.Errorf("invalid protocol [%s], expected %s or %s", protocol, OTLP_PROTOCOL_GRPC, OTLP_PROTOCOL_HTTP)
+}
+
+// parseResourceAttributes parses a comma-separated list of key=value pairs, as in OTEL_RESOURCE_ATTRIBUTES
+func parseResourceAttributes(value string) ([]attribute.KeyValue, error) {
+	var attributes []attribute.KeyValue
+
+	for _, pair := range strings.Split(value, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid resource attribute [%s], expected key=value", pair)
+		}
+		attributes = append(attributes, attribute.String(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])))
+	}
+
+	return attributes, nil
+}
+
+// newOtlpResource describes the process: defaults first, then OTEL_RESOURCE_ATTRIBUTES and OTEL_SERVICE_NAME, then the
+// flags, later ones taking precedence
+func newOtlpResource(ctx context.Context, pid int32, serviceName string, hostName string, containerId string, extraAttributes string) (*resource.Resource, error) {
+	var defaultAttributes = []attribute.KeyValue{semconv.ProcessPID(int(pid))}
+	if proc, err := process.NewProcess(pid); err == nil {
+		if name, err := proc.Name(); err == nil {
+			defaultAttributes = append(defaultAttributes, semconv.ServiceName(name))
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		defaultAttributes = append(defaultAttributes, semconv.HostName(hostname))
+	}
+	if id, err := procfs.ContainerID(pid); err == nil && id != "" {
+		defaultAttributes = append(defaultAttributes, semconv.ContainerID(id))
+	}
+
+	flagAttributes, err := parseResourceAttributes(extraAttributes)
+	if err != nil {
+		return nil, err
+	}
+	if serviceName != "" {
+		flagAttributes = append(flagAttributes, semconv.ServiceName(serviceName))
+	}
+	if hostName != "" {
+		flagAttributes = append(flagAttributes, semconv.HostName(hostName))
+	}
+	if containerId != "" {
+		flagAttributes = append(flagAttributes, semconv.ContainerID(containerId))
+	}
+
+	return resource.New(ctx, resource.WithAttributes(defaultAttributes...), resource.WithFromEnv(),
+		resource.WithAttributes(flagAttributes...))
+}
+
+// runOtlp pushes the metrics of a process to an OpenTelemetry collector, i.e. `ptop otlp <pid> -endpoint <host:port>`,
+// until the process is gone or ptop is interrupted
+func runOtlp(args []string) error {
+	flags := flag.NewFlagSet("ptop otlp", flag.ContinueOnError)
+	protocol := flags.String("protocol", OTLP_PROTOCOL_GRPC, "OTLP transport: grpc or http")
+	endpoint := flags.String("endpoint", "", "host:port of the collector, default from OTEL_EXPORTER_OTLP_ENDPOINT or localhost")
+	insecure := flags.Bool("insecure", false, "connect without TLS, e.g. to a local collector")
+	interval := flags.Duration("interval", DEFAULT_OTLP_INTERVAL_IN_SECOND * time.Second, "time between two exports")
+	serviceName := flags.String("service-name", "", "service.name resource attribute, default the process name")
+	hostName := flags.String("host", "", "host.name resource attribute, default the hostname")
+	containerId := flags.String("container-id", "", "container.id resource attribute, default the id found in the cgroup of the process")
+	extraAttributes := flags.String("resource", "", "additional resource attributes, as comma-separated key=value pairs")
+	threadLabels := flags.String("thread-labels", THREAD_LABELS_NAME, "attributes of the per-thread metrics: name, pool or none")
+	maxThreads := flags.Int("max-threads", DEFAULT_EXPORT_MAX_THREADS, "thread names kept, the busiest on the first export then new ones as they show up, the other threads are summed as thread.name=\"other\", 0 for no limit")
+	minInterval := flags.Duration("min-interval", DEFAULT_SNAPSHOT_INTERVAL_IN_SECOND * time.Second, "minimum time between two snapshots of the process, collections in between export the latest one")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	pid := int32(parsedPid)
+	if *protocol != OTLP_PROTOCOL_GRPC && *protocol != OTLP_PROTOCOL_HTTP {
+		return usageErrorf("invalid protocol [%s], expected %s or %s", *protocol, OTLP_PROTOCOL_GRPC, OTLP_PROTOCOL_HTTP)
+	}
+	if _, err := parseResourceAttributes(*extraAttributes); err != nil {
+		return usageError{err}
+	}
+
+	exporter, err := NewOtlpExporter(NewCachedSource(&LiveSource{}, *minInterval), pid, *threadLabels, *maxThreads)
+	if err != nil {
+		return usageError{err}
+	}
+
+	ctx := context.Background()
+	res, err := newOtlpResource(ctx, pid, *serviceName, *hostName, *containerId, *extraAttributes)
+	if err != nil {
+		return err
+	}
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		glog.Errorf("OpenTelemetry Cause: [%s]", err)
+	}))
+
+	metricExporter, err := newOtlpMetricExporter(ctx, *protocol, *endpoint, *insecure)
+	if err != nil {
+		return err
+	}
+
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(*interval))))
+	//the last collection is exported on shutdown
+	defer provider.Shutdown(ctx)
+
+	if err := exporter.Register(provider.Meter(OTLP_METER_NAME)); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "exporting metrics of process %d over OTLP/%s every %s\n", pid, *protocol, *interval)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-signals:
+			return nil
+		case <-ticker.C:
+			if _, err := procfs.SearchProcessByPid(pid); err != nil {
+				fmt.Fprintf(os.Stderr, "process %d is gone\n", pid)
+				return nil
+			}
+		}
+	}
+}
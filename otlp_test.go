@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"github.com/mcfongtw/go-ptop/correlate"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSource hands out the given snapshots in turn, the last one over and over
+type testSource struct {
+	LiveSource
+	listOfSnapshots []*correlate.Snapshot
+}
+
+func (this *testSource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	snapshot := this.listOfSnapshots[0]
+	if len(this.listOfSnapshots) > 1 {
+		this.listOfSnapshots = this.listOfSnapshots[1:]
+	}
+	return snapshot, nil
+}
+
+// testCollector stands in for an OpenTelemetry collector, keeping the requests it receives on /v1/metrics
+type testCollector struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	requests []*collectormetrics.ExportMetricsServiceRequest
+}
+
+func newTestCollector(t *testing.T) *testCollector {
+	collector := testCollector{}
+	collector.server = httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		body, err := ioutil.ReadAll(request.Body)
+		if err != nil || request.URL.Path != "/v1/metrics" {
+			t.Errorf("unexpected request to %s: %v", request.URL.Path, err)
+			http.Error(writer, "unexpected request", http.StatusBadRequest)
+			return
+		}
+
+		var export collectormetrics.ExportMetricsServiceRequest
+		if err := proto.Unmarshal(body, &export); err != nil {
+			t.Errorf("invalid export request: %s", err)
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		collector.mutex.Lock()
+		collector.requests = append(collector.requests, &export)
+		collector.mutex.Unlock()
+
+		response, _ := proto.Marshal(&collectormetrics.ExportMetricsServiceResponse{})
+		writer.Header().Set("Content-Type", "application/x-protobuf")
+		writer.Write(response)
+	}))
+	t.Cleanup(collector.server.Close)
+
+	return &collector
+}
+
+func (this *testCollector) endpoint() string {
+	return strings.TrimPrefix(this.server.URL, "http://")
+}
+
+func (this *testCollector) last() *collectormetrics.ExportMetricsServiceRequest {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if len(this.requests) == 0 {
+		return nil
+	}
+	return this.requests[len(this.requests)-1]
+}
+
+// exportCycles runs the exporter against the collector, one export per snapshot, and returns the resource attributes of
+// the last export, along with the attributes and the value of its ptop.thread.cpu.time points by pool
+func exportCycles(t *testing.T, collector *testCollector, serviceName string, extraAttributes string, listOfSnapshots ...*correlate.Snapshot) (map[string]string, map[string][]*commonpb.KeyValue, map[string]float64) {
+	ctx := context.Background()
+	pid := int32(os.Getpid())
+
+	res, err := newOtlpResource(ctx, pid, serviceName, "", "", extraAttributes)
+	if err != nil {
+		t.Fatalf("newOtlpResource failed: %s", err)
+	}
+	metricExporter, err := newOtlpMetricExporter(ctx, OTLP_PROTOCOL_HTTP, collector.endpoint(), true)
+	if err != nil {
+		t.Fatalf("newOtlpMetricExporter failed: %s", err)
+	}
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithResource(res),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(time.Hour))))
+	defer provider.Shutdown(ctx)
+
+	exporter, err := NewOtlpExporter(&testSource{listOfSnapshots: listOfSnapshots}, pid, THREAD_LABELS_POOL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Register(provider.Meter(OTLP_METER_NAME)); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	for range listOfSnapshots {
+		if err := provider.ForceFlush(ctx); err != nil {
+			t.Fatalf("ForceFlush failed: %s", err)
+		}
+	}
+
+	export := collector.last()
+	if export == nil || len(export.ResourceMetrics) != 1 {
+		t.Fatalf("no metrics exported")
+	}
+
+	var mapOfAttributes = make(map[string]string)
+	for _, keyValue := range export.ResourceMetrics[0].Resource.Attributes {
+		mapOfAttributes[keyValue.Key] = keyValue.Value.GetStringValue()
+		if intValue, ok := keyValue.Value.Value.(*commonpb.AnyValue_IntValue); ok {
+			mapOfAttributes[keyValue.Key] = strconv.FormatInt(intValue.IntValue, 10)
+		}
+	}
+
+	//thread.cpu.time by pool, along with the attributes of the points
+	var mapOfPointAttributes = make(map[string][]*commonpb.KeyValue)
+	var mapOfCpuSeconds = make(map[string]float64)
+	for _, scopeMetrics := range export.ResourceMetrics[0].ScopeMetrics {
+		for _, exported := range scopeMetrics.Metrics {
+			if exported.Name != "ptop.thread.cpu.time" {
+				continue
+			}
+			if !exported.GetSum().GetIsMonotonic() {
+				t.Errorf("%s is not monotonic", exported.Name)
+			}
+			for _, point := range exported.GetSum().DataPoints {
+				for _, keyValue := range point.Attributes {
+					if keyValue.Key == "thread.pool" {
+						pool := keyValue.Value.GetStringValue()
+						mapOfPointAttributes[pool] = point.Attributes
+						mapOfCpuSeconds[pool] = point.GetAsDouble()
+					}
+				}
+			}
+		}
+	}
+
+	return mapOfAttributes, mapOfPointAttributes, mapOfCpuSeconds
+}
+
+func TestOtlpExportResource(t *testing.T) {
+	collector := newTestCollector(t)
+	snapshot := testSnapshot(testThread{1, "main", 500, 0, 0})
+
+	hostname, _ := os.Hostname()
+	defaults, _, _ := exportCycles(t, collector, "", "", snapshot)
+	if defaults["host.name"] != hostname || defaults["service.name"] == "" || defaults["process.pid"] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("default resource attributes = %v", defaults)
+	}
+
+	//the environment overrides the defaults
+	os.Setenv("OTEL_RESOURCE_ATTRIBUTES", "service.name=from-env,host.name=env-host,deployment.environment=staging")
+	defer os.Unsetenv("OTEL_RESOURCE_ATTRIBUTES")
+	fromEnv, _, _ := exportCycles(t, collector, "", "", snapshot)
+	if fromEnv["service.name"] != "from-env" || fromEnv["host.name"] != "env-host" || fromEnv["deployment.environment"] != "staging" {
+		t.Errorf("resource attributes from the environment = %v", fromEnv)
+	}
+
+	//the flags override the environment
+	fromFlags, _, _ := exportCycles(t, collector, "from-flag", "deployment.environment=prod,team=jvm", snapshot)
+	if fromFlags["service.name"] != "from-flag" || fromFlags["deployment.environment"] != "prod" || fromFlags["team"] != "jvm" ||
+		fromFlags["host.name"] != "env-host" {
+		t.Errorf("resource attributes from the flags = %v", fromFlags)
+	}
+}
+
+func TestOtlpExportThreadCounters(t *testing.T) {
+	collector := newTestCollector(t)
+
+	//pool-1-thread-2 exits between the two exports, the pool keeps counting its CPU time
+	_, mapOfPointAttributes, mapOfCpuSeconds := exportCycles(t, collector, "", "",
+		testSnapshot(testThread{1, "main", 500, 0, 0}, testThread{2, "pool-1-thread-1", 300, 0, 0}, testThread{3, "pool-1-thread-2", 200, 0, 0}),
+		testSnapshot(testThread{1, "main", 600, 0, 0}, testThread{2, "pool-1-thread-1", 310, 0, 0}))
+
+	if mapOfCpuSeconds["main"] != 6 || mapOfCpuSeconds["pool-1-thread"] != 5.1 {
+		t.Errorf("CPU seconds by pool = %v, expected main 6 and pool-1-thread 5.1", mapOfCpuSeconds)
+	}
+	for pool, attributes := range mapOfPointAttributes {
+		for _, keyValue := range attributes {
+			if keyValue.Key == "thread.name" {
+				t.Errorf("points of %s are labelled by thread name", pool)
+			}
+		}
+	}
+}
+
+func TestOtlpExportCachedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	source := countingSource{}
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	defer provider.Shutdown(ctx)
+
+	exporter, err := NewOtlpExporter(NewCachedSource(&source, time.Minute), 1, THREAD_LABELS_POOL, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Register(provider.Meter(OTLP_METER_NAME)); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	//e.g. a short -interval, then the collection on shutdown
+	for i := 0; i < 3; i++ {
+		var collected metricdata.ResourceMetrics
+		if err := reader.Collect(ctx, &collected); err != nil {
+			t.Fatalf("Collect failed: %s", err)
+		}
+		if len(collected.ScopeMetrics) != 1 {
+			t.Fatalf("collection %d has no metrics", i)
+		}
+	}
+	if source.captures != 1 {
+		t.Errorf("%d snapshots captured within the minimum interval, expected 1", source.captures)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/google/pprof/profile"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/shirou/gopsutil/process"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Sample types of the profile, in the order of the values of every sample. Pick one with `go tool pprof -sample_index`.
+const (
+	// thread dumps the stack was seen in
+	PPROF_SAMPLE_TYPE_SAMPLES = "samples"
+	// time between two thread dumps, i.e. wall-clock time
+	PPROF_SAMPLE_TYPE_WALL    = "wall"
+	// CPU time of the thread since the previous thread dump, attributed to its current stack
+	PPROF_SAMPLE_TYPE_CPU     = "cpu"
+)
+
+// sample labels, to be used with -tagfocus or -tagshow
+const (
+	PPROF_LABEL_THREAD = "thread"
+	PPROF_LABEL_POOL   = "pool"
+	PPROF_LABEL_STATE  = "state"
+)
+
+// PprofProfiler turns the stacks of the successive samples of a process into a pprof profile
+type PprofProfiler struct {
+	runnableOnly bool
+	period       time.Duration
+	profile      *profile.Profile
+	functions    map[string]*profile.Function
+	locations    map[jvmdump.StackFrame]*profile.Location
+}
+
+func NewPprofProfiler(period time.Duration, runnableOnly bool) *PprofProfiler {
+	//thread dumps carry no address, the single mapping tells pprof the functions are known already
+	mapping := &profile.Mapping{ID: 1, File: "jvm", HasFunctions: true, HasFilenames: true, HasLineNumbers: true}
+
+	result := profile.Profile{
+		SampleType: []*profile.ValueType{
+			{Type: PPROF_SAMPLE_TYPE_SAMPLES, Unit: "count"},
+			{Type: PPROF_SAMPLE_TYPE_WALL, Unit: "nanoseconds"},
+			{Type: PPROF_SAMPLE_TYPE_CPU, Unit: "nanoseconds"},
+		},
+		DefaultSampleType: PPROF_SAMPLE_TYPE_WALL,
+		PeriodType:        &profile.ValueType{Type: PPROF_SAMPLE_TYPE_WALL, Unit: "nanoseconds"},
+		Period:            period.Nanoseconds(),
+		Mapping:           []*profile.Mapping{mapping},
+	}
+
+	return &PprofProfiler{runnableOnly: runnableOnly, period: period, profile: &result,
+		functions: make(map[string]*profile.Function), locations: make(map[jvmdump.StackFrame]*profile.Location)}
+}
+
+// Add adds the stacks of curr, prev being the sample before, nil for the first one
+func (this *PprofProfiler) Add(prev *StackSample, curr *StackSample) {
+	if this.profile.TimeNanos == 0 {
+		this.profile.TimeNanos = curr.Timestamp.UnixNano()
+	}
+	this.profile.DurationNanos = curr.Timestamp.UnixNano() - this.profile.TimeNanos
+
+	//the first sample stands for one period of wall-clock time, and is the baseline of the CPU deltas
+	wall := this.period
+	if prev != nil {
+		wall = curr.Timestamp.Sub(prev.Timestamp)
+	}
+
+	for nid, jthread := range curr.Threads {
+		if len(jthread.Frames) == 0 || (this.runnableOnly && jthread.State != jvmdump.THREAD_STATE_RUNNABLE) {
+			continue
+		}
+
+		var cpu time.Duration = 0
+		if prev != nil {
+			cpu = time.Duration(cpuSecondsBetween(prev.Counters[nid], curr.Counters[nid]) * float64(time.Second))
+		}
+
+		sample := profile.Sample{
+			Value: []int64{1, wall.Nanoseconds(), cpu.Nanoseconds()},
+			Label: map[string][]string{
+				PPROF_LABEL_THREAD: {jthread.ThreadName},
+				PPROF_LABEL_POOL:   {jvmdump.ThreadPool(jthread.ThreadName)},
+			},
+		}
+		if jthread.State != "" {
+			sample.Label[PPROF_LABEL_STATE] = []string{jthread.State}
+		}
+
+		//pprof lists the leaf first, as thread dumps do
+		for _, frame := range jthread.Frames {
+			sample.Location = append(sample.Location, this.location(jvmdump.ParseStackFrame(frame)))
+		}
+
+		this.profile.Sample = append(this.profile.Sample, &sample)
+	}
+}
+
+func (this *PprofProfiler) location(frame jvmdump.StackFrame) *profile.Location {
+	//the module is left out, so that the profiles of two JDK builds compare
+	frame.Module = ""
+	if location, ok := this.locations[frame]; ok {
+		return location
+	}
+
+	function, ok := this.functions[frame.Function+"\x00"+frame.File]
+	if !ok {
+		function = &profile.Function{ID: uint64(len(this.profile.Function) + 1), Name: frame.Function, SystemName: frame.Function, Filename: frame.File}
+		this.functions[frame.Function+"\x00"+frame.File] = function
+		this.profile.Function = append(this.profile.Function, function)
+	}
+
+	location := &profile.Location{ID: uint64(len(this.profile.Location) + 1), Mapping: this.profile.Mapping[0],
+		Line: []profile.Line{{Function: function, Line: int64(frame.Line)}}}
+	this.locations[frame] = location
+	this.profile.Location = append(this.profile.Location, location)
+
+	return location
+}
+
+// Write writes the profile as a gzipped protobuf, as read by `go tool pprof`
+func (this *PprofProfiler) Write(writer io.Writer) error {
+	if err := this.profile.CheckValid(); err != nil {
+		return err
+	}
+	return this.profile.Write(writer)
+}
+
+// runPprof samples the stacks of a process and writes them as a pprof profile, i.e. `ptop pprof -o jvm.pb.gz <pid>`
+func runPprof(args []string) error {
+	flags := flag.NewFlagSet("ptop pprof", flag.ContinueOnError)
+	frequency := flags.Float64("frequency", DEFAULT_FLAME_FREQUENCY, "thread dumps per second")
+	duration := flags.Duration("duration", DEFAULT_FLAME_DURATION, "time the stacks are sampled over, the sampling stops on interrupt too")
+	runnableOnly := flags.Bool("runnable-only", false, "only sample the RUNNABLE threads")
+	output := flags.String("o", "", "profile file to write")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 || *output == "" {
+		return usageErrorf("a pid and -o <file> are required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+	pid := int32(parsedPid)
+	if *frequency <= 0 {
+		return usageErrorf("frequency must be positive")
+	}
+
+	period := time.Duration(float64(time.Second) / *frequency)
+	profiler := NewPprofProfiler(period, *runnableOnly)
+	if proc, err := process.NewProcess(pid); err == nil {
+		name, _ := proc.Name()
+		profiler.profile.Comments = append(profiler.profile.Comments, fmt.Sprintf("ptop %d (%s)", pid, name))
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	fmt.Fprintf(os.Stderr, "sampling process %d at %g Hz for %s\n", pid, *frequency, *duration)
+	taken, samplingErr := SampleStacks(pid, period, *duration, signals, profiler.Add)
+	if taken == 0 {
+		if samplingErr != nil {
+			return samplingErr
+		}
+		return fmt.Errorf("no sample could be taken")
+	}
+	fmt.Fprintf(os.Stderr, "%d samples taken, view them with: go tool pprof -http=: [-sample_index=cpu] %s\n", taken, *output)
+
+	if err := writeOutput(*output, profiler.Write); err != nil {
+		return err
+	}
+
+	//what was sampled before the process exited is written anyway
+	return samplingErr
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"github.com/google/pprof/profile"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writePprof(t *testing.T, runnableOnly bool) *profile.Profile {
+	profiler := NewPprofProfiler(200*time.Millisecond, runnableOnly)
+
+	var prev *StackSample
+	for _, sample := range stackSamples() {
+		profiler.Add(prev, sample)
+		prev = sample
+	}
+
+	var buffer bytes.Buffer
+	if err := profiler.Write(&buffer); err != nil {
+		t.Fatalf("Write() Cause: [%s]", err)
+	}
+
+	parsed, err := profile.Parse(&buffer)
+	if err != nil {
+		t.Fatalf("profile.Parse() Cause: [%s]", err)
+	}
+	if err := parsed.CheckValid(); err != nil {
+		t.Fatalf("CheckValid() Cause: [%s]", err)
+	}
+	return parsed
+}
+
+func TestPprofProfiler(t *testing.T) {
+	parsed := writePprof(t, false)
+
+	var sampleTypes []string
+	for _, sampleType := range parsed.SampleType {
+		sampleTypes = append(sampleTypes, sampleType.Type)
+	}
+	if !reflect.DeepEqual(sampleTypes, []string{PPROF_SAMPLE_TYPE_SAMPLES, PPROF_SAMPLE_TYPE_WALL, PPROF_SAMPLE_TYPE_CPU}) {
+		t.Errorf("sample types = %v", sampleTypes)
+	}
+	if parsed.DurationNanos != time.Second.Nanoseconds() {
+		t.Errorf("duration = %d, expected 1s", parsed.DurationNanos)
+	}
+
+	//the frames shared by the 2 threads and the 2 samples are one location each
+	if len(parsed.Sample) != 4 || len(parsed.Location) != 4 || len(parsed.Function) != 4 {
+		t.Fatalf("%d samples, %d locations and %d functions, expected 4 each", len(parsed.Sample), len(parsed.Location), len(parsed.Function))
+	}
+
+	var values = make(map[string][][]int64)
+	for _, sample := range parsed.Sample {
+		thread := sample.Label[PPROF_LABEL_THREAD][0]
+		values[thread] = append(values[thread], sample.Value)
+
+		leaf := sample.Location[0].Line[0]
+		root := sample.Location[len(sample.Location)-1].Line[0]
+		if root.Function.Name != "java.lang.Thread.run" || root.Function.Filename != "Thread.java" || root.Line != 833 {
+			t.Errorf("root of %s = %s (%s:%d)", thread, root.Function.Name, root.Function.Filename, root.Line)
+		}
+		if thread == "worker-1" && (leaf.Function.Name != "com.acme.Worker.poll" || sample.Label[PPROF_LABEL_STATE][0] != "RUNNABLE") {
+			t.Errorf("leaf of worker-1 = %s, state %v", leaf.Function.Name, sample.Label[PPROF_LABEL_STATE])
+		}
+		if sample.Label[PPROF_LABEL_POOL][0] != "worker" {
+			t.Errorf("pool of %s = %v, expected worker", thread, sample.Label[PPROF_LABEL_POOL])
+		}
+	}
+
+	//samples, wall and cpu: the first sample stands for one period and has no CPU time
+	expected := map[string][][]int64{
+		"worker-1": {{1, 200e6, 0}, {1, 1e9, 500e6}},
+		"worker-2": {{1, 200e6, 0}, {1, 1e9, 10e6}},
+	}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("values = %v, expected %v", values, expected)
+	}
+}
+
+func TestPprofProfilerRunnableOnly(t *testing.T) {
+	parsed := writePprof(t, true)
+
+	if len(parsed.Sample) != 2 || len(parsed.Location) != 3 {
+		t.Fatalf("%d samples and %d locations, expected 2 and 3", len(parsed.Sample), len(parsed.Location))
+	}
+	for _, sample := range parsed.Sample {
+		if thread := sample.Label[PPROF_LABEL_THREAD][0]; thread != "worker-1" {
+			t.Errorf("sample of %s, expected worker-1 only", thread)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Package procfs reads the process and thread files of /proc. All paths are resolved against a configurable root, so
+// that the host's /proc can be read from a container, or a fake tree from tests.
+package procfs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const DEFAULT_PROC_ROOT = "/proc"
+
+const DEFAULT_TMP_ROOT = "/tmp"
+
+// environment variables overriding the roots, e.g. when running as a sidecar with the host's /proc and /tmp mounted elsewhere
+const PROC_ROOT_ENV = "PTOP_PROC_ROOT"
+
+const TMP_ROOT_ENV = "PTOP_TMP_ROOT"
+
+// every path of the procfs, and of the hsperfdata and attach files, is resolved against these
+var procRoot = DEFAULT_PROC_ROOT
+var tmpRoot = DEFAULT_TMP_ROOT
+
+// SetProcRoot changes where the procfs is read from, by ptop as well as by gopsutil
+func SetProcRoot(root string) {
+	procRoot = root
+	os.Setenv("HOST_PROC", root)
+}
+
+// SetTmpRoot changes the directory holding the hsperfdata and the attach files of the JVMs, /tmp by default
+func SetTmpRoot(root string) {
+	tmpRoot = root
+}
+
+// ConfigureRootsFromEnv applies PTOP_PROC_ROOT and PTOP_TMP_ROOT, if set
+func ConfigureRootsFromEnv() {
+	if root := os.Getenv(PROC_ROOT_ENV); root != "" {
+		SetProcRoot(root)
+	}
+	if root := os.Getenv(TMP_ROOT_ENV); root != "" {
+		SetTmpRoot(root)
+	}
+}
+
+// ProcPath returns <proc root>/<pid>/<elements...>
+func ProcPath(pid int32, elements ...string) string {
+	return filepath.Join(append([]string{procRoot, strconv.Itoa(int(pid))}, elements...)...)
+}
+
+// TaskPath returns <proc root>/<pid>/task/<tid>/<elements...>
+func TaskPath(pid int32, tid int32, elements ...string) string {
+	return filepath.Join(append([]string{procRoot, strconv.Itoa(int(pid)), "task", strconv.Itoa(int(tid))}, elements...)...)
+}
+
+// TmpPath returns <tmp root>/<elements...>
+func TmpPath(elements ...string) string {
+	return filepath.Join(append([]string{tmpRoot}, elements...)...)
+}
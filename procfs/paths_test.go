@@ -0,0 +1,38 @@
+package procfs
+
+import (
+	"os"
+	"testing"
+)
+
+func TestConfigureRootsFromEnv(t *testing.T) {
+	defer func() {
+		SetProcRoot(DEFAULT_PROC_ROOT)
+		SetTmpRoot(DEFAULT_TMP_ROOT)
+		os.Unsetenv(PROC_ROOT_ENV)
+		os.Unsetenv(TMP_ROOT_ENV)
+		os.Unsetenv("HOST_PROC")
+	}()
+
+	if path := ProcPath(42, "task"); path != "/proc/42/task" {
+		t.Errorf("default ProcPath = %s", path)
+	}
+
+	os.Setenv(PROC_ROOT_ENV, "/host/proc")
+	os.Setenv(TMP_ROOT_ENV, "/host/tmp")
+	ConfigureRootsFromEnv()
+
+	if path := ProcPath(42, "smaps"); path != "/host/proc/42/smaps" {
+		t.Errorf("ProcPath = %s", path)
+	}
+	if path := TaskPath(42, 43, "io"); path != "/host/proc/42/task/43/io" {
+		t.Errorf("TaskPath = %s", path)
+	}
+	if path := TmpPath("hsperfdata_ptop", "42"); path != "/host/tmp/hsperfdata_ptop/42" {
+		t.Errorf("TmpPath = %s", path)
+	}
+	//gopsutil reads the same root
+	if root := os.Getenv("HOST_PROC"); root != "/host/proc" {
+		t.Errorf("HOST_PROC = %s", root)
+	}
+}
@@ -0,0 +1,244 @@
+package procfs
+
+import (
+	"fmt"
+	"github.com/shirou/gopsutil/process"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KernelThread is a task of a process, with the address its stack starts at
+type KernelThread struct {
+	Pid 		int
+	Tid 		int
+
+	StartStack 	uint64
+}
+
+// GetListOfKernelThreadsFromProcStat lists the threads of a process along with the start of their stack, as found in their stat file
+func GetListOfKernelThreadsFromProcStat(pid int32) (*[]KernelThread, error) {
+	proc:= getProcess(pid)
+	var listOfKernelThreads []KernelThread
+
+	threadMaps, err := proc.Threads()
+	if err != nil {
+		log.Panicf("Failed to get number of tasks under %s. Cause: [%s]", ProcPath(pid, "task"), err)
+	}
+
+	for k, _ := range threadMaps {
+		lwp := KernelThread{}
+		lwp.Pid = int(pid)
+		lwp.Tid = int(k)
+		stackaddress, err := GetProcStats(pid, true, k)
+		if err != nil {
+			//break loop and return error immediately
+			return nil, err
+		}
+		//lwp.StartStack = "0x" + strconv.FormatUint(stackaddress, 16)
+		lwp.StartStack = stackaddress
+
+		listOfKernelThreads = append(listOfKernelThreads, lwp)
+
+	}
+
+	return &listOfKernelThreads, nil
+}
+
+func getProcess(pid int32) (proc *process.Process) {
+	proc, _ = SearchProcessByPid(pid)
+
+	return proc
+}
+
+// GetProcStats returns the start of the stack of the process, or of one of its threads if isLwp is set
+func GetProcStats(pid int32, isLwp bool, tid int32) (uint64, error){
+	var statPath string
+
+	if isLwp {
+		statPath = TaskPath(pid, tid, "stat")
+
+	} else {
+		statPath = ProcPath(pid, "stat")
+	}
+
+	fields, err := GetProcStatFields(pid, statPath)
+	if err != nil {
+		return 0, err
+	}
+
+	i := 1
+	for !strings.HasSuffix(fields[i], ")") {
+		i++
+	}
+
+	startstack, err := strconv.ParseUint(fields[i+26], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return startstack, nil
+}
+
+// GetThreadCpuTicks returns the user and system time of a thread, i.e. utime + stime, in clock ticks
+func GetThreadCpuTicks(pid int32, tid int32) (uint64, error) {
+	statPath := TaskPath(pid, tid, "stat")
+
+	contents, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return 0, err
+	}
+
+	return ParseCpuTicks(string(contents))
+}
+
+// ParseCpuTicks extracts utime + stime from the contents of a stat file
+func ParseCpuTicks(contents string) (uint64, error) {
+	fields := strings.Fields(contents)
+
+	i := 1
+	for i < len(fields) && !strings.HasSuffix(fields[i], ")") {
+		i++
+	}
+	if i+13 >= len(fields) {
+		return 0, fmt.Errorf("malformed stat: [%s]", contents)
+	}
+
+	utime, err := strconv.ParseUint(fields[i+12], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	stime, err := strconv.ParseUint(fields[i+13], 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return utime + stime, nil
+}
+
+// GetProcStatFields splits a stat file into its fields
+func GetProcStatFields(pid int32, statPath string) ([]string, error) {
+	contents, err := ioutil.ReadFile(statPath)
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(contents))
+	return fields, nil
+}
+
+// GetThreadIoStat reads the I/O counters of a thread
+func GetThreadIoStat(pid int32, tid int32) (*process.IOCountersStat, error) {
+	var ioPath = TaskPath(pid, tid, "io")
+
+	ioline, err := ioutil.ReadFile(ioPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseIoCounters(string(ioline))
+}
+
+// ParseIoCounters decodes the contents of an io file of /proc
+func ParseIoCounters(contents string) (*process.IOCountersStat, error) {
+	lines := strings.Split(contents, "\n")
+	ret := process.IOCountersStat{}
+
+	for _, line := range lines {
+		field := strings.Fields(line)
+		if len(field) < 2 {
+			continue
+		}
+		t, err := strconv.ParseUint(field[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		param := field[0]
+		if strings.HasSuffix(param, ":") {
+			param = param[:len(param)-1]
+		}
+		switch param {
+		case "syscr":
+			ret.ReadCount = t
+		case "syscw":
+			ret.WriteCount = t
+		case "read_bytes":
+			ret.ReadBytes = t
+		case "write_bytes":
+			ret.WriteBytes = t
+		}
+	}
+
+	return &ret, nil
+}
+
+// SearchProcessByPid returns the running process with the given pid
+func SearchProcessByPid(target int32) (*process.Process, error) {
+	listOfProcesses, _ := process.Processes()
+
+	for _, proc := range listOfProcesses {
+
+		if proc.Pid == target {
+			return proc, nil
+		}
+	}
+
+	return nil, fmt.Errorf("pid %d not found!", target)
+}
+
+// SearchProcessesByName returns the pids of the processes whose name or command line match pattern, except ptop itself
+func SearchProcessesByName(pattern string) ([]int32, error) {
+	compRegEx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	listOfProcesses, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+
+	var self = int32(os.Getpid())
+	var pids []int32
+	for _, proc := range listOfProcesses {
+		if proc.Pid == self {
+			continue
+		}
+
+		name, _ := proc.Name()
+		cmdline, _ := proc.Cmdline()
+		if compRegEx.MatchString(name) || compRegEx.MatchString(cmdline) {
+			pids = append(pids, proc.Pid)
+		}
+	}
+
+	return pids, nil
+}
+
+// ReadFileAsString reads a whole file, typically a small procfs one
+func ReadFileAsString(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	return string(contents), err
+}
+
+//64 hex digits of a docker, containerd or cri-o container id, e.g. in "0::/system.slice/docker-<id>.scope"
+var containerIdRegex = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// ContainerID returns the id of the container the process runs in, as found in its cgroup file, or an empty string if it
+// does not run in a container
+func ContainerID(pid int32) (string, error) {
+	cgroup, err := ReadFileAsString(ProcPath(pid, "cgroup"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(cgroup, "\n") {
+		if id := containerIdRegex.FindString(line); id != "" {
+			return id, nil
+		}
+	}
+
+	return "", nil
+}
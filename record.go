@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io"
+	"os"
+	"time"
+)
+
+// A recording starts with RECORDING_MAGIC and the format version, followed by length-prefixed records: the header first,
+// then one record per frame. Each record is a gzip-compressed JSON document, so frames can be indexed without decoding them
+// and a recording cut short, e.g. by a kill, stays readable up to its last complete frame.
+const RECORDING_MAGIC = "PTOPREC"
+
+const RECORDING_FORMAT_VERSION = 1
+
+// upper bound of a single record, to reject garbage lengths early
+const RECORDING_MAX_RECORD_SIZE = 256 * 1024 * 1024
+
+type RecordingHeader struct {
+	Pid       int32
+	Name      string
+	Hostname  string
+	StartedAt time.Time
+	Interval  time.Duration
+}
+
+// frameSummary derives the figures of the process overview from the frame, with rates computed against prev
+func frameSummary(frame *correlate.Frame, pid int32, name string, prev *ProcessSummary) (*ProcessSummary, error) {
+	summary := ProcessSummary{Pid: pid, Name: name, NumThreads: int32(len(frame.Tasks)), sampledAt: frame.Timestamp}
+	var err error
+
+	summary.Rollup, err = frame.Rollup()
+	if err != nil {
+		return nil, err
+	}
+	summary.Rss = summary.Rollup.Rss
+	summary.Pss = summary.Rollup.Pss
+
+	if frame.Io != "" {
+		ioStat, err := procfs.ParseIoCounters(frame.Io)
+		if err != nil {
+			return nil, err
+		}
+		summary.ReadBytes = ioStat.ReadBytes
+		summary.WriteBytes = ioStat.WriteBytes
+	}
+
+	summary.computeRates(prev)
+
+	return &summary, nil
+}
+
+func sampleFrameThread(frame *correlate.Frame, tid int) (*ThreadSample, error) {
+	task, ok := frame.Tasks[tid]
+	if !ok {
+		return nil, fmt.Errorf("thread %d was not captured", tid)
+	}
+
+	cpuTicks, err := procfs.ParseCpuTicks(task.Stat)
+	if err != nil {
+		return nil, err
+	}
+
+	ioStat, err := procfs.ParseIoCounters(task.Io)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ThreadSample{CpuTicks: cpuTicks, ReadBytes: ioStat.ReadBytes, WriteBytes: ioStat.WriteBytes, sampledAt: frame.Timestamp}, nil
+}
+
+////////////////////////////////////////////////////////////////
+
+type RecordingWriter struct {
+	file *os.File
+}
+
+// CreateRecording truncates the file at path and writes the header of a new recording to it
+func CreateRecording(path string, header *RecordingHeader) (*RecordingWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Write(append([]byte(RECORDING_MAGIC), RECORDING_FORMAT_VERSION)); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if err := writeRecord(file, header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &RecordingWriter{file: file}, nil
+}
+
+func (this *RecordingWriter) WriteFrame(frame *correlate.Frame) error {
+	return writeRecord(this.file, frame)
+}
+
+func (this *RecordingWriter) Close() error {
+	return this.file.Close()
+}
+
+func writeRecord(writer io.Writer, record interface{}) error {
+	var buffer bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&buffer)
+	if err := json.NewEncoder(gzipWriter).Encode(record); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+
+	//the record is written at once, so that an interrupted recording never ends with a partial length
+	prefixed := make([]byte, 4, 4+buffer.Len())
+	binary.BigEndian.PutUint32(prefixed, uint32(buffer.Len()))
+	_, err := writer.Write(append(prefixed, buffer.Bytes()...))
+	return err
+}
+
+// Recording is an opened recording. Only the offsets of the frames are kept in memory, frames are decoded on demand.
+type Recording struct {
+	Header  RecordingHeader
+	file    *os.File
+	offsets []int64
+}
+
+func OpenRecording(path string) (*Recording, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	recording, err := indexRecording(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	return recording, nil
+}
+
+func indexRecording(file *os.File) (*Recording, error) {
+	prologue := make([]byte, len(RECORDING_MAGIC)+1)
+	if _, err := io.ReadFull(file, prologue); err != nil {
+		return nil, fmt.Errorf("not a ptop recording")
+	}
+	if string(prologue[:len(RECORDING_MAGIC)]) != RECORDING_MAGIC {
+		return nil, fmt.Errorf("not a ptop recording")
+	}
+	if version := prologue[len(RECORDING_MAGIC)]; version > RECORDING_FORMAT_VERSION {
+		return nil, fmt.Errorf("recording format version %d is not supported, upgrade ptop", version)
+	}
+
+	recording := Recording{file: file}
+	if err := readRecord(file, &recording.Header); err != nil {
+		return nil, fmt.Errorf("malformed header: %s", err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		length, err := readRecordLength(file)
+		if err != nil {
+			break
+		}
+		if offset+4+int64(length) > fileInfo.Size() {
+			glog.Warningf("recording is truncated after %d frames", len(recording.offsets))
+			break
+		}
+
+		recording.offsets = append(recording.offsets, offset)
+		offset, err = file.Seek(int64(length), io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(recording.offsets) == 0 {
+		return nil, fmt.Errorf("recording has no frame")
+	}
+
+	return &recording, nil
+}
+
+func readRecordLength(reader io.Reader) (uint32, error) {
+	prefix := make([]byte, 4)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		return 0, err
+	}
+
+	length := binary.BigEndian.Uint32(prefix)
+	if length > RECORDING_MAX_RECORD_SIZE {
+		return 0, fmt.Errorf("record of %d bytes exceeds the limit", length)
+	}
+
+	return length, nil
+}
+
+func readRecord(reader io.Reader, record interface{}) error {
+	length, err := readRecordLength(reader)
+	if err != nil {
+		return err
+	}
+
+	//read the whole record, so that the reader is left at the next one
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return err
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer gzipReader.Close()
+
+	return json.NewDecoder(gzipReader).Decode(record)
+}
+
+func (this *Recording) Len() int {
+	return len(this.offsets)
+}
+
+func (this *Recording) Frame(index int) (*correlate.Frame, error) {
+	if index < 0 || index >= len(this.offsets) {
+		return nil, fmt.Errorf("frame %d is out of range [0, %d)", index, len(this.offsets))
+	}
+
+	var frame correlate.Frame
+	if err := readRecord(io.NewSectionReader(this.file, this.offsets[index], RECORDING_MAX_RECORD_SIZE), &frame); err != nil {
+		return nil, fmt.Errorf("frame %d: %s", index, err)
+	}
+
+	return &frame, nil
+}
+
+func (this *Recording) Close() error {
+	return this.file.Close()
+}
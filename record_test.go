@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/mcfongtw/go-ptop/correlate"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var recordStartedAt = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// writeTestRecording records numOfFrames frames of the test artifacts, a second apart, and returns the path of the recording
+func writeTestRecording(t *testing.T, numOfFrames int) string {
+	frame, err := LoadFrame(webTestThreadDump, webTestSmaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat, err := ioutil.ReadFile(filepath.Join("correlate", "testdata", "proc", "4242", "task", "4242", "stat"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioCounters, err := ioutil.ReadFile(filepath.Join("correlate", "testdata", "proc", "4242", "task", "4242", "io"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame.Tasks[4242] = &correlate.TaskCapture{Stat: string(stat), Io: string(ioCounters)}
+
+	path := filepath.Join(t.TempDir(), "test.ptoprec")
+	writer, err := CreateRecording(path, &RecordingHeader{Pid: 4242, Name: "java", Hostname: "test", StartedAt: recordStartedAt, Interval: time.Second})
+	if err != nil {
+		t.Fatalf("CreateRecording() Cause: [%s]", err)
+	}
+	for i := 0; i < numOfFrames; i++ {
+		frame.Timestamp = recordStartedAt.Add(time.Duration(i) * time.Second)
+		if err := writer.WriteFrame(frame); err != nil {
+			t.Fatalf("WriteFrame(%d) Cause: [%s]", i, err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func openTestRecording(t *testing.T, path string) *Recording {
+	recording, err := OpenRecording(path)
+	if err != nil {
+		t.Fatalf("OpenRecording() Cause: [%s]", err)
+	}
+	t.Cleanup(func() { recording.Close() })
+	return recording
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	recording := openTestRecording(t, writeTestRecording(t, 3))
+
+	header := recording.Header
+	if header.Pid != 4242 || header.Name != "java" || header.Hostname != "test" || !header.StartedAt.Equal(recordStartedAt) || header.Interval != time.Second {
+		t.Errorf("header = %+v", header)
+	}
+	if recording.Len() != 3 {
+		t.Fatalf("Len() = %d, expected 3", recording.Len())
+	}
+
+	expected, _ := LoadFrame(webTestThreadDump, webTestSmaps)
+	for i := 0; i < 3; i++ {
+		frame, err := recording.Frame(i)
+		if err != nil {
+			t.Fatalf("Frame(%d) Cause: [%s]", i, err)
+		}
+		if !frame.Timestamp.Equal(recordStartedAt.Add(time.Duration(i) * time.Second)) {
+			t.Errorf("Frame(%d) captured at %s", i, frame.Timestamp)
+		}
+		if frame.ThreadDump != expected.ThreadDump || frame.Smaps != expected.Smaps {
+			t.Errorf("Frame(%d) does not hold the recorded thread dump and smaps", i)
+		}
+		if task, ok := frame.Tasks[4242]; !ok || !strings.HasPrefix(task.Stat, "4242 (java)") {
+			t.Errorf("Frame(%d) tasks = %v", i, frame.Tasks)
+		}
+	}
+
+	if _, err := recording.Frame(3); err == nil {
+		t.Errorf("Frame(3) expected an error")
+	}
+}
+
+func TestRecordingUnknownVersion(t *testing.T) {
+	path := writeTestRecording(t, 1)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(RECORDING_MAGIC)] = RECORDING_FORMAT_VERSION + 1
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenRecording(path); err == nil || !strings.Contains(err.Error(), "version 2 is not supported") {
+		t.Errorf("OpenRecording() error = %v, expected an unsupported version", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("PTOPRUN"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenRecording(path); err == nil || !strings.Contains(err.Error(), "not a ptop recording") {
+		t.Errorf("OpenRecording() error = %v, expected not a ptop recording", err)
+	}
+}
+
+func TestRecordingTruncated(t *testing.T) {
+	path := writeTestRecording(t, 3)
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the last frame is cut short, as if ptop record was killed while writing it
+	if err := os.Truncate(path, fileInfo.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+
+	recording := openTestRecording(t, path)
+	if recording.Len() != 2 {
+		t.Fatalf("Len() = %d, expected the 2 complete frames", recording.Len())
+	}
+	if frame, err := recording.Frame(1); err != nil || !frame.Timestamp.Equal(recordStartedAt.Add(time.Second)) {
+		t.Errorf("Frame(1) = %v, %v", frame, err)
+	}
+}
+
+func TestReplaySourceSamples(t *testing.T) {
+	numOfFrames := HISTORY_LENGTH + 10
+	source := NewReplaySource(openTestRecording(t, writeTestRecording(t, numOfFrames)))
+
+	for i := 0; i < numOfFrames; i++ {
+		if _, err := source.GetProcessSummary(4242, nil); err != nil {
+			t.Fatalf("GetProcessSummary() at frame %d Cause: [%s]", i, err)
+		}
+		source.History()
+		if len(source.samples) > HISTORY_LENGTH+1 {
+			t.Fatalf("%d frames cached at frame %d, expected at most %d", len(source.samples), i, HISTORY_LENGTH+1)
+		}
+		source.Seek(1)
+	}
+
+	//scrubbing back to the start drops the frames past the cursor
+	source.Seek(-numOfFrames)
+	if _, err := source.SampleThread(4242, 4242); err != nil {
+		t.Fatalf("SampleThread() Cause: [%s]", err)
+	}
+	if len(source.samples) != 1 {
+		t.Errorf("%d frames cached at the start, expected 1", len(source.samples))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"sync"
+	"time"
+)
+
+// ReplaySource feeds the TUI from a recording, one frame at a time. The cursor is moved by the user.
+type ReplaySource struct {
+	recording  *Recording
+
+	mutex      sync.Mutex
+	cursor     int
+	//last decoded frame, as the tabs of the TUI all ask for the same one
+	frame      *correlate.Frame
+	frameIndex int
+	//figures needed to rebuild the trends, by frame index, so that scrubbing does not decode the frames over and over.
+	//Only the frames of the trends leading to the cursor are kept.
+	samples    map[int]*frameSamples
+}
+
+type frameSamples struct {
+	summary *ProcessSummary
+	threads map[int]*ThreadSample
+}
+
+func NewReplaySource(recording *Recording) *ReplaySource {
+	return &ReplaySource{recording: recording, frameIndex: -1, samples: make(map[int]*frameSamples)}
+}
+
+func (this *ReplaySource) Pid() int32 {
+	return this.recording.Header.Pid
+}
+
+// Seek moves the cursor by delta frames, within the bounds of the recording, and tells whether it has moved
+func (this *ReplaySource) Seek(delta int) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	cursor := this.cursor + delta
+	if cursor < 0 {
+		cursor = 0
+	}
+	if cursor > this.recording.Len()-1 {
+		cursor = this.recording.Len() - 1
+	}
+
+	moved := cursor != this.cursor
+	this.cursor = cursor
+	return moved
+}
+
+// Position returns the index of the current frame, the number of frames and the time the current frame was captured
+func (this *ReplaySource) Position() (int, int, time.Time) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var timestamp time.Time
+	if frame, err := this.frameAt(this.cursor); err == nil {
+		timestamp = frame.Timestamp
+	}
+
+	return this.cursor, this.recording.Len(), timestamp
+}
+
+//caller must hold mutex
+func (this *ReplaySource) frameAt(index int) (*correlate.Frame, error) {
+	if this.frame != nil && this.frameIndex == index {
+		return this.frame, nil
+	}
+
+	frame, err := this.recording.Frame(index)
+	if err != nil {
+		return nil, err
+	}
+	this.frame, this.frameIndex = frame, index
+
+	return frame, nil
+}
+
+//caller must hold mutex
+func (this *ReplaySource) samplesAt(index int) (*frameSamples, error) {
+	if samples, ok := this.samples[index]; ok {
+		return samples, nil
+	}
+
+	frame, err := this.frameAt(index)
+	if err != nil {
+		return nil, err
+	}
+
+	summary, err := frameSummary(frame, this.Pid(), this.recording.Header.Name, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := frameSamples{summary: summary, threads: make(map[int]*ThreadSample)}
+	for tid := range frame.Tasks {
+		if sample, err := sampleFrameThread(frame, tid); err == nil {
+			samples.threads[tid] = sample
+		}
+	}
+
+	for cached := range this.samples {
+		if cached < this.cursor-HISTORY_LENGTH || cached > this.cursor {
+			delete(this.samples, cached)
+		}
+	}
+	this.samples[index] = &samples
+
+	return &samples, nil
+}
+
+func (this *ReplaySource) checkPid(pid int32) error {
+	if pid != this.Pid() {
+		return fmt.Errorf("pid %d was not recorded", pid)
+	}
+	return nil
+}
+
+// GetProcessSummary ignores prev, rates are computed against the frame preceding the current one in the recording
+func (this *ReplaySource) GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error) {
+	if err := this.checkPid(pid); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	samples, err := this.samplesAt(this.cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := *samples.summary
+	if this.cursor > 0 {
+		if prevSamples, err := this.samplesAt(this.cursor - 1); err == nil {
+			summary.computeRates(prevSamples.summary)
+		}
+	}
+
+	return &summary, nil
+}
+
+func (this *ReplaySource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	if err := this.checkPid(pid); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	frame, err := this.frameAt(this.cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return correlate.BuildSnapshot(pid, frame)
+}
+
+func (this *ReplaySource) GetPerfData(pid int32) (*hsperf.PerfData, error) {
+	if err := this.checkPid(pid); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	frame, err := this.frameAt(this.cursor)
+	if err != nil {
+		return nil, err
+	}
+	if len(frame.PerfData) == 0 {
+		return nil, fmt.Errorf("hsperfdata was not recorded")
+	}
+
+	return hsperf.ParsePerfData(frame.PerfData)
+}
+
+func (this *ReplaySource) SampleThread(pid int32, tid int) (*ThreadSample, error) {
+	if err := this.checkPid(pid); err != nil {
+		return nil, err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	samples, err := this.samplesAt(this.cursor)
+	if err != nil {
+		return nil, err
+	}
+	sample, ok := samples.threads[tid]
+	if !ok {
+		return nil, fmt.Errorf("thread %d was not recorded", tid)
+	}
+
+	return sample, nil
+}
+
+// History rebuilds the trends out of the frames up to the current one
+func (this *ReplaySource) History() *ProcessHistory {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	history := NewProcessHistory()
+
+	start := this.cursor - HISTORY_LENGTH
+	if start < 0 {
+		start = 0
+	}
+
+	var liveTids = make(map[int]bool)
+	for i := start; i <= this.cursor; i++ {
+		samples, err := this.samplesAt(i)
+		if err != nil {
+			continue
+		}
+
+		history.RecordRollup(samples.summary.Rollup)
+		liveTids = make(map[int]bool)
+		for tid, sample := range samples.threads {
+			history.RecordThread(tid, sample)
+			liveTids[tid] = true
+		}
+	}
+	history.Prune(liveTids)
+
+	return history
+}
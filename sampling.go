@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StackSample is a thread dump along with the counters of every task of the process, read right after it
+type StackSample struct {
+	Timestamp time.Time
+	// Java threads by nid
+	Threads   map[int]jvmdump.JavaThread
+	// every task, including the ones which are not Java threads, by tid
+	Counters  map[int]*ThreadSample
+}
+
+// TakeStackSample takes a thread dump of the process and reads the counters of its tasks
+func TakeStackSample(pid int32) (*StackSample, error) {
+	sample := StackSample{Timestamp: time.Now()}
+
+	threadDump, err := attach.GetJavaThreadDump(pid)
+	if err != nil {
+		return nil, err
+	}
+	sample.Threads, err = jvmdump.ParseThreadDump(strings.NewReader(threadDump))
+	if err != nil {
+		return nil, err
+	}
+
+	sample.Counters, err = SampleTasks(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sample, nil
+}
+
+// SampleStacks takes a StackSample of the process every period for duration, or until a signal is received on stop,
+// and hands each one to handle along with the previous one, nil for the first. It returns the number of samples taken.
+func SampleStacks(pid int32, period time.Duration, duration time.Duration, stop <-chan os.Signal, handle func(prev *StackSample, curr *StackSample)) (int, error) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	deadline := time.After(duration)
+
+	var prev *StackSample
+	var taken = 0
+	for {
+		sample, err := TakeStackSample(pid)
+		if err != nil {
+			if _, searchErr := procfs.SearchProcessByPid(pid); searchErr != nil {
+				return taken, fmt.Errorf("process %d is gone after %d samples", pid, taken)
+			}
+			glog.Warningf("TakeStackSample(%d) Cause: [%s]", pid, err)
+		} else {
+			handle(prev, sample)
+			prev = sample
+			taken++
+		}
+
+		//a slow thread dump delays the next sample rather than piling samples up
+		select {
+		case <-stop:
+			return taken, nil
+		case <-deadline:
+			return taken, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// SampleTasks reads the CPU time and I/O counters of every task of the process
+func SampleTasks(pid int32) (map[int]*ThreadSample, error) {
+	listOfTasks, err := ioutil.ReadDir(procfs.ProcPath(pid, "task"))
+	if err != nil {
+		return nil, err
+	}
+
+	var result = make(map[int]*ThreadSample)
+	for _, task := range listOfTasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		sample, err := SampleThread(pid, tid)
+		if err != nil {
+			//thread has exited meanwhile
+			glog.V(3).Infof("SampleThread(%d, %d) Cause: [%s]", pid, tid, err)
+			continue
+		}
+		result[tid] = sample
+	}
+
+	return result, nil
+}
+
+// cpuSecondsBetween is the CPU time a task spent between two samples, 0 if either misses it
+func cpuSecondsBetween(prev *ThreadSample, curr *ThreadSample) float64 {
+	if prev == nil || curr == nil || curr.CpuTicks < prev.CpuTicks {
+		return 0
+	}
+	return float64(curr.CpuTicks-prev.CpuTicks) / CLOCK_TICKS_PER_SECOND
+}
+
+// taskName is the name of a thread, from the thread dump if it is a Java thread, from its comm otherwise
+func taskName(pid int32, tid int, threads map[int]jvmdump.JavaThread) string {
+	if jthread, ok := threads[tid]; ok {
+		return jthread.ThreadName
+	}
+
+	comm, err := procfs.ReadFileAsString(procfs.TaskPath(pid, int32(tid), "comm"))
+	if err != nil {
+		return "<exited>"
+	}
+	return strings.TrimSpace(comm)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DEFAULT_SERVE_LISTEN = ":9779"
+
+// version 0.0.4 of the Prometheus text exposition format
+const PROMETHEUS_CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// metricFamily is a metric of the exposition, with its samples summed by label values
+type metricFamily struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	values     map[string]float64
+	labels     map[string][]string
+}
+
+func newMetricFamily(name string, kind string, help string, labelNames ...string) *metricFamily {
+	return &metricFamily{name: name, kind: kind, help: help, labelNames: labelNames,
+		values: make(map[string]float64), labels: make(map[string][]string)}
+}
+
+// Add adds value to the sample of the given label values, so that threads sharing a name end up in one series
+func (this *metricFamily) Add(value float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\x00")
+	if _, ok := this.labels[key]; !ok {
+		this.labels[key] = labelValues
+	}
+	this.values[key] += value
+}
+
+func (this *metricFamily) Write(writer io.Writer) {
+	if len(this.values) == 0 {
+		return
+	}
+
+	fmt.Fprintf(writer, "# HELP %s %s\n", this.name, escapeHelp(this.help))
+	fmt.Fprintf(writer, "# TYPE %s %s\n", this.name, this.kind)
+
+	var keys []string
+	for key := range this.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var pairs []string
+		for i, labelValue := range this.labels[key] {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", this.labelNames[i], escapeLabelValue(labelValue)))
+		}
+		fmt.Fprintf(writer, "%s{%s} %s\n", this.name, strings.Join(pairs, ","), strconv.FormatFloat(this.values[key], 'g', -1, 64))
+	}
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// MetricsExporter exposes the snapshots of a process as Prometheus metrics. The source is expected to be a CachedSource,
+// as a snapshot pauses the JVM and there may be several Prometheus scraping it.
+type MetricsExporter struct {
+	source       SnapshotSource
+	pid          int32
+	threadLabels string
+	//keeps the per-thread counters monotonic from one scrape to the next
+	aggregator   *ThreadMetricsAggregator
+
+	//concurrent scrapes take turns with the aggregator
+	mutex        sync.Mutex
+}
+
+func NewMetricsExporter(source SnapshotSource, pid int32, threadLabels string, maxThreads int) (*MetricsExporter, error) {
+	aggregator, err := NewThreadMetricsAggregator(threadLabels, maxThreads)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MetricsExporter{source: source, pid: pid, threadLabels: threadLabels, aggregator: aggregator}, nil
+}
+
+func (this *MetricsExporter) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	startedAt := time.Now()
+	snapshot, err := this.source.GetSnapshot(this.pid)
+	if err != nil {
+		glog.Errorf("GetSnapshot(%d) Cause: [%s]", this.pid, err)
+		http.Error(writer, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	writer.Header().Set("Content-Type", PROMETHEUS_CONTENT_TYPE)
+	this.WriteMetrics(writer, snapshot)
+
+	scrapeDuration := newMetricFamily("ptop_scrape_duration_seconds", "gauge", "Time taken to capture and correlate the snapshot, or to get the cached one.", "pid")
+	scrapeDuration.Add(time.Since(startedAt).Seconds(), this.pidLabel())
+	scrapeDuration.Write(writer)
+}
+
+func (this *MetricsExporter) pidLabel() string {
+	return strconv.Itoa(int(this.pid))
+}
+
+// WriteMetrics writes the metrics of a snapshot in the Prometheus text format, the snapshots being passed in order
+func (this *MetricsExporter) WriteMetrics(writer io.Writer, snapshot *correlate.Snapshot) {
+	pid := this.pidLabel()
+
+	for _, family := range this.threadMetrics(snapshot) {
+		family.Write(writer)
+	}
+
+	threadStates := newMetricFamily("ptop_threads", "gauge", "Java threads by state.", "pid", "state")
+	for _, state := range jvmdump.ListOfThreadStates {
+		threadStates.Add(0, pid, state)
+	}
+	for _, jthread := range snapshot.Threads {
+		if jthread.State != "" {
+			threadStates.Add(1, pid, jthread.State)
+		}
+	}
+	threadStates.Write(writer)
+
+	memorySize := newMetricFamily("ptop_memory_size_bytes", "gauge", "Virtual size of the mappings by memory category.", "pid", "category")
+	memoryRss := newMetricFamily("ptop_memory_rss_bytes", "gauge", "Resident set size of the mappings by memory category.", "pid", "category")
+	memoryPss := newMetricFamily("ptop_memory_pss_bytes", "gauge", "Proportional set size of the mappings by memory category.", "pid", "category")
+	memoryMappings := newMetricFamily("ptop_memory_mappings", "gauge", "Number of mappings by memory category.", "pid", "category")
+	nmtCommitted := newMetricFamily("ptop_nmt_committed_bytes", "gauge", "Memory committed by memory category, as reported by native memory tracking.", "pid", "category")
+
+	var nativeMemory = snapshot.Layout.NativeMemory
+	for _, summary := range correlate.SummarizeMemoryCategories(snapshot.Segments, nativeMemory) {
+		memorySize.Add(float64(summary.Size * 1024), pid, summary.Category)
+		memoryRss.Add(float64(summary.Rss * 1024), pid, summary.Category)
+		memoryPss.Add(float64(summary.Pss * 1024), pid, summary.Category)
+		memoryMappings.Add(float64(summary.Mappings), pid, summary.Category)
+		if summary.HasNmt {
+			nmtCommitted.Add(float64(summary.NmtCommitted * 1024), pid, summary.Category)
+		}
+	}
+
+	for _, family := range []*metricFamily{memorySize, memoryRss, memoryPss, memoryMappings, nmtCommitted} {
+		family.Write(writer)
+	}
+}
+
+func (this *MetricsExporter) threadMetrics(snapshot *correlate.Snapshot) []*metricFamily {
+	if this.threadLabels == THREAD_LABELS_NONE {
+		return nil
+	}
+
+	var labelNames = []string{"pid", "pool"}
+	if this.threadLabels == THREAD_LABELS_NAME {
+		labelNames = append(labelNames, "thread")
+	}
+	cpuSeconds := newMetricFamily("ptop_thread_cpu_seconds_total", "counter", "CPU time spent by the Java threads.", labelNames...)
+	readBytes := newMetricFamily("ptop_thread_read_bytes_total", "counter", "Bytes read from storage by the Java threads.", labelNames...)
+	writeBytes := newMetricFamily("ptop_thread_write_bytes_total", "counter", "Bytes written to storage by the Java threads.", labelNames...)
+	stackRss := newMetricFamily("ptop_thread_stack_rss_bytes", "gauge", "Resident set size of the stacks of the Java threads.", labelNames...)
+
+	pid := this.pidLabel()
+	for _, metrics := range this.aggregator.Aggregate(snapshot) {
+		var labelValues = []string{pid, metrics.Pool}
+		if this.threadLabels == THREAD_LABELS_NAME {
+			labelValues = append(labelValues, metrics.Thread)
+		}
+
+		cpuSeconds.Add(metrics.CpuSeconds, labelValues...)
+		readBytes.Add(float64(metrics.ReadBytes), labelValues...)
+		writeBytes.Add(float64(metrics.WriteBytes), labelValues...)
+		stackRss.Add(float64(metrics.StackRss * 1024), labelValues...)
+	}
+
+	return []*metricFamily{cpuSeconds, readBytes, writeBytes, stackRss}
+}
+
+// runServe exposes the metrics of a process to Prometheus, i.e. `ptop serve <pid> -listen :9779`
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("ptop serve", flag.ContinueOnError)
+	listen := flags.String("listen", DEFAULT_SERVE_LISTEN, "address to serve /metrics on")
+	threadLabels := flags.String("thread-labels", THREAD_LABELS_NAME, "labels of the per-thread metrics: name, pool or none")
+	maxThreads := flags.Int("max-threads", DEFAULT_EXPORT_MAX_THREADS, "thread names labelled, the busiest on the first scrape then new ones as they show up, the other threads are summed as thread=\"other\", 0 for no limit")
+	minInterval := flags.Duration("min-interval", DEFAULT_SNAPSHOT_INTERVAL_IN_SECOND * time.Second, "minimum time between two snapshots of the process, scrapes in between are served the latest one")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+
+	exporter, err := NewMetricsExporter(NewCachedSource(&LiveSource{}, *minInterval), int32(parsedPid), *threadLabels, *maxThreads)
+	if err != nil {
+		return usageError{err}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exporter)
+	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		if request.URL.Path != "/" {
+			http.NotFound(writer, request)
+			return
+		}
+		fmt.Fprintf(writer, "<html><body><h1>ptop</h1><a href=\"/metrics\">Metrics of process %d</a></body></html>\n", parsedPid)
+	})
+
+	fmt.Fprintf(os.Stderr, "serving metrics of process %d on %s/metrics\n", parsedPid, *listen)
+	return http.ListenAndServe(*listen, mux)
+}
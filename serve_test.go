@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/mcfongtw/go-ptop/correlate"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSource takes a while to capture a snapshot, like a thread dump over the attach socket, and counts the captures
+type countingSource struct {
+	LiveSource
+	captures int32
+}
+
+func (this *countingSource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	atomic.AddInt32(&this.captures, 1)
+	time.Sleep(20 * time.Millisecond)
+	return testSnapshot(testThread{1, "main", 500, 0, 0}), nil
+}
+
+// scrape runs count concurrent scrapes of the exporter and returns their bodies
+func scrape(t *testing.T, exporter *MetricsExporter, count int) []string {
+	server := httptest.NewServer(exporter)
+	defer server.Close()
+
+	var bodies = make([]string, count)
+	var wg sync.WaitGroup
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			response, err := http.Get(server.URL)
+			if err != nil {
+				t.Errorf("scrape failed: %s", err)
+				return
+			}
+			defer response.Body.Close()
+			body, _ := ioutil.ReadAll(response.Body)
+			if response.StatusCode != http.StatusOK {
+				t.Errorf("scrape returned %d: %s", response.StatusCode, body)
+			}
+			bodies[i] = string(body)
+		}(i)
+	}
+	wg.Wait()
+
+	return bodies
+}
+
+func TestMetricsExporterCachedSnapshot(t *testing.T) {
+	source := countingSource{}
+	exporter, err := NewMetricsExporter(NewCachedSource(&source, time.Minute), 1, THREAD_LABELS_NAME, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//e.g. two Prometheus replicas, and then some
+	for _, body := range scrape(t, exporter, 4) {
+		if !strings.Contains(body, `ptop_thread_cpu_seconds_total{pid="1",pool="main",thread="main"} 5`) {
+			t.Errorf("metrics = [%s]", body)
+		}
+	}
+	scrape(t, exporter, 1)
+	if source.captures != 1 {
+		t.Errorf("%d snapshots captured within the minimum interval, expected 1", source.captures)
+	}
+
+	uncached, err := NewMetricsExporter(NewCachedSource(&source, 0), 1, THREAD_LABELS_NAME, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scrape(t, uncached, 3)
+	if source.captures != 4 {
+		t.Errorf("%d snapshots captured without a minimum interval, expected 4", source.captures)
+	}
+}
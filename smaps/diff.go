@@ -0,0 +1,103 @@
+package smaps
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kinds of change of a mapping between two snapshots
+const (
+	MAPPING_NEW     = "new"
+	MAPPING_REMOVED = "removed"
+	MAPPING_GROWN   = "grown"
+	MAPPING_SHRUNK  = "shrunk"
+)
+
+// MappingDelta is a mapping which changed between two snapshots, with its deltas in kB
+type MappingDelta struct {
+	// the current mapping, or the previous one if removed
+	Segment   ProcessMemorySegment
+	Change    string
+	SizeDelta int64
+	RssDelta  int64
+	PssDelta  int64
+}
+
+// mappingKey identifies a mapping across snapshots. Only its end moves while it is resized in place, e.g. by brk() or
+// mremap(); another file or an anonymous region mapped at the same address is another mapping.
+type mappingKey struct {
+	start uint64
+	path  string
+	inode uint64
+}
+
+func keyOf(segment ProcessMemorySegment) mappingKey {
+	//a file deleted while mapped is still the same mapping
+	return mappingKey{start: segment.StackStart, path: strings.TrimSuffix(segment.Path, " (deleted)"), inode: segment.Inode}
+}
+
+// DiffMemorySegments compares two lists of mappings, matched by their start address, path and inode. Unchanged
+// mappings are left out; the largest RSS changes come first, then the largest PSS ones.
+func DiffMemorySegments(previous *[]ProcessMemorySegment, current *[]ProcessMemorySegment) []MappingDelta {
+	var previousSegments = make(map[mappingKey]ProcessMemorySegment)
+	for _, segment := range *previous {
+		previousSegments[keyOf(segment)] = segment
+	}
+
+	var result []MappingDelta
+	for _, segment := range *current {
+		prevSegment, ok := previousSegments[keyOf(segment)]
+		if !ok {
+			result = append(result, MappingDelta{Segment: segment, Change: MAPPING_NEW,
+				SizeDelta: int64(segment.Size), RssDelta: int64(segment.Rss), PssDelta: int64(segment.Pss)})
+			continue
+		}
+		delete(previousSegments, keyOf(segment))
+
+		delta := MappingDelta{Segment: segment,
+			SizeDelta: int64(segment.Size) - int64(prevSegment.Size),
+			RssDelta:  int64(segment.Rss) - int64(prevSegment.Rss),
+			PssDelta:  int64(segment.Pss) - int64(prevSegment.Pss)}
+		//the size decides, then the RSS, then the PSS, which changes alone when pages stop or start being shared,
+		//e.g. as a sibling process exits
+		var growth = delta.SizeDelta
+		if growth == 0 {
+			growth = delta.RssDelta
+		}
+		if growth == 0 {
+			growth = delta.PssDelta
+		}
+		switch {
+		case growth > 0:
+			delta.Change = MAPPING_GROWN
+		case growth < 0:
+			delta.Change = MAPPING_SHRUNK
+		default:
+			continue
+		}
+		result = append(result, delta)
+	}
+
+	for _, segment := range previousSegments {
+		result = append(result, MappingDelta{Segment: segment, Change: MAPPING_REMOVED,
+			SizeDelta: -int64(segment.Size), RssDelta: -int64(segment.Rss), PssDelta: -int64(segment.Pss)})
+	}
+
+	abs := func(val int64) int64 {
+		if val < 0 {
+			return -val
+		}
+		return val
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if abs(result[i].RssDelta) != abs(result[j].RssDelta) {
+			return abs(result[i].RssDelta) > abs(result[j].RssDelta)
+		}
+		if abs(result[i].PssDelta) != abs(result[j].PssDelta) {
+			return abs(result[i].PssDelta) > abs(result[j].PssDelta)
+		}
+		return result[i].Segment.StackStart < result[j].Segment.StackStart
+	})
+
+	return result
+}
@@ -0,0 +1,88 @@
+package smaps
+
+import (
+	"github.com/shirou/gopsutil/process"
+	"reflect"
+	"testing"
+)
+
+func testSegment(start uint64, size uint64, rss uint64, pss uint64) ProcessMemorySegment {
+	return ProcessMemorySegment{MemoryMapsStat: process.MemoryMapsStat{Size: size, Rss: rss, Pss: pss}, StackStart: start}
+}
+
+func testFileSegment(start uint64, size uint64, rss uint64, path string, inode uint64) ProcessMemorySegment {
+	segment := testSegment(start, size, rss, rss)
+	segment.Path = path
+	segment.Inode = inode
+	return segment
+}
+
+func TestDiffMemorySegments(t *testing.T) {
+	previous := []ProcessMemorySegment{
+		testSegment(0x1000, 100, 50, 50),
+		testSegment(0x2000, 100, 50, 50),
+		testSegment(0x3000, 100, 50, 50),
+		testSegment(0x4000, 100, 80, 40),
+		testSegment(0x5000, 100, 80, 80),
+		testSegment(0x6000, 100, 10, 10),
+	}
+	current := []ProcessMemorySegment{
+		//unchanged
+		testSegment(0x1000, 100, 50, 50),
+		//resized in place
+		testSegment(0x2000, 200, 50, 50),
+		//paged in
+		testSegment(0x3000, 100, 70, 70),
+		//no longer shared, only the PSS changes
+		testSegment(0x4000, 100, 80, 80),
+		//shared by a new sibling
+		testSegment(0x5000, 100, 80, 40),
+		testSegment(0x7000, 100, 30, 30),
+	}
+
+	expected := []MappingDelta{
+		{Segment: testSegment(0x7000, 100, 30, 30), Change: MAPPING_NEW, SizeDelta: 100, RssDelta: 30, PssDelta: 30},
+		{Segment: testSegment(0x3000, 100, 70, 70), Change: MAPPING_GROWN, SizeDelta: 0, RssDelta: 20, PssDelta: 20},
+		{Segment: testSegment(0x6000, 100, 10, 10), Change: MAPPING_REMOVED, SizeDelta: -100, RssDelta: -10, PssDelta: -10},
+		{Segment: testSegment(0x4000, 100, 80, 80), Change: MAPPING_GROWN, SizeDelta: 0, RssDelta: 0, PssDelta: 40},
+		{Segment: testSegment(0x5000, 100, 80, 40), Change: MAPPING_SHRUNK, SizeDelta: 0, RssDelta: 0, PssDelta: -40},
+		{Segment: testSegment(0x2000, 200, 50, 50), Change: MAPPING_GROWN, SizeDelta: 100, RssDelta: 0, PssDelta: 0},
+	}
+
+	if actual := DiffMemorySegments(&previous, &current); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("deltas =\n%+v\nexpected\n%+v", actual, expected)
+	}
+}
+
+func TestDiffMemorySegmentsRemapped(t *testing.T) {
+	previous := []ProcessMemorySegment{
+		testFileSegment(0x1000, 100, 60, "/tmp/a.bin", 11),
+		testFileSegment(0x2000, 100, 40, "/lib/b.so", 12),
+		testFileSegment(0x3000, 100, 20, "/tmp/c.bin", 13),
+		testFileSegment(0x4000, 100, 10, "/tmp/d.bin", 14),
+	}
+	current := []ProcessMemorySegment{
+		//unmapped, then an anonymous region mapped at the same address
+		testFileSegment(0x1000, 100, 50, "", 0),
+		//the file was replaced, e.g. by an upgrade
+		testFileSegment(0x2000, 100, 30, "/lib/b.so", 22),
+		//unmapped, then another file mapped at the same address
+		testFileSegment(0x3000, 100, 20, "/tmp/e.bin", 15),
+		//deleted while mapped, still the same mapping
+		testFileSegment(0x4000, 200, 10, "/tmp/d.bin (deleted)", 14),
+	}
+
+	expected := []MappingDelta{
+		{Segment: previous[0], Change: MAPPING_REMOVED, SizeDelta: -100, RssDelta: -60, PssDelta: -60},
+		{Segment: current[0], Change: MAPPING_NEW, SizeDelta: 100, RssDelta: 50, PssDelta: 50},
+		{Segment: previous[1], Change: MAPPING_REMOVED, SizeDelta: -100, RssDelta: -40, PssDelta: -40},
+		{Segment: current[1], Change: MAPPING_NEW, SizeDelta: 100, RssDelta: 30, PssDelta: 30},
+		{Segment: current[2], Change: MAPPING_NEW, SizeDelta: 100, RssDelta: 20, PssDelta: 20},
+		{Segment: previous[2], Change: MAPPING_REMOVED, SizeDelta: -100, RssDelta: -20, PssDelta: -20},
+		{Segment: current[3], Change: MAPPING_GROWN, SizeDelta: 100, RssDelta: 0, PssDelta: 0},
+	}
+
+	if actual := DiffMemorySegments(&previous, &current); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("deltas =\n%+v\nexpected\n%+v", actual, expected)
+	}
+}
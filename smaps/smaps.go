@@ -0,0 +1,420 @@
+// Package smaps parses /proc/<pid>/smaps and smaps_rollup into one segment per mapping.
+package smaps
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/procfs"
+	"github.com/shirou/gopsutil/process"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProcessMemorySegment is one mapping of a process, or a group of them
+type ProcessMemorySegment struct {
+	//Type embedded from process.MemoryMapsStat
+	process.MemoryMapsStat
+	StackStart   uint64 `json:"startStack"`
+	StackStop    uint64 `json:"stackStop"`
+	FramePerm	 string `json:"framePerm"`
+	//mmap, anon, the pseudo path of a special mapping, e.g. [heap], or whatever a consumer identified it as, e.g. JavaThread
+	FrameType    string `json:"frameType"`
+	//set by a consumer, e.g. correlate.ClassifyMemorySegments
+	Category     string `json:"category"`
+	//offset into the backing file, its device as major:minor and its inode; 0 for anonymous mappings
+	Offset       uint64 `json:"offset"`
+	Device       string `json:"device"`
+	Inode        uint64 `json:"inode"`
+	//number of mappings summed up into this one, i.e. 1 unless grouped
+	MappingCount int    `json:"mappingCount"`
+
+	//smaps counters not covered by process.MemoryMapsStat, in kB unless noted otherwise
+	KernelPageSize uint64 `json:"kernelPageSize"`
+	MMUPageSize    uint64 `json:"mmuPageSize"`
+	PssDirty       uint64 `json:"pssDirty"`
+	Ksm            uint64 `json:"ksm"`
+	LazyFree       uint64 `json:"lazyFree"`
+	AnonHugePages  uint64 `json:"anonHugePages"`
+	ShmemPmdMapped uint64 `json:"shmemPmdMapped"`
+	FilePmdMapped  uint64 `json:"filePmdMapped"`
+	SharedHugetlb  uint64 `json:"sharedHugetlb"`
+	PrivateHugetlb uint64 `json:"privateHugetlb"`
+	SwapPss        uint64 `json:"swapPss"`
+	Locked         uint64 `json:"locked"`
+	//0 or 1
+	THPeligible    uint64 `json:"thpEligible"`
+	ProtectionKey  uint64 `json:"protectionKey"`
+	VmFlags        VmFlagSet `json:"vmFlags"`
+}
+
+// MemoryRollup is the process-wide sum of all mappings, in kB
+type MemoryRollup struct {
+	ProcessMemorySegment
+	//only available from smaps_rollup, i.e. not from an smaps based fallback
+	PssAnon  uint64 `json:"pssAnon"`
+	PssFile  uint64 `json:"pssFile"`
+	PssShmem uint64 `json:"pssShmem"`
+}
+
+// GetMemoryRollup reads /proc/(pid)/smaps_rollup, which the kernel computes much more cheaply than the full smaps.
+// Kernels older than 4.14 do not provide it, in which case smaps is summed up instead.
+func GetMemoryRollup(pid int32) (*MemoryRollup, error) {
+	rollupPath := procfs.ProcPath(pid, "smaps_rollup")
+	file, err := os.Open(rollupPath)
+	if os.IsNotExist(err) {
+		glog.V(3).Infof("%s is not available, summing up smaps", rollupPath)
+		return sumMemoryMaps(pid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseRollup(file)
+}
+
+// ParseRollup reads the smaps_rollup format
+func ParseRollup(reader io.Reader) (*MemoryRollup, error) {
+	var rollup MemoryRollup
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 || isSmapsHeader(line) {
+			continue
+		}
+
+		field := strings.Fields(line)
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case "Pss_Anon:":
+			rollup.PssAnon, _ = strconv.ParseUint(field[1], 10, 64)
+		case "Pss_File:":
+			rollup.PssFile, _ = strconv.ParseUint(field[1], 10, 64)
+		case "Pss_Shmem:":
+			rollup.PssShmem, _ = strconv.ParseUint(field[1], 10, 64)
+		default:
+			parseSmapsField(&rollup.ProcessMemorySegment, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &rollup, nil
+}
+
+func sumMemoryMaps(pid int32) (*MemoryRollup, error) {
+	listOfMemorySegments, err := GetProcessMemoryMaps(false, pid)
+	if err != nil {
+		return nil, err
+	}
+
+	return SumMemorySegments(listOfMemorySegments), nil
+}
+
+// SumMemorySegments adds up the counters of all segments
+func SumMemorySegments(listOfMemorySegments *[]ProcessMemorySegment) *MemoryRollup {
+	var rollup MemoryRollup
+	for _, segment := range *listOfMemorySegments {
+		rollup.Add(segment)
+	}
+
+	return &rollup
+}
+
+// MemoryMaps get memory maps from /proc/(pid)/smaps
+func GetProcessMemoryMaps(grouped bool, pid int32) (*[]ProcessMemorySegment, error) {
+	return GetProcessMemoryMapsWithContext(context.Background(), grouped, pid)
+}
+
+// GetProcessMemoryMapsWithContext returns one segment per mapping, or with grouped, one segment per backing file and per type
+// of anonymous mapping
+func GetProcessMemoryMapsWithContext(ctx context.Context, grouped bool, pid int32) (*[]ProcessMemorySegment, error) {
+	smapsPath := procfs.ProcPath(pid, "smaps")
+	file, err := os.Open(smapsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ret, err := Parse(ctx, file)
+	if err != nil || !grouped {
+		return ret, err
+	}
+
+	return GroupMemorySegments(ret), nil
+}
+
+// GroupMemorySegments collapses the mappings backed by the same file, and the non file-backed ones of the same type
+// (anon, [heap], [stack], ...), summing up their counters. Groups are ordered by their first mapping.
+func GroupMemorySegments(listOfMemorySegments *[]ProcessMemorySegment) *[]ProcessMemorySegment {
+	var ret []ProcessMemorySegment
+	var indexes = make(map[string]int)
+
+	for _, segment := range *listOfMemorySegments {
+		key := segment.FrameType
+		if strings.HasPrefix(segment.Path, "/") {
+			key = segment.Path
+		}
+
+		i, ok := indexes[key]
+		if !ok {
+			group := segment
+			group.Path = key
+			indexes[key] = len(ret)
+			ret = append(ret, group)
+			continue
+		}
+
+		group := &ret[i]
+		group.Add(segment)
+		if segment.StackStart < group.StackStart {
+			group.StackStart = segment.StackStart
+		}
+		if segment.StackStop > group.StackStop {
+			group.StackStop = segment.StackStop
+		}
+	}
+
+	return &ret
+}
+
+// Add sums up the counters of other into this
+func (this *ProcessMemorySegment) Add(other ProcessMemorySegment) {
+	this.Size += other.Size
+	this.Rss += other.Rss
+	this.Pss += other.Pss
+	this.PssDirty += other.PssDirty
+	this.SharedClean += other.SharedClean
+	this.SharedDirty += other.SharedDirty
+	this.PrivateClean += other.PrivateClean
+	this.PrivateDirty += other.PrivateDirty
+	this.Referenced += other.Referenced
+	this.Anonymous += other.Anonymous
+	this.Ksm += other.Ksm
+	this.LazyFree += other.LazyFree
+	this.AnonHugePages += other.AnonHugePages
+	this.ShmemPmdMapped += other.ShmemPmdMapped
+	this.FilePmdMapped += other.FilePmdMapped
+	this.SharedHugetlb += other.SharedHugetlb
+	this.PrivateHugetlb += other.PrivateHugetlb
+	this.Swap += other.Swap
+	this.SwapPss += other.SwapPss
+	this.Locked += other.Locked
+	this.MappingCount += other.MappingCount
+}
+
+// Parse streams the smaps format: each mapping starts with a header line, i.e.
+// "<start>-<stop> <perms> <offset> <dev> <inode> [<path>]", followed by one "<Field>: <value>" line per counter.
+func Parse(ctx context.Context, reader io.Reader) (*[]ProcessMemorySegment, error) {
+	var ret []ProcessMemorySegment
+	var current *ProcessMemorySegment
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) == 0 {
+			continue
+		}
+
+		if isSmapsHeader(line) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			if current != nil {
+				ret = append(ret, *current)
+			}
+
+			segment, err := parseSmapsHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			current = segment
+			continue
+		}
+
+		if current == nil {
+			return nil, fmt.Errorf("smaps field outside of any mapping: [%s]", line)
+		}
+		parseSmapsField(current, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		ret = append(ret, *current)
+	}
+
+	return &ret, nil
+}
+
+// isSmapsHeader tells a mapping header from a field line: headers start with the hex address range whereas
+// field names are followed by a colon
+func isSmapsHeader(line string) bool {
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return false
+	}
+	addrs := strings.Split(line[:end], "-")
+	if len(addrs) != 2 {
+		return false
+	}
+	for _, addr := range addrs {
+		if _, err := strconv.ParseUint(addr, 16, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSmapsHeader(line string) (*ProcessMemorySegment, error) {
+	m := ProcessMemorySegment{}
+
+	//the path is everything after the 5th field, and may itself contain spaces
+	rest := line
+	var fields [5]string
+	for i := 0; i < len(fields); i++ {
+		rest = strings.TrimLeft(rest, " ")
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			end = len(rest)
+		}
+		fields[i] = rest[:end]
+		rest = rest[end:]
+	}
+	if fields[4] == "" {
+		return nil, fmt.Errorf("malformed smaps header: [%s]", line)
+	}
+
+	var err error
+	var stacks = strings.Split(fields[0], "-")
+	m.StackStart, err = strconv.ParseUint(stacks[0], 16, 64)
+	if err != nil {
+		glog.Errorf("Parsing stackStart failed! - %s", err)
+		return nil, err
+	}
+	m.StackStop, err = strconv.ParseUint(stacks[1], 16, 64)
+	if err != nil {
+		glog.Errorf("Parsing stackStop failed! - %s", err)
+		return nil, err
+	}
+	m.FramePerm = fields[1]
+	m.MappingCount = 1
+	m.Offset, err = strconv.ParseUint(fields[2], 16, 64)
+	if err != nil {
+		glog.Errorf("Parsing offset failed! - %s", err)
+		return nil, err
+	}
+	m.Device = fields[3]
+	m.Inode, err = strconv.ParseUint(fields[4], 10, 64)
+	if err != nil {
+		glog.Errorf("Parsing inode failed! - %s", err)
+		return nil, err
+	}
+	m.Path = strings.TrimLeft(rest, " ")
+
+	switch {
+	case strings.HasPrefix(m.Path, "/"):
+		m.FrameType = "mmap"
+	case m.Path == "":
+		m.FrameType = "anon"
+	default:
+		m.FrameType = m.Path
+	}
+
+	return &m, nil
+}
+
+// IsDeleted tells whether the backing file has been unlinked while still being mapped
+func (this *ProcessMemorySegment) IsDeleted() bool {
+	return this.Inode != 0 && strings.HasSuffix(this.Path, " (deleted)")
+}
+
+// parseSmapsField stores one "<Field>: <value>" line into m. Fields unknown to ptop are skipped, so that newer
+// kernels do not break the parsing.
+func parseSmapsField(m *ProcessMemorySegment, line string) {
+	colon := strings.IndexByte(line, ':')
+	if colon < 0 {
+		glog.V(3).Infof("Skipping malformed smaps line [%s]", line)
+		return
+	}
+	name := line[:colon]
+	value := strings.Fields(line[colon+1:])
+
+	if name == "VmFlags" {
+		m.VmFlags = ParseVmFlags(value)
+		return
+	}
+
+	if len(value) == 0 {
+		return
+	}
+	t, err := strconv.ParseUint(value[0], 10, 64)
+	if err != nil {
+		glog.V(3).Infof("Skipping non numeric smaps field [%s]", line)
+		return
+	}
+
+	switch name {
+	case "Size":
+		m.Size = t
+	case "KernelPageSize":
+		m.KernelPageSize = t
+	case "MMUPageSize":
+		m.MMUPageSize = t
+	case "Rss":
+		m.Rss = t
+	case "Pss":
+		m.Pss = t
+	case "Pss_Dirty":
+		m.PssDirty = t
+	case "Shared_Clean":
+		m.SharedClean = t
+	case "Shared_Dirty":
+		m.SharedDirty = t
+	case "Private_Clean":
+		m.PrivateClean = t
+	case "Private_Dirty":
+		m.PrivateDirty = t
+	case "Referenced":
+		m.Referenced = t
+	case "Anonymous":
+		m.Anonymous = t
+	case "KSM":
+		m.Ksm = t
+	case "LazyFree":
+		m.LazyFree = t
+	case "AnonHugePages":
+		m.AnonHugePages = t
+	case "ShmemPmdMapped":
+		m.ShmemPmdMapped = t
+	case "FilePmdMapped":
+		m.FilePmdMapped = t
+	case "Shared_Hugetlb":
+		m.SharedHugetlb = t
+	case "Private_Hugetlb":
+		m.PrivateHugetlb = t
+	case "Swap":
+		m.Swap = t
+	case "SwapPss":
+		m.SwapPss = t
+	case "Locked":
+		m.Locked = t
+	case "THPeligible":
+		m.THPeligible = t
+	case "ProtectionKey":
+		m.ProtectionKey = t
+	default:
+		glog.V(3).Infof("Skipping unknown smaps field [%s]", name)
+	}
+}
@@ -0,0 +1,146 @@
+package smaps
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// go test ./smaps -update rewrites the expected outputs from the current parser, to be reviewed before committing
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// goldenSegment spells the VmFlags out, so that the golden files read like smaps does
+type goldenSegment struct {
+	ProcessMemorySegment
+	VmFlags string `json:"vmFlags"`
+}
+
+func toGolden(value interface{}) []byte {
+	var golden interface{}
+	switch value := value.(type) {
+	case *[]ProcessMemorySegment:
+		var listOfSegments = []goldenSegment{}
+		for _, segment := range *value {
+			listOfSegments = append(listOfSegments, goldenSegment{ProcessMemorySegment: segment, VmFlags: segment.VmFlags.String()})
+		}
+		golden = listOfSegments
+	default:
+		golden = value
+	}
+
+	result, _ := json.MarshalIndent(golden, "", "  ")
+	return append(result, '\n')
+}
+
+// compareGolden compares actual with the .json file named after input
+func compareGolden(t *testing.T, input string, actual []byte) {
+	goldenPath := strings.TrimSuffix(input, filepath.Ext(input)) + ".json"
+
+	if *update {
+		if err := ioutil.WriteFile(goldenPath, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("%s does not match %s:\n%s", input, goldenPath, actual)
+	}
+}
+
+func TestParseGolden(t *testing.T) {
+	listOfInputs, err := filepath.Glob(filepath.Join("testdata", "*.smaps"))
+	if err != nil || len(listOfInputs) == 0 {
+		t.Fatalf("no smaps found in testdata: %v", err)
+	}
+
+	for _, input := range listOfInputs {
+		file, err := os.Open(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		listOfSegments, err := Parse(context.Background(), file)
+		file.Close()
+		if err != nil {
+			t.Errorf("%s: Parse failed: %s", input, err)
+			continue
+		}
+
+		compareGolden(t, input, toGolden(listOfSegments))
+	}
+}
+
+func TestParseRollupGolden(t *testing.T) {
+	input := filepath.Join("testdata", "rollup.smaps_rollup")
+	file, err := os.Open(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	rollup, err := ParseRollup(file)
+	if err != nil {
+		t.Fatalf("ParseRollup failed: %s", err)
+	}
+
+	compareGolden(t, input, toGolden(rollup))
+}
+
+// TestParseMappings checks the cases the golden files are there for, so that a regenerated golden file does not hide them
+func TestParseMappings(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "jvm.smaps"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	listOfSegments, err := Parse(context.Background(), file)
+	if err != nil {
+		t.Fatalf("Parse failed: %s", err)
+	}
+	if len(*listOfSegments) != 8 {
+		t.Fatalf("%d mappings parsed, expected 8", len(*listOfSegments))
+	}
+	segments := *listOfSegments
+
+	//no path
+	if anon := segments[2]; anon.Path != "" || anon.FrameType != "anon" || anon.THPeligible != 1 || anon.ProtectionKey != 3 || !anon.VmFlags.Has(VM_FLAG_HG) {
+		t.Errorf("anonymous mapping = %+v", anon)
+	}
+	//spaces and (deleted) are part of the path
+	if deleted := segments[3]; deleted.Path != "/dev/shm/jvm buffer (deleted)" || !deleted.IsDeleted() || deleted.KernelPageSize != 2048 {
+		t.Errorf("deleted mapping = %+v", deleted)
+	}
+	if spaced := segments[4]; spaced.Path != "/opt/my app/lib/libnative helper.so" || spaced.Offset != 0x200000 || spaced.Device != "103:02" || spaced.Inode != 2883617 {
+		t.Errorf("mapping with spaces = %+v", spaced)
+	}
+	//the last mapping is flushed along with its fields
+	if last := segments[7]; last.Path != "[vsyscall]" || last.Size != 4 || last.Locked != 7 || last.VmFlags.String() != "ex" {
+		t.Errorf("last mapping = %+v", last)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	if _, err := Parse(context.Background(), strings.NewReader("Size:  4 kB\n")); err == nil {
+		t.Errorf("a field outside of any mapping is accepted")
+	}
+	if _, err := Parse(context.Background(), strings.NewReader("00400000-00401000 r-xp 00000000\n")); err == nil {
+		t.Errorf("a truncated header is accepted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Parse(ctx, strings.NewReader("00400000-00401000 r-xp 00000000 fd:01 1050731 /bin/java\n")); err != context.Canceled {
+		t.Errorf("error = %v, expected %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,173 @@
+package smaps
+
+import (
+	"github.com/golang/glog"
+	"strings"
+)
+
+// VmFlagSet is the decoded VmFlags line of a mapping, see show_smap_vma_flags() in fs/proc/task_mmu.c
+type VmFlagSet uint64
+
+const (
+	VM_FLAG_RD VmFlagSet = 1 << iota
+	VM_FLAG_WR
+	VM_FLAG_EX
+	VM_FLAG_SH
+	VM_FLAG_MR
+	VM_FLAG_MW
+	VM_FLAG_ME
+	VM_FLAG_MS
+	VM_FLAG_GD
+	VM_FLAG_PF
+	VM_FLAG_DW
+	VM_FLAG_LO
+	VM_FLAG_IO
+	VM_FLAG_SR
+	VM_FLAG_RR
+	VM_FLAG_DC
+	VM_FLAG_DE
+	VM_FLAG_AC
+	VM_FLAG_NR
+	VM_FLAG_HT
+	VM_FLAG_SF
+	VM_FLAG_NL
+	VM_FLAG_AR
+	VM_FLAG_WF
+	VM_FLAG_DD
+	VM_FLAG_SD
+	VM_FLAG_MM
+	VM_FLAG_HG
+	VM_FLAG_NH
+	VM_FLAG_MG
+	VM_FLAG_BT
+	VM_FLAG_MT
+	VM_FLAG_UM
+	VM_FLAG_UW
+	VM_FLAG_SS
+	VM_FLAG_SL
+)
+
+type vmFlagDefinition struct {
+	flag        VmFlagSet
+	mnemonic    string
+	description string
+}
+
+// in the order the kernel prints them
+var vmFlagDefinitions = []vmFlagDefinition{
+	{VM_FLAG_RD, "rd", "readable"},
+	{VM_FLAG_WR, "wr", "writeable"},
+	{VM_FLAG_EX, "ex", "executable"},
+	{VM_FLAG_SH, "sh", "shared"},
+	{VM_FLAG_MR, "mr", "may read"},
+	{VM_FLAG_MW, "mw", "may write"},
+	{VM_FLAG_ME, "me", "may execute"},
+	{VM_FLAG_MS, "ms", "may share"},
+	{VM_FLAG_GD, "gd", "stack segment grows down"},
+	{VM_FLAG_PF, "pf", "pure PFN range"},
+	{VM_FLAG_DW, "dw", "disabled write to the mapped file"},
+	{VM_FLAG_LO, "lo", "pages are locked in memory"},
+	{VM_FLAG_IO, "io", "memory mapped I/O area"},
+	{VM_FLAG_SR, "sr", "sequential read advise provided"},
+	{VM_FLAG_RR, "rr", "random read advise provided"},
+	{VM_FLAG_DC, "dc", "do not copy area on fork"},
+	{VM_FLAG_DE, "de", "do not expand area on remapping"},
+	{VM_FLAG_AC, "ac", "area is accountable"},
+	{VM_FLAG_NR, "nr", "swap space is not reserved for the area"},
+	{VM_FLAG_HT, "ht", "area uses huge tlb pages"},
+	{VM_FLAG_SF, "sf", "synchronous page fault"},
+	{VM_FLAG_NL, "nl", "non-linear mapping"},
+	{VM_FLAG_AR, "ar", "architecture specific flag"},
+	{VM_FLAG_WF, "wf", "wipe on fork"},
+	{VM_FLAG_DD, "dd", "do not include area into core dump"},
+	{VM_FLAG_SD, "sd", "soft-dirty flag"},
+	{VM_FLAG_MM, "mm", "mixed map area"},
+	{VM_FLAG_HG, "hg", "huge page advise flag"},
+	{VM_FLAG_NH, "nh", "no-huge page advise flag"},
+	{VM_FLAG_MG, "mg", "mergeable advise flag"},
+	{VM_FLAG_BT, "bt", "arm64 BTI guarded page"},
+	{VM_FLAG_MT, "mt", "arm64 MTE allocation tags are enabled"},
+	{VM_FLAG_UM, "um", "userfaultfd missing tracking"},
+	{VM_FLAG_UW, "uw", "userfaultfd wr-protect tracking"},
+	{VM_FLAG_SS, "ss", "shadow stack page"},
+	{VM_FLAG_SL, "sl", "sealed"},
+}
+
+// ParseVmFlags decodes the mnemonics of a VmFlags line. Mnemonics unknown to ptop are skipped.
+func ParseVmFlags(mnemonics []string) VmFlagSet {
+	var flags VmFlagSet
+
+	for _, mnemonic := range mnemonics {
+		found := false
+		for _, definition := range vmFlagDefinitions {
+			if definition.mnemonic == mnemonic {
+				flags |= definition.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			glog.V(3).Infof("Skipping unknown VmFlags mnemonic [%s]", mnemonic)
+		}
+	}
+
+	return flags
+}
+
+// Has tells whether all the bits of flag are set
+func (this VmFlagSet) Has(flag VmFlagSet) bool {
+	return this&flag == flag
+}
+
+// String returns the flags as the kernel prints them, e.g. "rd wr mr mw me ac"
+func (this VmFlagSet) String() string {
+	var mnemonics []string
+	for _, definition := range vmFlagDefinitions {
+		if this.Has(definition.flag) {
+			mnemonics = append(mnemonics, definition.mnemonic)
+		}
+	}
+	return strings.Join(mnemonics, " ")
+}
+
+// Describe returns the mnemonic and the meaning of each flag in the set
+func (this VmFlagSet) Describe() [][2]string {
+	var descriptions [][2]string
+	for _, definition := range vmFlagDefinitions {
+		if this.Has(definition.flag) {
+			descriptions = append(descriptions, [2]string{definition.mnemonic, definition.description})
+		}
+	}
+	return descriptions
+}
+
+// MappingFilter selects the mappings shown in the MMap, Others and All tabs
+type MappingFilter struct {
+	Name  string
+	Match func(segment *ProcessMemorySegment) bool
+}
+
+func isAnonymousMapping(segment *ProcessMemorySegment) bool {
+	return segment.Inode == 0 && !strings.HasPrefix(segment.Path, "[")
+}
+
+// ListOfMappingFilters are the filters of the mapping tabs, in display order
+var ListOfMappingFilters = []MappingFilter{
+	{"all mappings", func(segment *ProcessMemorySegment) bool { return true }},
+	// JIT compiled code, but also possibly injected code
+	{"executable anonymous", func(segment *ProcessMemorySegment) bool {
+		return segment.VmFlags.Has(VM_FLAG_EX) && isAnonymousMapping(segment)
+	}},
+	{"writeable and executable", func(segment *ProcessMemorySegment) bool {
+		return segment.VmFlags.Has(VM_FLAG_WR | VM_FLAG_EX)
+	}},
+	{"hugepage-backed", func(segment *ProcessMemorySegment) bool {
+		return segment.VmFlags.Has(VM_FLAG_HT) || segment.AnonHugePages > 0 || segment.ShmemPmdMapped > 0 || segment.FilePmdMapped > 0
+	}},
+	{"locked", func(segment *ProcessMemorySegment) bool {
+		return segment.VmFlags.Has(VM_FLAG_LO)
+	}},
+	{"shared", func(segment *ProcessMemorySegment) bool {
+		return segment.VmFlags.Has(VM_FLAG_SH)
+	}},
+}
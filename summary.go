@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/mcfongtw/go-ptop/smaps"
+	"github.com/shirou/gopsutil/process"
+	"time"
+)
+
+// ProcessSummary holds the process-level figures shown in the multi-process overview
+type ProcessSummary struct {
+	Pid        int32
+	Name       string
+	Rss        uint64
+	Pss        uint64
+	NumThreads int32
+	ReadBytes  uint64
+	WriteBytes uint64
+	// bytes per second since the previous sample
+	ReadRate  float64
+	WriteRate float64
+	Rollup    *smaps.MemoryRollup
+
+	sampledAt time.Time
+}
+
+// GetProcessSummary samples the given process. I/O rates are computed against prev, which may be nil for the first sample.
+func GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error) {
+	proc, err := process.NewProcess(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := ProcessSummary{Pid: pid, sampledAt: time.Now()}
+
+	summary.Name, err = proc.Name()
+	if err != nil {
+		return nil, err
+	}
+
+	summary.NumThreads, err = proc.NumThreads()
+	if err != nil {
+		return nil, err
+	}
+
+	summary.Rollup, err = smaps.GetMemoryRollup(pid)
+	if err != nil {
+		return nil, err
+	}
+	summary.Rss = summary.Rollup.Rss
+	summary.Pss = summary.Rollup.Pss
+
+	ioStat, err := proc.IOCounters()
+	if err != nil {
+		return nil, err
+	}
+	summary.ReadBytes = ioStat.ReadBytes
+	summary.WriteBytes = ioStat.WriteBytes
+
+	summary.computeRates(prev)
+
+	return &summary, nil
+}
+
+func (this *ProcessSummary) computeRates(prev *ProcessSummary) {
+	if prev != nil && prev.Pid == this.Pid && !prev.sampledAt.IsZero() {
+		elapsed := this.sampledAt.Sub(prev.sampledAt).Seconds()
+		if elapsed > 0 && this.ReadBytes >= prev.ReadBytes && this.WriteBytes >= prev.WriteBytes {
+			this.ReadRate = float64(this.ReadBytes-prev.ReadBytes) / elapsed
+			this.WriteRate = float64(this.WriteBytes-prev.WriteBytes) / elapsed
+		}
+	}
+}
@@ -5,7 +5,14 @@ import (
 	"github.com/gizak/termui"
 	"github.com/gizak/termui/extra"
 	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/nmt"
+	"github.com/mcfongtw/go-ptop/smaps"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -26,178 +33,535 @@ func NewTableTabElement(width int) (*TableTabElement) {
 	return &TableTabElement{Table: table}
 }
 
-func (this *TableTabElement) UpdateThread(listOfMemorySegments *[]TaskMemorySegment) {
-	//reset rows
-	rows := [][] string {}
-	this.Table.Rows = rows
+func (this *TableTabElement) reset(header []string) {
+	this.Table.Rows = [][] string {header}
+	//row colors are allocated lazily by termui for the first set of rows only
+	this.Table.FgColors = nil
+	this.Table.BgColors = nil
+}
+
+func (this *TableTabElement) UpdateSummary(listOfSummaries []*ProcessSummary, selected int) {
+	this.reset([] string {"PID", "Name", "RSS", "PSS", "Threads", "Rd B/s", "Wrt B/s"})
+
+	for i := 0; i < len(listOfSummaries); i++ {
+		summary := listOfSummaries[i]
+		if summary == nil {
+			continue
+		}
+
+		row := [] string{StringfyInteger(int(summary.Pid)), summary.Name, StringfyUinteger64(summary.Rss), StringfyUinteger64(summary.Pss),
+			StringfyInteger(int(summary.NumThreads)), StringfyRate(summary.ReadRate), StringfyRate(summary.WriteRate)}
+		this.Table.Rows = append(this.Table.Rows, row)
+	}
+
+	this.highlight(selected)
+}
+
+func (this *TableTabElement) UpdatePicker(listOfJavaProcesses []attach.JavaProcess, selected int) {
+	this.reset([] string {"PID", "User", "Main Class", "Uptime", "Attachable"})
+
+	for i := 0; i < len(listOfJavaProcesses); i++ {
+		jproc := listOfJavaProcesses[i]
 
-	header := [] string {"stackStart", "stackStop", "task ID", "Wrt Cnt", "Rd Cnt", "Wrt Byte", "Rd Byte", "Type", "Path"}
-	this.Table.Rows = append(this.Table.Rows, header)
+		row := [] string{StringfyInteger(int(jproc.Pid)), jproc.User, jproc.MainClass, StringfyDuration(jproc.Uptime), fmt.Sprintf("%v", jproc.Attachable)}
+		this.Table.Rows = append(this.Table.Rows, row)
+	}
+
+	this.highlight(selected)
+}
+
+//highlight the selected data row, i.e. excluding header
+func (this *TableTabElement) highlight(selected int) {
+	this.Table.FgColors = make([]termui.Attribute, len(this.Table.Rows))
+	this.Table.BgColors = make([]termui.Attribute, len(this.Table.Rows))
+	if selected >= 0 && selected + 1 < len(this.Table.Rows) {
+		this.Table.FgColors[selected + 1] = termui.ColorWhite
+		this.Table.BgColors[selected + 1] = termui.ColorBlue
+	}
+}
+
+//mark shows the given data rows in color, on top of the selection if any
+func (this *TableTabElement) mark(rows map[int]bool, color termui.Attribute) {
+	if len(rows) == 0 {
+		return
+	}
+	if len(this.Table.FgColors) != len(this.Table.Rows) {
+		this.highlight(-1)
+	}
+	for row, marked := range rows {
+		if marked && row + 1 < len(this.Table.Rows) {
+			this.Table.FgColors[row + 1] = color
+		}
+	}
+}
+
+//UpdateThread with a history adds the CPU usage and the trends of CPU and I/O of every thread
+func (this *TableTabElement) UpdateThread(listOfMemorySegments *[]correlate.TaskMemorySegment, history *ProcessHistory) {
+	this.reset([] string {"stackStart", "stackStop", "task ID", "CPU %", "CPU Trend", "I/O Trend", "Wrt Cnt", "Rd Cnt", "Wrt Byte", "Rd Byte", "Type", "Path"})
 
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		row := [] string{Stringify64BitAddress(segment.stackStart), Stringify64BitAddress(segment.stackStop), StringfyInteger(segment.taskID),
-			StringfyUinteger64(segment.WriteCount), StringfyUinteger64(segment.ReadCount), StringfyUinteger64(segment.WriteBytes), StringfyUinteger64(segment.ReadBytes), segment.frameType, segment.Path}
+		threadHistory := &ThreadHistory{}
+		if history != nil {
+			threadHistory = history.ThreadHistory(segment.TaskID)
+		}
+
+		row := [] string{Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), StringfyInteger(segment.TaskID),
+			fmt.Sprintf("%.1f", threadHistory.Cpu.Last()), StringfySparkline(threadHistory.Cpu.Values(), SPARKLINE_WIDTH), StringfySparkline(threadHistory.IoRate.Values(), SPARKLINE_WIDTH),
+			StringfyUinteger64(segment.WriteCount), StringfyUinteger64(segment.ReadCount), StringfyUinteger64(segment.WriteBytes), StringfyUinteger64(segment.ReadBytes), segment.FrameType, segment.Path}
 		this.Table.Rows = append(this.Table.Rows, row)
 	}
 }
 
-func (this *TableTabElement) UpdateMmap(listOfMemorySegments *[]TaskMemorySegment) {
-	//reset rows
-	rows := [][] string {}
-	this.Table.Rows = rows
-
-	header := [] string {"stackStart", "stackStop", "RSS", "Size", "Perm", "Type", "Path"}
-	this.Table.Rows = append(this.Table.Rows, header)
+//UpdateMmap with showDetails adds the offset, device and inode columns
+func (this *TableTabElement) UpdateMmap(listOfMemorySegments *[]correlate.TaskMemorySegment, showDetails bool) {
+	if !showDetails {
+		this.reset([] string {"stackStart", "stackStop", "RSS", "Size", "Perm", "Type", "Path"})
+	} else {
+		this.reset([] string {"stackStart", "stackStop", "RSS", "Size", "Perm", "Offset", "Dev", "Inode", "Deleted", "Type", "Path"})
+	}
 
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		row := [] string{Stringify64BitAddress(segment.stackStart), Stringify64BitAddress(segment.stackStop), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Size),
-			segment.framePerm, segment.frameType, segment.Path}
+		var row [] string
+		if !showDetails {
+			row = [] string{Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Size),
+				segment.FramePerm, segment.FrameType, segment.Path}
+		} else {
+			row = [] string{Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Size),
+				segment.FramePerm, fmt.Sprintf("0x%x", segment.Offset), segment.Device, StringfyUinteger64(segment.Inode), fmt.Sprintf("%v", segment.IsDeleted()),
+				segment.FrameType, segment.Path}
+		}
 		this.Table.Rows = append(this.Table.Rows, row)
 
 	}
 }
 
-func (this *TableTabElement) Update(listOfMemorySegments *[]TaskMemorySegment) {
-	//reset rows
-	rows := [][] string {}
-	this.Table.Rows = rows
+//UpdateGrouped lists the grouped segments, the largest RSS first
+func (this *TableTabElement) UpdateGrouped(listOfMemorySegments *[]smaps.ProcessMemorySegment) {
+	this.reset([] string {"Mappings", "Size", "RSS", "PSS", "Shr Clean", "Shr Dirty", "Prv Clean", "Prv Dirty", "Swap", "Type", "Path"})
 
-	header := [] string {"stackStart", "stackStop", "RSS", "Size", "Type", "Path"}
-	this.Table.Rows = append(this.Table.Rows, header)
+	sortedSegments := append([]smaps.ProcessMemorySegment{}, *listOfMemorySegments...)
+	sort.SliceStable(sortedSegments, func(i, j int) bool { return sortedSegments[i].Rss > sortedSegments[j].Rss })
+
+	for _, segment := range sortedSegments {
+		row := [] string{StringfyInteger(segment.MappingCount), StringfyUinteger64(segment.Size), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Pss),
+			StringfyUinteger64(segment.SharedClean), StringfyUinteger64(segment.SharedDirty), StringfyUinteger64(segment.PrivateClean), StringfyUinteger64(segment.PrivateDirty),
+			StringfyUinteger64(segment.Swap), segment.FrameType, segment.Path}
+		this.Table.Rows = append(this.Table.Rows, row)
+	}
+}
+
+func (this *TableTabElement) Update(listOfMemorySegments *[]correlate.TaskMemorySegment) {
+	this.reset([] string {"stackStart", "stackStop", "RSS", "Size", "Type", "Category", "Path"})
 
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		row := [] string{Stringify64BitAddress(segment.stackStart), Stringify64BitAddress(segment.stackStop), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Size),
-			segment.frameType, segment.Path}
+		row := [] string{Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), StringfyUinteger64(segment.Rss), StringfyUinteger64(segment.Size),
+			segment.FrameType, segment.Category, segment.Path}
 		this.Table.Rows = append(this.Table.Rows, row)
 
 	}
 }
 
-func associateKernelThreadAndJavaThread(pid int32, listOfKernelThreads *[]KernelThread, mapOfJavaThreads map[int]JavaThread, listOfMemorySegments *[]ProcessMemorySegment)(*[]TaskMemorySegment) {
-	var foundSegments = make(map[int]*TaskMemorySegment)
-	var listOfTaskSegments []TaskMemorySegment
+//UpdateDetail decodes everything known about a single mapping
+func (this *TableTabElement) UpdateDetail(segment *correlate.TaskMemorySegment) {
+	this.reset([] string {"Field", "Value"})
 
-	//Copy ProcessMemorySegment to TaskMemorySegment
-	for i := 0; i < len(*listOfMemorySegments); i++ {
-		segment := (*listOfMemorySegments)[i]
-		listOfTaskSegments = append(listOfTaskSegments, NewTaskMemorySegment(segment))
+	appendRow := func(field string, value string) {
+		this.Table.Rows = append(this.Table.Rows, [] string{field, value})
 	}
 
-	//associate KernelThreads and ProcessMemorySegment
-	for i := 0; i < len(*listOfKernelThreads); i++ {
-		kthread := (*listOfKernelThreads)[i]
+	appendRow("Address", Stringify64BitAddress(segment.StackStart) + " - " + Stringify64BitAddress(segment.StackStop))
+	appendRow("Perm", segment.FramePerm)
+	appendRow("Offset", fmt.Sprintf("0x%x", segment.Offset))
+	appendRow("Dev", segment.Device)
+	appendRow("Inode", StringfyUinteger64(segment.Inode))
+	appendRow("Path", segment.Path)
+	appendRow("Type", segment.FrameType)
+	appendRow("Category", segment.Category)
+	if segment.FrameType == "JavaThread" {
+		appendRow("Task ID", StringfyInteger(segment.TaskID))
+		appendRow("Wrt Cnt", StringfyUinteger64(segment.WriteCount))
+		appendRow("Rd Cnt", StringfyUinteger64(segment.ReadCount))
+		appendRow("Wrt Byte", StringfyUinteger64(segment.WriteBytes))
+		appendRow("Rd Byte", StringfyUinteger64(segment.ReadBytes))
+	}
 
-		for j := 0; j < len(listOfTaskSegments); j++ {
-			//call by reference of ProcessMemorySegment
-			segment := &((listOfTaskSegments)[j])
-			if kthread.startStack >= segment.stackStart && kthread.startStack <= segment.stackStop {
-				foundSegments[kthread.tid] = segment
-				break
-			}
+	for _, counter := range []struct {
+		name  string
+		value uint64
+	}{
+		{"Size", segment.Size}, {"KernelPageSize", segment.KernelPageSize}, {"MMUPageSize", segment.MMUPageSize}, {"Rss", segment.Rss},
+		{"Pss", segment.Pss}, {"Pss_Dirty", segment.PssDirty}, {"Shared_Clean", segment.SharedClean}, {"Shared_Dirty", segment.SharedDirty},
+		{"Private_Clean", segment.PrivateClean}, {"Private_Dirty", segment.PrivateDirty}, {"Referenced", segment.Referenced},
+		{"Anonymous", segment.Anonymous}, {"KSM", segment.Ksm}, {"LazyFree", segment.LazyFree}, {"AnonHugePages", segment.AnonHugePages},
+		{"ShmemPmdMapped", segment.ShmemPmdMapped}, {"FilePmdMapped", segment.FilePmdMapped}, {"Shared_Hugetlb", segment.SharedHugetlb},
+		{"Private_Hugetlb", segment.PrivateHugetlb}, {"Swap", segment.Swap}, {"SwapPss", segment.SwapPss}, {"Locked", segment.Locked},
+	} {
+		appendRow(counter.name, StringfyUinteger64(counter.value) + " kB")
+	}
+	appendRow("THPeligible", StringfyUinteger64(segment.THPeligible))
+	appendRow("ProtectionKey", StringfyUinteger64(segment.ProtectionKey))
+
+	appendRow("VmFlags", segment.VmFlags.String())
+	for _, description := range segment.VmFlags.Describe() {
+		appendRow("  " + description[0], description[1])
+	}
+}
+
+//UpdateThreadDiff lists the stuck threads first, then the threads which changed since the previous thread dump
+func (this *TableTabElement) UpdateThreadDiff(listOfChanges []jvmdump.ThreadChange, threads map[int]jvmdump.JavaThread, stuckDetector *jvmdump.StuckDetector) {
+	this.reset([] string {"Change", "nid", "Name", "State", "Top Frame"})
+
+	var colors = map[string]termui.Attribute{jvmdump.THREAD_NEW: termui.ColorGreen, jvmdump.THREAD_EXITED: termui.ColorRed, jvmdump.THREAD_STATE_CHANGED: termui.ColorYellow}
+	this.Table.FgColors = [] termui.Attribute {this.Table.FgColor}
+
+	topFrame := func(jthread jvmdump.JavaThread) string {
+		if len(jthread.Frames) == 0 {
+			return ""
+		}
+		return jthread.Frames[0]
+	}
+
+	var listOfNids []int
+	for nid := range threads {
+		if stuckDetector.Stuck(nid) {
+			listOfNids = append(listOfNids, nid)
+		}
+	}
+	sort.Ints(listOfNids)
+	for _, nid := range listOfNids {
+		jthread := threads[nid]
+		row := [] string{fmt.Sprintf("stuck for %d dumps", stuckDetector.Dumps(nid)), StringfyInteger(nid), jthread.ThreadName, jthread.State, topFrame(jthread)}
+		this.Table.Rows = append(this.Table.Rows, row)
+		this.Table.FgColors = append(this.Table.FgColors, termui.ColorMagenta)
+	}
+
+	for _, change := range listOfChanges {
+		state := change.Thread.State
+		if change.Change == jvmdump.THREAD_STATE_CHANGED {
+			state = change.PrevState + " -> " + change.Thread.State
 		}
+		row := [] string{change.Change, StringfyInteger(change.Thread.Nid), change.Thread.ThreadName, state, topFrame(change.Thread)}
+		this.Table.Rows = append(this.Table.Rows, row)
+		this.Table.FgColors = append(this.Table.FgColors, colors[change.Change])
 	}
-	glog.V(0).Infof("associated memory segments: %v\n", len(foundSegments))
+	this.Table.BgColors = make([]termui.Attribute, len(this.Table.Rows))
+}
 
+func (this *TableTabElement) UpdateGrowth(listOfDeltas []smaps.MappingDelta) {
+	this.reset([] string {"Change", "stackStart", "stackStop", "RSS", "RSS Delta", "PSS", "PSS Delta", "Size Delta", "Type", "Path"})
 
-	//associate ProcessMemorySegment and JavaThread
-	for tid, segment := range foundSegments {
-		jthread, ok := mapOfJavaThreads[tid]
-		if ok {
-			glog.V(0).Infof("Found java thread (%v) : %v\n", tid, jthread)
-			segment.frameType = "JavaThread"
-			segment.Path = jthread.threadname
-			segment.taskID = jthread.nid
+	var colors = map[string]termui.Attribute{smaps.MAPPING_NEW: termui.ColorGreen, smaps.MAPPING_REMOVED: termui.ColorRed, smaps.MAPPING_GROWN: termui.ColorYellow}
+	this.Table.FgColors = [] termui.Attribute {this.Table.FgColor}
 
-			ioStat, err := GetThreadIoStat(pid, int32(segment.taskID))
-			if err != nil {
-				glog.Warningf("GetThreadIoStat Cause: [%s]", err)
-				continue
-			}
-			segment.WriteCount = ioStat.WriteCount
-			segment.ReadCount = ioStat.ReadCount
-			segment.WriteBytes = ioStat.WriteBytes
-			segment.ReadBytes = ioStat.ReadBytes
+	for _, delta := range listOfDeltas {
+		segment := delta.Segment
+
+		row := [] string{delta.Change, Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), StringfyUinteger64(segment.Rss),
+			StringfyDelta(delta.RssDelta), StringfyUinteger64(segment.Pss), StringfyDelta(delta.PssDelta), StringfyDelta(delta.SizeDelta), segment.FrameType, segment.Path}
+		this.Table.Rows = append(this.Table.Rows, row)
+		this.Table.FgColors = append(this.Table.FgColors, colors[delta.Change])
+	}
+	this.Table.BgColors = make([]termui.Attribute, len(this.Table.Rows))
+}
 
+func (this *TableTabElement) UpdateNmt(current *nmt.NativeMemoryReport, reference *nmt.NativeMemoryReport) {
+	this.reset([] string {"Category", "Reserved", "Committed", "Reserved Delta", "Committed Delta"})
 
-		} else {
-			glog.Warningf("java thread (%v) NOT found\n", tid)
+	if current == nil {
+		this.Table.Rows = append(this.Table.Rows, [] string{"NMT not available, run the JVM with -XX:NativeMemoryTracking=summary", "", "", "", ""})
+		return
+	}
 
+	for _, delta := range nmt.DiffNativeMemoryReports(current, reference) {
+		row := [] string{delta.Name, StringfyUinteger64(delta.Reserved), StringfyUinteger64(delta.Committed),
+			StringfyDelta(delta.ReservedDelta), StringfyDelta(delta.CommittedDelta)}
+		this.Table.Rows = append(this.Table.Rows, row)
+	}
+}
+
+func (this *TableTabElement) UpdateMemory(listOfSummaries []correlate.MemoryCategorySummary) {
+	this.reset([] string {"Category", "Mappings", "Size", "RSS", "PSS", "NMT Committed"})
+
+	for _, summary := range listOfSummaries {
+		nmtCommitted := "-"
+		if summary.HasNmt {
+			nmtCommitted = StringfyUinteger64(summary.NmtCommitted)
 		}
 
+		row := [] string{summary.Category, StringfyInteger(summary.Mappings), StringfyUinteger64(summary.Size), StringfyUinteger64(summary.Rss),
+			StringfyUinteger64(summary.Pss), nmtCommitted}
+		this.Table.Rows = append(this.Table.Rows, row)
 	}
+}
 
-	return &listOfTaskSegments
+func (this *TableTabElement) UpdateJvm(perfData *hsperf.PerfData) {
+	this.reset([] string {"Metric", "Value"})
+
+	this.Table.Rows = append(this.Table.Rows, describePerfData(perfData)...)
 }
 
-////////////////////////////////////////////////////////////////
+//describePerfData picks the heap, GC, safepoint, class loading, JIT and thread figures out of the hsperfdata counters
+func describePerfData(perfData *hsperf.PerfData) [][]string {
+	var rows [][]string
+
+	capacityOf := func(prefix string) string {
+		return fmt.Sprintf("%s / %s (max %s)", StringfyKiloBytes(perfData.Long(prefix+".used")), StringfyKiloBytes(perfData.Long(prefix+".capacity")),
+			StringfyKiloBytes(perfData.Long(prefix+".maxCapacity")))
+	}
+
+	//heap spaces, e.g. eden, s0, s1 and old
+	for gen := 0; perfData.Has(fmt.Sprintf("sun.gc.generation.%d.name", gen)); gen++ {
+		for space := 0; ; space++ {
+			prefix := fmt.Sprintf("sun.gc.generation.%d.space.%d", gen, space)
+			if !perfData.Has(prefix + ".name") {
+				break
+			}
+			rows = append(rows, [] string{"Heap " + perfData.String(prefix+".name"), capacityOf(prefix)})
+		}
+	}
+
+	if perfData.Has("sun.gc.metaspace.used") {
+		rows = append(rows, [] string{"Metaspace", capacityOf("sun.gc.metaspace")})
+	}
+	if perfData.Has("sun.gc.compressedclassspace.used") {
+		rows = append(rows, [] string{"Compressed Class Space", capacityOf("sun.gc.compressedclassspace")})
+	}
+
+	for collector := 0; ; collector++ {
+		prefix := fmt.Sprintf("sun.gc.collector.%d", collector)
+		if !perfData.Has(prefix + ".name") {
+			break
+		}
+		rows = append(rows, [] string{"GC " + perfData.String(prefix+".name"),
+			fmt.Sprintf("%d collections, %.3f s", perfData.Long(prefix+".invocations"), perfData.Seconds(prefix+".time"))})
+	}
+
+	rows = append(rows, [] string{"Safepoints",
+		fmt.Sprintf("%d, total %.3f s, sync %.3f s", perfData.Long("sun.rt.safepoints"), perfData.Seconds("sun.rt.safepointTime"), perfData.Seconds("sun.rt.safepointSyncTime"))})
+
+	rows = append(rows, [] string{"Classes",
+		fmt.Sprintf("loaded %d, unloaded %d, %.3f s", perfData.Long("java.cls.loadedClasses"), perfData.Long("java.cls.unloadedClasses"), perfData.Seconds("sun.cls.time"))})
 
+	rows = append(rows, [] string{"JIT",
+		fmt.Sprintf("%d compiles, %.3f s", perfData.Long("sun.ci.totalCompiles"), perfData.Seconds("java.ci.totalTime"))})
 
-func ptop(pid int32) (*[]TaskMemorySegment, error) {
-	var jstackResp, err = GetJavaThreadDump(pid)
+	rows = append(rows, [] string{"Threads",
+		fmt.Sprintf("live %d, daemon %d, peak %d", perfData.Long("java.threads.live"), perfData.Long("java.threads.daemon"), perfData.Long("java.threads.livePeak"))})
+
+	return rows
+}
+
+func ptop(pid int32) (*correlate.Snapshot, error) {
+	frame, err := correlate.CaptureFrame(pid)
 
 	if(err != nil) {
-		glog.Errorf("GetJavaThreadDump Cause: [%s]", err)
+		glog.Errorf("CaptureFrame Cause: [%s]", err)
 		return nil, err
 	}
 
-	////////////////////////////////////
+	return correlate.BuildSnapshot(pid, frame)
+}
+
+// SnapshotSource feeds the TUI, either live or from a recording
+type SnapshotSource interface {
+	GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error)
+	GetSnapshot(pid int32) (*correlate.Snapshot, error)
+	GetPerfData(pid int32) (*hsperf.PerfData, error)
+	SampleThread(pid int32, tid int) (*ThreadSample, error)
+}
+
+// LiveSource reads /proc and attaches to the JVM on every call
+type LiveSource struct {
+}
 
-	mapOfJavaThread := parseJavaThreadInfo(jstackResp)
+func (this *LiveSource) GetProcessSummary(pid int32, prev *ProcessSummary) (*ProcessSummary, error) {
+	return GetProcessSummary(pid, prev)
+}
 
-	for key, jthread := range mapOfJavaThread {
-		glog.V(0).Infof("key : %d, val: %s\n", key, jthread)
+func (this *LiveSource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	return ptop(pid)
+}
 
-	}
-	////////////////////////////////////
+func (this *LiveSource) GetPerfData(pid int32) (*hsperf.PerfData, error) {
+	return hsperf.GetPerfData(pid)
+}
 
-	listOfMemorySegment, err := GetProcessMemoryMaps(false, pid)
+func (this *LiveSource) SampleThread(pid int32, tid int) (*ThreadSample, error) {
+	return SampleThread(pid, tid)
+}
+
+// CachedSource reuses the snapshot of the wrapped source for minInterval, so that the exporters do not attach to the JVM
+// on every scrape or collection, whatever their number. Concurrent callers wait for the snapshot in progress and share it.
+type CachedSource struct {
+	SnapshotSource
+	minInterval time.Duration
+
+	mutex       sync.Mutex
+	pid         int32
+	snapshot    *correlate.Snapshot
+	takenAt     time.Time
+}
+
+func NewCachedSource(source SnapshotSource, minInterval time.Duration) *CachedSource {
+	return &CachedSource{SnapshotSource: source, minInterval: minInterval}
+}
 
+// GetSnapshot returns the cached snapshot of pid unless it is older than minInterval. A tenth of minInterval is allowed
+// for, so that a caller on the same period, give or take its jitter, gets a fresh snapshot every time.
+func (this *CachedSource) GetSnapshot(pid int32) (*correlate.Snapshot, error) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.snapshot != nil && this.pid == pid && time.Since(this.takenAt) < this.minInterval-this.minInterval/10 {
+		return this.snapshot, nil
+	}
+
+	//the age is counted from the start of the capture, which takes a while
+	startedAt := time.Now()
+	snapshot, err := this.SnapshotSource.GetSnapshot(pid)
 	if err != nil {
-		glog.Errorf("GetProcessMemoryMaps Cause: [%s]", err)
 		return nil, err
 	}
+	this.pid, this.snapshot, this.takenAt = pid, snapshot, startedAt
 
-	//for i := 0; i < len(*listOfMemorySegment); i++ {
-	//	mmap := (*listOfMemorySegment)[i]
-	//	glog.Infof("Ref: %v, RSS : %v \t PSS : %v \t anon : %v \t size %v \t Stack Start : %v \t Stack Stop : %v \t Path: %v\n", mmap.Rss, mmap.Pss, mmap.Anonymous, mmap.Referenced, mmap.Size, Stringify64BitAddress(mmap.stackStart), Stringify64BitAddress(mmap.stackStop), mmap.Path)
-	//}
+	return snapshot, nil
+}
+
+const CLOCK_TEXT = "[%s]"
 
-	////////////////////////////////////
+const PICKER_KEYBINDING_TEXT = "Press <Esc> to quit, Press <Up> or <Down> to select a JVM, <Enter> to monitor it, <Ctrl-a> to monitor all of them"
 
-	listOfKernelThreads, err := GetListOfKernelThreadsFromJStack(pid, mapOfJavaThread)
+const SUMMARY_KEYBINDING_TEXT = "Press <Esc> to quit, Press <Up> or <Down> to select a process, <Enter> to show its threads"
 
+const THREAD_KEYBINDING_TEXT = "Press <Esc> to quit, Press <Right> or <Left> to switch tabs, <Up> or <Down> and <Enter> to inspect a mapping, <Ctrl-s> to sort by Write Count, <Ctrl-o> to toggle mapping details, <Ctrl-g> to group mappings by file, <Ctrl-f> to cycle mapping filters, <Ctrl-b> to mark a baseline, <Ctrl-x> to drop it, <Backspace> to go back"
+
+//number of samples shown by the sparklines of the Thread tab
+const SPARKLINE_WIDTH = 20
+
+const DETAIL_KEYBINDING_TEXT = "Press <Esc> to quit, <Backspace> to go back to the tabs"
+
+const REPLAY_KEYBINDING_TEXT = ", <[> or <]> to step through the recording, <{> or <}> to skip 10 frames, <Home> or <End> to jump to either end"
+
+const REPLAY_CLOCK_TEXT = "[%s] frame %d/%d"
+
+const BUNDLE_KEYBINDING_TEXT = ", <Ctrl-e> to capture a diagnostic bundle"
+
+//index of the tabs listing mappings, in the order of tabpane.SetTabs()
+const (
+	TAB_INDEX_THREAD = 0
+	TAB_INDEX_MMAP   = 1
+	TAB_INDEX_OTHERS = 2
+	TAB_INDEX_ALL    = 3
+)
+
+//pickJavaProcesses lets the user choose among the discovered JVMs and returns the pids to be monitored, or nil if none was picked
+func pickJavaProcesses(listOfJavaProcesses []attach.JavaProcess) ([]int32) {
+	err := termui.Init()
 	if err != nil {
-		glog.Errorf("GetListOfKernelThreadsFromJStack Cause: [%s]", err)
-		return nil, err
+		panic(err)
 	}
+	defer termui.Close()
+	defer termui.ResetHandlers()
 
-	for i := 0; i < len(*listOfKernelThreads); i++ {
-		kthread := (*listOfKernelThreads)[i]
-		glog.V(0).Infof("tid : %v, start stack : %v\n", kthread.tid, Stringify64BitAddress(kthread.startStack))
+	keybindingText := termui.NewPar(PICKER_KEYBINDING_TEXT)
+	keybindingText.Y = 1
+	keybindingText.Height = 2 // 2 line
+	keybindingText.Width = 100  // 100 chars
+	keybindingText.Border = false
+	keybindingText.TextFgColor = termui.ColorWhite
+	keybindingText.TextBgColor = termui.ColorBlue
+
+	pickerTabElem := NewTableTabElement(300)
+	pickerTabElem.Table.Y = 3
+	pickerTabElem.Table.Block.BorderLabel = "PTOP - Java processes"
 
+	var selected = 0
+	var pids []int32
+
+	renderView := func() {
+		pickerTabElem.UpdatePicker(listOfJavaProcesses, selected)
+		termui.Clear()
+		termui.Render(keybindingText, pickerTabElem.Table)
 	}
 
-	///////////////////////////////////////
+	termui.Handle("<Escape>", func(termui.Event) {
+		termui.StopLoop()
+	})
 
-	listOfTaskSegment := associateKernelThreadAndJavaThread(pid, listOfKernelThreads, mapOfJavaThread, listOfMemorySegment)
+	termui.Handle("<Up>", func(termui.Event) {
+		if selected > 0 {
+			selected--
+			renderView()
+		}
+	})
+
+	termui.Handle("<Down>", func(termui.Event) {
+		if selected < len(listOfJavaProcesses)-1 {
+			selected++
+			renderView()
+		}
+	})
+
+	termui.Handle("<Enter>", func(termui.Event) {
+		pids = []int32{listOfJavaProcesses[selected].Pid}
+		termui.StopLoop()
+	})
+
+	termui.Handle("<C-a>", func(termui.Event) {
+		for _, jproc := range listOfJavaProcesses {
+			pids = append(pids, jproc.Pid)
+		}
+		termui.StopLoop()
+	})
 
-	//printMemorySegments(listOfTaskSegment)
+	renderView()
 
-	return listOfTaskSegment, nil
+	termui.Loop()
+
+	return pids
 }
 
-const CLOCK_TEXT = "[%s]"
+func formatMemoryRollup(rollup *smaps.MemoryRollup) string {
+	return fmt.Sprintf("RSS %d kB | PSS %d kB (anon %d kB, file %d kB, shmem %d kB) | Swap %d kB | Dirty %d kB", rollup.Rss, rollup.Pss,
+		rollup.PssAnon, rollup.PssFile, rollup.PssShmem, rollup.Swap, rollup.SharedDirty+rollup.PrivateDirty)
+}
+
+// tuiLoop shows the given processes as fed by source, i.e. a LiveSource or a ReplaySource. With alertConfig, the rules are
+// evaluated on every summary tick and the breaches are shown on top. stuckOptions tells when a thread is flagged as stuck.
+func tuiLoop(pids []int32, source SnapshotSource, alertConfig *AlertConfig, stuckOptions *StuckOptions) {
+	replay, isReplay := source.(*ReplaySource)
+	//bundles are only captured from a running process
+	_, isLive := source.(*LiveSource)
+
+	//rules are evaluated per process, thread rules only for the drilled-in one
+	var evaluators = make(map[int32]*AlertEvaluator)
+	var notifier *AlertNotifier
+	var bundles = NewBundleCapturer(nil)
+	if alertConfig != nil {
+		var err error
+		//event lines would garble the screen, hence they are only written to the event log of the config
+		notifier, err = NewAlertNotifier(alertConfig, nil)
+		if err != nil {
+			glog.Errorf("NewAlertNotifier() Cause: [%s]", err)
+			alertConfig = nil
+		} else {
+			defer notifier.Close()
+			bundles = notifier.bundles
+			for _, pid := range pids {
+				evaluators[pid] = NewAlertEvaluator(alertConfig, pid)
+			}
+		}
+	}
 
-func tuiLoop(pid int32) {
 	err := termui.Init()
 	if err != nil {
 		panic(err)
@@ -218,7 +582,14 @@ func tuiLoop(pid int32) {
 	parTicker := time.NewTicker(1 * time.Second)
 	go func() {
 		for {
-			clockText.Text = fmt.Sprintf(time.Now().Format("2006-01-02 15:04:05 MST -07:00"))
+			clockText.Text = time.Now().Format("2006-01-02 15:04:05 MST -07:00")
+			if isReplay {
+				cursor, frameCount, timestamp := replay.Position()
+				clockText.Text = fmt.Sprintf(REPLAY_CLOCK_TEXT, timestamp.Format("2006-01-02 15:04:05 MST -07:00"), cursor+1, frameCount)
+			}
+			if status := bundles.Status(); status != "" {
+				clockText.Text += " " + status
+			}
 
 			termui.Render(clockText)
 			<-parTicker.C
@@ -226,7 +597,7 @@ func tuiLoop(pid int32) {
 	}()
 
 
-	keybindingText := termui.NewPar("Press <Esc> to quit, Press <Right> or <Left> to switch tabs, <Ctrl-s> to sort by Write Count")
+	keybindingText := termui.NewPar(SUMMARY_KEYBINDING_TEXT)
 	keybindingText.Y = 2
 	keybindingText.Height = 2 // 2 line
 	keybindingText.Width = 100  // 100 chars
@@ -234,12 +605,32 @@ func tuiLoop(pid int32) {
 	keybindingText.TextFgColor = termui.ColorWhite
 	keybindingText.TextBgColor = termui.ColorBlue
 
+	alertText := termui.NewPar("")
+	alertText.Y = 0
+	alertText.Height = 1 // 1 line
+	alertText.Width = 300
+	alertText.Border = false
+	alertText.TextFgColor = termui.ColorWhite
+	alertText.TextBgColor = termui.ColorRed
+
+	rollupText := termui.NewPar("")
+	rollupText.Y = 4
+	rollupText.Height = 1 // 1 line
+	rollupText.Width = 150
+	rollupText.Border = false
+	rollupText.TextFgColor = termui.ColorWhite
+	rollupText.TextBgColor = termui.ColorBlack
+
 	//////////////////////////////////////////////////////////////////////////////
 
 	termWidth := 300
 
+	summaryTabElem := NewTableTabElement(termWidth)
+	summaryTabElem.Table.Y = 5
+	summaryTabElem.Table.Block.BorderLabel = "PTOP - Processes"
+
 	tabpane := extra.NewTabpane()
-	tabpane.Y = 4
+	tabpane.Y = 5
 	tabpane.Width = 50
 	tabpane.Border = true
 
@@ -261,66 +652,682 @@ func tuiLoop(pid int32) {
 	tabAll := extra.NewTab("All")
 	allTabElem := NewTableTabElement(termWidth)
 	tabAll.AddBlocks(allTabElem.Table)
+
+	tabJvm := extra.NewTab("JVM")
+	jvmTabElem := NewTableTabElement(termWidth)
+	tabJvm.AddBlocks(jvmTabElem.Table)
 	/////////////////////////////////////////////
 
-	tabpane.SetTabs(*tabThread, *tabMmap, *tabOthers, *tabAll)
-	termui.Render(clockText, keybindingText, tabpane)
+	tabMemory := extra.NewTab("Memory")
+	memoryTabElem := NewTableTabElement(termWidth)
+	tabMemory.AddBlocks(memoryTabElem.Table)
+
+	tabNmt := extra.NewTab("NMT")
+	nmtTabElem := NewTableTabElement(termWidth)
+	tabNmt.AddBlocks(nmtTabElem.Table)
+
+	tabGrowth := extra.NewTab("Growth")
+	growthTabElem := NewTableTabElement(termWidth)
+	tabGrowth.AddBlocks(growthTabElem.Table)
+
+	tabDiff := extra.NewTab("Dump Diff")
+	diffTabElem := NewTableTabElement(termWidth)
+	tabDiff.AddBlocks(diffTabElem.Table)
+
+	tabpane.SetTabs(*tabThread, *tabMmap, *tabOthers, *tabAll, *tabJvm, *tabMemory, *tabNmt, *tabGrowth, *tabDiff)
+
+	//trends shown above the detail table
+	historyChart := termui.NewSparklines()
+	historyChart.BorderLabel = "History"
+	historyChart.Width = termWidth
+	historyChart.Y = 5
+
+	detailTabElem := NewTableTabElement(termWidth)
+	detailTabElem.Table.Y = 5
 	///////////////////////////////////////////////////////////////////////////////
 
+	//guards the view state below, which is shared by the event handlers and the refreshing goroutines
+	var mutex sync.Mutex
+	var listOfSummaries = make([]*ProcessSummary, len(pids))
+	var selected = 0
+	//pid shown in the thread tabs, 0 while the process summary is shown
+	var drilledPid int32 = 0
+	var listOfJavaThreadSegments = &[]correlate.TaskMemorySegment{}
+	var listOfMmapSegments = &[]correlate.TaskMemorySegment{}
+	var listOfOthersSegments = &[]correlate.TaskMemorySegment{}
+	var listOfAllSegments = &[]correlate.TaskMemorySegment{}
+	//what the mapping tabs currently show, after filtering and sorting
+	var displayedSegments = make(map[int]*[]correlate.TaskMemorySegment)
+	var showMmapDetails = false
+	var showGrouped = false
+	var mappingFilter = 0
+	//tabpane does not expose its active tab, hence it is tracked here
+	var activeTab = 0
+	var selectedRow = 0
+	//mapping shown in the detail view, if any
+	var detailSegment *correlate.TaskMemorySegment
+	//NMT report of the latest and the previous refresh, and the baseline taken by the user if any
+	var currNmt, prevNmt, baselineNmt *nmt.NativeMemoryReport
+	//likewise for the mappings
+	var currSnapshot, prevSnapshot, baselineSnapshot *correlate.Snapshot
+	//how the threads changed between the thread dumps of the latest two refreshes, and which ones are stuck
+	var listOfThreadChanges []jvmdump.ThreadChange
+	var stuckDetector = jvmdump.NewStuckDetector(stuckOptions.TopFrames, stuckOptions.Dumps)
+	//trends of the drilled-in process and of its threads, sampled on every summary tick
+	var history = NewProcessHistory()
+	var refreshCh = make(chan bool, 1)
+	var summaryRefreshCh = make(chan bool, 1)
+	var keybindingSuffix = ""
+	if isReplay {
+		keybindingSuffix = REPLAY_KEYBINDING_TEXT
+	}
+	if isLive {
+		keybindingSuffix = BUNDLE_KEYBINDING_TEXT
+	}
+
+	//alerts firing for the given pid; caller must hold mutex
+	firingAlerts := func(pid int32) []AlertEvent {
+		if evaluator, ok := evaluators[pid]; ok {
+			return evaluator.Firing()
+		}
+		return nil
+	}
+
+	//caller must hold mutex
+	renderAlerts := func() {
+		var firing []AlertEvent
+		for _, pid := range pids {
+			firing = append(firing, firingAlerts(pid)...)
+		}
+		if len(firing) > 0 {
+			alertText.Text = describeAlerts(firing)
+			termui.Render(alertText)
+		}
+	}
+
+	//caller must hold mutex
+	renderView := func() {
+		termui.Clear()
+		defer renderAlerts()
+		if drilledPid != 0 && detailSegment != nil {
+			keybindingText.Text = DETAIL_KEYBINDING_TEXT + keybindingSuffix
+			termui.Render(clockText, keybindingText, rollupText, historyChart, detailTabElem.Table)
+		} else if drilledPid != 0 {
+			keybindingText.Text = THREAD_KEYBINDING_TEXT + keybindingSuffix
+			rollupText.Text = ""
+			for _, summary := range listOfSummaries {
+				if summary != nil && summary.Pid == drilledPid && summary.Rollup != nil {
+					rollupText.Text = formatMemoryRollup(summary.Rollup)
+				}
+			}
+			termui.Render(clockText, keybindingText, rollupText, tabpane)
+		} else {
+			keybindingText.Text = SUMMARY_KEYBINDING_TEXT + keybindingSuffix
+			summaryTabElem.UpdateSummary(listOfSummaries, selected)
+			alertedRows := make(map[int]bool)
+			for i, pid := range pids {
+				if len(firingAlerts(pid)) > 0 {
+					alertedRows[i] = true
+				}
+			}
+			summaryTabElem.mark(alertedRows, termui.ColorRed)
+			termui.Render(clockText, keybindingText, summaryTabElem.Table)
+		}
+	}
+
+	//thread trends are only shown for a thread stack; caller must hold mutex
+	updateHistoryChart := func() {
+		historyChart.Lines = nil
+		addLine := func(title string, data []int) {
+			line := termui.NewSparkline()
+			line.Title = title
+			line.Data = data
+			line.Height = 2
+			historyChart.Add(line)
+		}
+
+		if detailSegment != nil && detailSegment.FrameType == "JavaThread" {
+			threadHistory := history.ThreadHistory(detailSegment.TaskID)
+			addLine(fmt.Sprintf("CPU %.1f %%", threadHistory.Cpu.Last()), toSparklineData(threadHistory.Cpu.Values(), 10))
+			addLine(fmt.Sprintf("I/O %s B/s", StringfyRate(threadHistory.IoRate.Last())), toSparklineData(threadHistory.IoRate.Values(), 1))
+		}
+		addLine(fmt.Sprintf("Process RSS %d kB", uint64(history.Rss.Last())), toSparklineData(history.Rss.Values(), 1))
+		addLine(fmt.Sprintf("Process PSS %d kB", uint64(history.Pss.Last())), toSparklineData(history.Pss.Values(), 1))
+
+		//a title takes a line on top of each sparkline
+		historyChart.Height = len(historyChart.Lines) * 3 + 2
+		detailTabElem.Table.Y = historyChart.Y + historyChart.Height
+	}
+
+	//hsperfdata is read without attaching, hence it is refreshed on every summary tick; caller must hold mutex
+	refreshJvmTab := func() {
+		perfData, err := source.GetPerfData(drilledPid)
+		if err != nil {
+			glog.V(3).Infof("GetPerfData(%d) Cause: [%s]", drilledPid, err)
+			return
+		}
+		jvmTabElem.UpdateJvm(perfData)
+	}
+
+	//caller must hold mutex
+	updateNmtTab := func() {
+		if baselineNmt != nil {
+			nmtTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - NMT since baseline at %s", drilledPid, baselineNmt.Timestamp.Format("15:04:05"))
+			nmtTabElem.UpdateNmt(currNmt, baselineNmt)
+		} else {
+			nmtTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - NMT since previous refresh", drilledPid)
+			nmtTabElem.UpdateNmt(currNmt, prevNmt)
+		}
+	}
+
+	//caller must hold mutex
+	updateGrowthTab := func() {
+		reference := prevSnapshot
+		if baselineSnapshot != nil {
+			reference = baselineSnapshot
+			growthTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - mappings since baseline at %s", drilledPid, baselineSnapshot.Timestamp.Format("15:04:05"))
+		} else {
+			growthTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - mappings since previous refresh", drilledPid)
+		}
+
+		if currSnapshot == nil || reference == nil {
+			growthTabElem.UpdateGrowth(nil)
+			return
+		}
+		growthTabElem.UpdateGrowth(smaps.DiffMemorySegments(toProcessMemorySegments(reference.Segments), toProcessMemorySegments(currSnapshot.Segments)))
+	}
+
+	//caller must hold mutex
+	updateDiffTab := func() {
+		diffTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - threads since previous refresh, stuck: RUNNABLE/BLOCKED with the same %d top frames for %d dumps, WAITING/TIMED_WAITING ignored",
+			drilledPid, stuckOptions.TopFrames, stuckOptions.Dumps)
+		var threads map[int]jvmdump.JavaThread
+		if currSnapshot != nil {
+			threads = currSnapshot.Threads
+		}
+		diffTabElem.UpdateThreadDiff(listOfThreadChanges, threads, stuckDetector)
+	}
+
+	//whether a tab lists grouped mappings, whose rows cannot be selected; caller must hold mutex
+	isGroupedTab := func(tab int) bool {
+		return showGrouped && (tab == TAB_INDEX_MMAP || tab == TAB_INDEX_ALL)
+	}
+
+	//Thread tab, and MMap, Others and All tabs either per mapping or grouped; caller must hold mutex
+	updateMappingTabs := func() {
+		filter := smaps.ListOfMappingFilters[mappingFilter]
+
+		displayedSegments[TAB_INDEX_THREAD] = listOfJavaThreadSegments
+		threadTabElem.UpdateThread(listOfJavaThreadSegments, history)
+
+		displayedSegments[TAB_INDEX_MMAP] = filterByMappingFilter(listOfMmapSegments, filter)
+		if showMmapDetails {
+			//cluster the mappings of the same file
+			sort.Stable(InodeSortedTaskMemorySegmentVector{*displayedSegments[TAB_INDEX_MMAP]})
+		}
+		displayedSegments[TAB_INDEX_OTHERS] = filterByMappingFilter(listOfOthersSegments, filter)
+		displayedSegments[TAB_INDEX_ALL] = filterByMappingFilter(listOfAllSegments, filter)
+
+		for _, tabElem := range []*TableTabElement{mmapTabElem, othersTabElem, allTabElem} {
+			tabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - %s", drilledPid, filter.Name)
+		}
+
+		othersTabElem.Update(displayedSegments[TAB_INDEX_OTHERS])
+		if showGrouped {
+			mmapTabElem.UpdateGrouped(groupTaskMemorySegments(displayedSegments[TAB_INDEX_MMAP]))
+			allTabElem.UpdateGrouped(groupTaskMemorySegments(displayedSegments[TAB_INDEX_ALL]))
+		} else {
+			mmapTabElem.UpdateMmap(displayedSegments[TAB_INDEX_MMAP], showMmapDetails)
+			allTabElem.Update(displayedSegments[TAB_INDEX_ALL])
+		}
+
+		//selection is only offered on per mapping rows
+		tabElems := map[int]*TableTabElement{TAB_INDEX_THREAD: threadTabElem, TAB_INDEX_MMAP: mmapTabElem, TAB_INDEX_OTHERS: othersTabElem, TAB_INDEX_ALL: allTabElem}
+		if tabElem, ok := tabElems[activeTab]; ok && !isGroupedTab(activeTab) {
+			if selectedRow >= len(*displayedSegments[activeTab]) {
+				selectedRow = len(*displayedSegments[activeTab]) - 1
+			}
+			if selectedRow < 0 {
+				selectedRow = 0
+			}
+			tabElem.highlight(selectedRow)
+		}
+
+		alertedTids := make(map[int]bool)
+		for _, event := range firingAlerts(drilledPid) {
+			alertedTids[event.Tid] = true
+		}
+		stuckRows := make(map[int]bool)
+		alertedRows := make(map[int]bool)
+		for i, segment := range *listOfJavaThreadSegments {
+			stuckRows[i] = stuckDetector.Stuck(segment.TaskID)
+			alertedRows[i] = alertedTids[segment.TaskID]
+		}
+		threadTabElem.mark(stuckRows, termui.ColorMagenta)
+		threadTabElem.mark(alertedRows, termui.ColorRed)
+	}
+
+	//evaluates the rules against the latest summaries; caller must hold mutex
+	evaluateAlerts := func() {
+		for _, summary := range listOfSummaries {
+			if summary == nil {
+				continue
+			}
+			evaluator, ok := evaluators[summary.Pid]
+			//an unavailable process has no sample
+			if !ok || summary.sampledAt.IsZero() {
+				continue
+			}
+
+			var events []AlertEvent
+			if summary.Pid == drilledPid {
+				events = evaluator.Evaluate(summary, history, currSnapshot)
+			} else {
+				events = evaluator.Evaluate(summary, nil, nil)
+			}
+			for _, event := range events {
+				notifier.Notify(event)
+			}
+		}
+	}
+
+	//segments of the active tab which rows can be selected, or nil; caller must hold mutex
+	selectableSegments := func() *[]correlate.TaskMemorySegment {
+		if isGroupedTab(activeTab) {
+			return nil
+		}
+		return displayedSegments[activeTab]
+	}
+
+	//records the rollup and the counters of every known thread of the drilled-in process; caller must hold mutex
+	sampleHistory := func() {
+		for _, summary := range listOfSummaries {
+			if summary != nil && summary.Pid == drilledPid && summary.Rollup != nil {
+				history.RecordRollup(summary.Rollup)
+			}
+		}
+
+		liveTids := make(map[int]bool)
+		for _, segment := range *listOfJavaThreadSegments {
+			sample, err := source.SampleThread(drilledPid, segment.TaskID)
+			if err != nil {
+				glog.V(3).Infof("SampleThread(%d, %d) Cause: [%s]", drilledPid, segment.TaskID, err)
+				continue
+			}
+			history.RecordThread(segment.TaskID, sample)
+			liveTids[segment.TaskID] = true
+		}
+		history.Prune(liveTids)
+	}
+
+	//caller must hold mutex
+	drillInto := func(pid int32) {
+		drilledPid = pid
+		listOfJavaThreadSegments = &[]correlate.TaskMemorySegment{}
+		history = NewProcessHistory()
+
+		for _, tabElem := range []*TableTabElement{threadTabElem, mmapTabElem, othersTabElem, allTabElem, jvmTabElem, memoryTabElem} {
+			tabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d", pid)
+		}
+		listOfMmapSegments = &[]correlate.TaskMemorySegment{}
+		listOfOthersSegments = &[]correlate.TaskMemorySegment{}
+		listOfAllSegments = &[]correlate.TaskMemorySegment{}
+		detailSegment = nil
+		selectedRow = 0
+		updateMappingTabs()
+		memoryTabElem.UpdateMemory(nil)
+		currNmt, prevNmt, baselineNmt = nil, nil, nil
+		updateNmtTab()
+		currSnapshot, prevSnapshot, baselineSnapshot = nil, nil, nil
+		updateGrowthTab()
+		listOfThreadChanges = nil
+		stuckDetector = jvmdump.NewStuckDetector(stuckOptions.TopFrames, stuckOptions.Dumps)
+		updateDiffTab()
+		jvmTabElem.reset([] string {"Metric", "Value"})
+		refreshJvmTab()
+
+		renderView()
+
+		select {
+		case refreshCh <- true:
+		default:
+		}
+	}
+
 	termui.Handle("<Escape>", func(termui.Event) {
 		termui.StopLoop()
 	})
 
+	termui.Handle("<C-e>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !isLive {
+			return
+		}
+		if drilledPid != 0 {
+			bundles.Trigger(drilledPid, "key press")
+		} else {
+			bundles.Trigger(pids[selected], "key press")
+		}
+	})
+
 	termui.Handle("<Left>", func(termui.Event) {
-		tabpane.SetActiveLeft()
-		termui.Clear()
-		termui.Render(clockText, keybindingText, tabpane)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid != 0 && detailSegment == nil {
+			tabpane.SetActiveLeft()
+			if activeTab > 0 {
+				activeTab--
+			}
+			selectedRow = 0
+			updateMappingTabs()
+			renderView()
+		}
 	})
 
 	termui.Handle("<Right>", func(termui.Event) {
-		tabpane.SetActiveRight()
-		termui.Clear()
-		termui.Render(clockText, keybindingText, tabpane)
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid != 0 && detailSegment == nil {
+			tabpane.SetActiveRight()
+			if activeTab < len(tabpane.Tabs)-1 {
+				activeTab++
+			}
+			selectedRow = 0
+			updateMappingTabs()
+			renderView()
+		}
+	})
+
+	termui.Handle("<Up>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid == 0 && selected > 0 {
+			selected--
+			renderView()
+		} else if drilledPid != 0 && detailSegment == nil && selectableSegments() != nil && selectedRow > 0 {
+			selectedRow--
+			updateMappingTabs()
+			renderView()
+		}
+	})
+
+	termui.Handle("<Down>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid == 0 && selected < len(pids)-1 {
+			selected++
+			renderView()
+		} else if drilledPid != 0 && detailSegment == nil && selectableSegments() != nil && selectedRow < len(*selectableSegments())-1 {
+			selectedRow++
+			updateMappingTabs()
+			renderView()
+		}
+	})
+
+	termui.Handle("<Enter>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid == 0 {
+			drillInto(pids[selected])
+		} else if segments := selectableSegments(); detailSegment == nil && segments != nil && selectedRow < len(*segments) {
+			segment := (*segments)[selectedRow]
+			detailSegment = &segment
+			detailTabElem.Table.Block.BorderLabel = fmt.Sprintf("PTOP - %d - %s", drilledPid, segment.Path)
+			detailTabElem.UpdateDetail(detailSegment)
+			updateHistoryChart()
+			renderView()
+		}
 	})
 
+	termui.Handle("<Backspace>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if detailSegment != nil {
+			detailSegment = nil
+			renderView()
+		} else if drilledPid != 0 {
+			drilledPid = 0
+			renderView()
+		}
+	})
+
+	//TODO: 1-1 key binding for each column?
+	termui.Handle("<C-d>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sort.Sort(SortedTaskMemorySegmentVector(*listOfJavaThreadSegments))
+		updateMappingTabs()
+		if drilledPid != 0 {
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-s>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		sort.Sort(WriteCountSortedTaskMemorySegmentVector{*listOfJavaThreadSegments})
+		updateMappingTabs()
+		if drilledPid != 0 {
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-o>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		showMmapDetails = !showMmapDetails
+		updateMappingTabs()
+		if drilledPid != 0 {
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-g>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		showGrouped = !showGrouped
+		updateMappingTabs()
+		if drilledPid != 0 {
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-f>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		mappingFilter = (mappingFilter + 1) % len(smaps.ListOfMappingFilters)
+		selectedRow = 0
+		updateMappingTabs()
+		if drilledPid != 0 {
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-b>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid != 0 && currSnapshot != nil {
+			baselineSnapshot = currSnapshot
+			updateGrowthTab()
+			if currNmt != nil {
+				baselineNmt = currNmt
+				updateNmtTab()
+			}
+			renderView()
+		}
+	})
+
+	termui.Handle("<C-x>", func(termui.Event) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if drilledPid != 0 {
+			baselineNmt = nil
+			updateNmtTab()
+			baselineSnapshot = nil
+			updateGrowthTab()
+			renderView()
+		}
+	})
+
+	if isReplay {
+		seek := func(delta int) {
+			if !replay.Seek(delta) {
+				return
+			}
+			for _, ch := range []chan bool{summaryRefreshCh, refreshCh} {
+				select {
+				case ch <- true:
+				default:
+				}
+			}
+		}
+
+		termui.Handle("[", func(termui.Event) { seek(-1) })
+		termui.Handle("]", func(termui.Event) { seek(1) })
+		termui.Handle("{", func(termui.Event) { seek(-10) })
+		termui.Handle("}", func(termui.Event) { seek(10) })
+		termui.Handle("<Home>", func(termui.Event) { seek(-replay.recording.Len()) })
+		termui.Handle("<End>", func(termui.Event) { seek(replay.recording.Len()) })
+	}
+
+	mutex.Lock()
+	if len(pids) == 1 {
+		drillInto(pids[0])
+	} else {
+		renderView()
+	}
+	mutex.Unlock()
+
+	summaryTicker := time.NewTicker(DEFAULT_PROFILE_INTERVAL_IN_SECOND * time.Second)
+	go func() {
+		for {
+			for i, pid := range pids {
+				summary, err := source.GetProcessSummary(pid, listOfSummaries[i])
+				if err != nil {
+					glog.Warningf("GetProcessSummary(%d) Cause: [%s]", pid, err)
+					summary = &ProcessSummary{Pid: pid, Name: "<unavailable>"}
+				}
+
+				mutex.Lock()
+				listOfSummaries[i] = summary
+				mutex.Unlock()
+			}
+
+			mutex.Lock()
+			if drilledPid != 0 {
+				refreshJvmTab()
+				if isReplay {
+					//the trends are those leading to the current frame
+					history = replay.History()
+				} else {
+					sampleHistory()
+				}
+			}
+			evaluateAlerts()
+			if drilledPid != 0 {
+				updateMappingTabs()
+				if detailSegment != nil {
+					updateHistoryChart()
+				}
+			}
+			renderView()
+			mutex.Unlock()
+
+			select {
+			case <-summaryRefreshCh:
+			case <-summaryTicker.C:
+			}
+		}
+	}()
+
 	//TODO: remember current configuration. When next tick starts, reload config and render.
 
+	//Only the drilled-in process is attached to, as taking a thread dump is not free for the target JVM
 	tabpaneTicker := time.NewTicker(1 * time.Minute)
+	if isReplay {
+		//a recording does not change by itself, it is refreshed on seek only
+		summaryTicker.Stop()
+		tabpaneTicker.Stop()
+	}
 	go func() {
 		for {
-			listOfMemorySegments, err := ptop(pid)
+			select {
+			case <-refreshCh:
+			case <-tabpaneTicker.C:
+			}
+
+			mutex.Lock()
+			pid := drilledPid
+			mutex.Unlock()
 
-			if(err != nil) {
-				termui.StopLoop()
-				break;
+			if pid == 0 {
+				continue
 			}
 
-			listOfJavaThreadSegments := filterJavaThread(listOfMemorySegments)
+			snapshot, err := source.GetSnapshot(pid)
 
-			//TODO: 1-1 key binding for each column?
-			termui.Handle("<C-d>", func(termui.Event) {
-				sort.Sort(SortedTaskMemorySegmentVector(*listOfJavaThreadSegments))
-				threadTabElem.UpdateThread(listOfJavaThreadSegments)
-				termui.Render(clockText, keybindingText, tabpane)
-			})
+			mutex.Lock()
+			if drilledPid != pid {
+				//user has switched to another view meanwhile
+				mutex.Unlock()
+				continue
+			}
 
-			termui.Handle("<C-s>", func(termui.Event) {
-				sort.Sort(WriteCountSortedTaskMemorySegmentVector{*listOfJavaThreadSegments})
-				threadTabElem.UpdateThread(listOfJavaThreadSegments)
-				termui.Render(clockText, keybindingText, tabpane)
-			})
+			if err != nil {
+				if len(pids) == 1 && !isReplay {
+					mutex.Unlock()
+					termui.StopLoop()
+					break
+				}
+				drilledPid = 0
+				renderView()
+				mutex.Unlock()
+				continue
+			}
+
+			listOfMemorySegments := snapshot.Segments
 
-			threadTabElem.UpdateThread(listOfJavaThreadSegments)
+			//before the Thread tab is updated, which shows the stuck threads
+			if currSnapshot != nil {
+				listOfThreadChanges = jvmdump.DiffThreadDumps(currSnapshot.Threads, snapshot.Threads)
+			}
+			stuckDetector.Record(snapshot.Threads)
+
+			listOfJavaThreadSegments = filterJavaThread(listOfMemorySegments)
 
-			mmapTabElem.UpdateMmap(filterMmap(listOfMemorySegments))
+			listOfMmapSegments = filterMmap(listOfMemorySegments)
+			listOfOthersSegments = filterOthers(listOfMemorySegments)
+			listOfAllSegments = listOfMemorySegments
+			updateMappingTabs()
 
-			othersTabElem.Update(filterOthers(listOfMemorySegments))
+			memoryTabElem.UpdateMemory(correlate.SummarizeMemoryCategories(listOfMemorySegments, snapshot.Layout.NativeMemory))
 
-			allTabElem.Update(listOfMemorySegments)
+			if snapshot.Layout.NativeMemory != nil {
+				prevNmt, currNmt = currNmt, snapshot.Layout.NativeMemory
+			}
+			updateNmtTab()
 
-			termui.Render(tabpane)
+			prevSnapshot, currSnapshot = currSnapshot, snapshot
+			updateGrowthTab()
+			updateDiffTab()
 
-			<-tabpaneTicker.C
+			renderView()
+			mutex.Unlock()
 		}
 	}()
 
@@ -331,13 +1338,22 @@ func tuiLoop(pid int32) {
 //TODO: interface filter by topN element
 
 //More efficient way to retrieve JavaThread memory segment
-func filterJavaThread(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySegment) {
-	list := []TaskMemorySegment{}
+//toSparklineData scales the samples, as sparklines only plot integers
+func toSparklineData(values []float64, scale float64) ([]int) {
+	data := make([]int, len(values))
+	for i, val := range values {
+		data[i] = int(val * scale)
+	}
+	return data
+}
+
+func filterJavaThread(listOfMemorySegments *[]correlate.TaskMemorySegment)(*[]correlate.TaskMemorySegment) {
+	list := []correlate.TaskMemorySegment{}
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		if (segment.frameType == "JavaThread") {
+		if (segment.FrameType == "JavaThread") {
 			list = append(list, segment)
 		}
 	}
@@ -346,13 +1362,13 @@ func filterJavaThread(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySe
 }
 
 //More efficient way to retrieve mmap memory segment
-func filterMmap(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySegment) {
-	list := []TaskMemorySegment{}
+func filterMmap(listOfMemorySegments *[]correlate.TaskMemorySegment)(*[]correlate.TaskMemorySegment) {
+	list := []correlate.TaskMemorySegment{}
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		if (segment.frameType == "mmap") {
+		if (segment.FrameType == "mmap") {
 			list = append(list, segment)
 		}
 	}
@@ -361,13 +1377,27 @@ func filterMmap(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySegment)
 }
 
 //More efficient way to retrieve others	 memory segment
-func filterOthers(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySegment) {
-	list := []TaskMemorySegment{}
+func filterOthers(listOfMemorySegments *[]correlate.TaskMemorySegment)(*[]correlate.TaskMemorySegment) {
+	list := []correlate.TaskMemorySegment{}
+
+	for i := 0; i < len(*listOfMemorySegments); i++ {
+		segment := (*listOfMemorySegments)[i]
+
+		if (segment.FrameType != "JavaThread" && segment.FrameType != "mmap") {
+			list = append(list, segment)
+		}
+	}
+
+	return &list
+}
+
+func filterByMappingFilter(listOfMemorySegments *[]correlate.TaskMemorySegment, filter smaps.MappingFilter) (*[]correlate.TaskMemorySegment) {
+	list := []correlate.TaskMemorySegment{}
 
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
-		if (segment.frameType != "JavaThread" && segment.frameType != "mmap") {
+		if filter.Match(&segment.ProcessMemorySegment) {
 			list = append(list, segment)
 		}
 	}
@@ -375,18 +1405,33 @@ func filterOthers(listOfMemorySegments *[]TaskMemorySegment)(*[]TaskMemorySegmen
 	return &list
 }
 
+//Grouping is done on the process level counters only, per thread I/O is not summed up
+func groupTaskMemorySegments(listOfMemorySegments *[]correlate.TaskMemorySegment) *[]smaps.ProcessMemorySegment {
+	return smaps.GroupMemorySegments(toProcessMemorySegments(listOfMemorySegments))
+}
+
+func toProcessMemorySegments(listOfMemorySegments *[]correlate.TaskMemorySegment) *[]smaps.ProcessMemorySegment {
+	var segments []smaps.ProcessMemorySegment
+
+	for i := 0; i < len(*listOfMemorySegments); i++ {
+		segments = append(segments, (*listOfMemorySegments)[i].ProcessMemorySegment)
+	}
+
+	return &segments
+}
+
 //////////////////////
 
 
 ////////////////////////////////////////////////////////////////
 
 //TODO: We might need a generic way to traverse all sortable columns
-type SortedTaskMemorySegmentVector []TaskMemorySegment
+type SortedTaskMemorySegmentVector []correlate.TaskMemorySegment
 
 
 func (vector SortedTaskMemorySegmentVector) Len() int           { return len(vector)}
 func (vector SortedTaskMemorySegmentVector) Swap(i, j int)      { vector[i], vector[j] = vector[j], vector[i] }
-func (vector SortedTaskMemorySegmentVector) Less(i, j int) bool { return vector[i].taskID > vector[j].taskID }
+func (vector SortedTaskMemorySegmentVector) Less(i, j int) bool { return vector[i].TaskID > vector[j].TaskID }
 
 
 ///////////
@@ -396,3 +1441,15 @@ type WriteCountSortedTaskMemorySegmentVector struct {
 
 func (vector WriteCountSortedTaskMemorySegmentVector) Less(i, j int) bool { return vector.SortedTaskMemorySegmentVector[i].WriteCount > vector.SortedTaskMemorySegmentVector[j].WriteCount }
 
+///////////
+type InodeSortedTaskMemorySegmentVector struct {
+	SortedTaskMemorySegmentVector
+}
+
+func (vector InodeSortedTaskMemorySegmentVector) Less(i, j int) bool {
+	a, b := vector.SortedTaskMemorySegmentVector[i], vector.SortedTaskMemorySegmentVector[j]
+	if a.Device != b.Device {
+		return a.Device < b.Device
+	}
+	return a.Inode < b.Inode
+}
@@ -2,24 +2,12 @@ package main
 
 import (
 	"fmt"
-	"regexp"
+	"github.com/mcfongtw/go-ptop/attach"
+	"github.com/mcfongtw/go-ptop/correlate"
 	"strings"
+	"time"
 )
 
-func ParseRegexByGroup(regEx, expr string) (paramsMap map[string]string) {
-
-	var compRegEx = regexp.MustCompile(regEx)
-	match := compRegEx.FindStringSubmatch(expr)
-
-	paramsMap = make(map[string]string)
-	for i, name := range compRegEx.SubexpNames() {
-		if i > 0 && i <= len(match) {
-			paramsMap[name] = match[i]
-		}
-	}
-	return paramsMap
-}
-
 func Stringify64BitAddress(addr uint64)(string) {
 	hexAddr := "0x" + fmt.Sprintf("%016x", addr)
 
@@ -44,16 +32,85 @@ func StringfyUinteger64(val uint64) (string) {
 	return str
 }
 
-func PrintMemorySegments(listOfMemorySegments *[]TaskMemorySegment) {
+func PrintMemorySegments(listOfMemorySegments *[]correlate.TaskMemorySegment) {
 	fmt.Printf("[%-18s : %-18s] %9s %9s %9s %9s %9s %9s %9s %-10s %-30s\n", "START ADDR", "STOP ADDR", "PSS", "RSS", "DIRTY", "RD BYTES", "WRT BYTES", "RD CNT", "WRT CNT", "TYPE", "DATA")
 	for i := 0; i < len(*listOfMemorySegments); i++ {
 		segment := (*listOfMemorySegments)[i]
 
 		if(strings.HasPrefix(segment.Path, "/")){
-			fmt.Printf("[%-18v : %-18v] %9v %9v %9v %9v %9v %9v %9v [%-10s] %-30v\n", Stringify64BitAddress(segment.stackStart), Stringify64BitAddress(segment.stackStop), segment.Pss, segment.Rss, segment.PrivateDirty, segment.ReadBytes, segment.WriteBytes, segment.ReadCount, segment.WriteCount, segment.frameType, segment.Path)
+			fmt.Printf("[%-18v : %-18v] %9v %9v %9v %9v %9v %9v %9v [%-10s] %-30v\n", Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), segment.Pss, segment.Rss, segment.PrivateDirty, segment.ReadBytes, segment.WriteBytes, segment.ReadCount, segment.WriteCount, segment.FrameType, segment.Path)
 		} else {
-			fmt.Printf("[%-18v : %-18v] %9v %9v %9v %9v %9v %9v %9v [%-10s] %-30v\n", Stringify64BitAddress(segment.stackStart), Stringify64BitAddress(segment.stackStop), segment.Pss, segment.Rss, segment.PrivateDirty, segment.ReadBytes, segment.WriteBytes, segment.ReadCount, segment.WriteCount, segment.frameType, segment.Path)
+			fmt.Printf("[%-18v : %-18v] %9v %9v %9v %9v %9v %9v %9v [%-10s] %-30v\n", Stringify64BitAddress(segment.StackStart), Stringify64BitAddress(segment.StackStop), segment.Pss, segment.Rss, segment.PrivateDirty, segment.ReadBytes, segment.WriteBytes, segment.ReadCount, segment.WriteCount, segment.FrameType, segment.Path)
 		}
 
 	}
-}
\ No newline at end of file
+}
+
+func StringfyKiloBytes(bytes int64) (string) {
+	str := fmt.Sprintf("%d kB", bytes / 1024)
+
+	return str
+}
+
+func StringfyDelta(val int64) (string) {
+	str := fmt.Sprintf("%+d", val)
+
+	return str
+}
+
+var sparkRunes = []rune{'▁', '▂', '▃', '▄', '▅', '▆', '▇', '█'}
+
+// StringfySparkline renders the latest width values as a one line sparkline, scaled to their maximum
+func StringfySparkline(values []float64, width int) (string) {
+	if len(values) > width {
+		values = values[len(values)-width:]
+	}
+
+	var max float64 = 0
+	for _, val := range values {
+		if val > max {
+			max = val
+		}
+	}
+
+	runes := make([]rune, len(values))
+	for i, val := range values {
+		level := 0
+		if max > 0 && val > 0 {
+			level = int(val / max * float64(len(sparkRunes)-1) + 0.5)
+		}
+		runes[i] = sparkRunes[level]
+	}
+
+	return string(runes)
+}
+
+func StringfyRate(val float64) (string) {
+	str := fmt.Sprintf("%.1f", val)
+
+	return str
+}
+
+func StringfyDuration(val time.Duration) (string) {
+	str := val.Truncate(time.Second).String()
+
+	return str
+}
+
+func PrintJavaProcesses(listOfJavaProcesses []attach.JavaProcess) {
+	fmt.Printf("%-8s %-16s %-14s %-10s %s\n", "PID", "USER", "UPTIME", "ATTACHABLE", "MAIN CLASS")
+	for _, jproc := range listOfJavaProcesses {
+		fmt.Printf("%-8v %-16v %-14v %-10v %v\n", jproc.Pid, jproc.User, StringfyDuration(jproc.Uptime), jproc.Attachable, jproc.MainClass)
+	}
+}
+
+func StringfyBytes(val float64) (string) {
+	units := []string{"B", "kB", "MB", "GB", "TB"}
+	unit := 0
+	for val >= 1024 && unit < len(units)-1 {
+		val /= 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%.1f %s", val, units[unit])
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/golang/glog"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/hsperf"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/nmt"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the API serves the stacks and the mappings of the process without authentication, hence only to this host by default
+const DEFAULT_WEB_LISTEN = "127.0.0.1:9780"
+
+// as in the TUI, the summary and the trends are refreshed every DEFAULT_PROFILE_INTERVAL_IN_SECOND, and the snapshot, which
+// attaches to the JVM, every minute
+const DEFAULT_WEB_SNAPSHOT_INTERVAL_IN_SECOND = DEFAULT_SNAPSHOT_INTERVAL_IN_SECOND
+
+// Events pushed to the browsers over /api/events
+const (
+	WEB_EVENT_SUMMARY  = "summary"
+	WEB_EVENT_SNAPSHOT = "snapshot"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// WebThread is a row of the Thread tab
+type WebThread struct {
+	Tid        int       `json:"tid"`
+	Name       string    `json:"name"`
+	Pool       string    `json:"pool"`
+	State      string    `json:"state"`
+	StackStart uint64    `json:"stackStart"`
+	StackStop  uint64    `json:"stackStop"`
+	// in percent of one core
+	Cpu        float64   `json:"cpu"`
+	CpuTrend   []float64 `json:"cpuTrend"`
+	// read and written bytes per second
+	IoRate     float64   `json:"ioRate"`
+	IoTrend    []float64 `json:"ioTrend"`
+	ReadCount  uint64    `json:"readCount"`
+	WriteCount uint64    `json:"writeCount"`
+	ReadBytes  uint64    `json:"readBytes"`
+	WriteBytes uint64    `json:"writeBytes"`
+	StackRss   uint64    `json:"stackRss"`
+}
+
+// WebServer serves the views of the TUI over HTTP, from state refreshed in the background
+type WebServer struct {
+	source          SnapshotSource
+	pid             int32
+
+	mutex           sync.Mutex
+	summary         *ProcessSummary
+	history         *ProcessHistory
+	currSnapshot    *correlate.Snapshot
+	prevSnapshot    *correlate.Snapshot
+	prevNmt         *nmt.NativeMemoryReport
+	perfData        *hsperf.PerfData
+	subscribers     map[chan string]bool
+}
+
+func NewWebServer(source SnapshotSource, pid int32) *WebServer {
+	return &WebServer{source: source, pid: pid, history: NewProcessHistory(), subscribers: make(map[chan string]bool)}
+}
+
+// RefreshSummary samples the process, its threads and its hsperfdata, and records the trends. As in RefreshSnapshot, the
+// samples are taken without holding the mutex, so that the API is served meanwhile.
+func (this *WebServer) RefreshSummary() error {
+	this.mutex.Lock()
+	prevSummary, snapshot := this.summary, this.currSnapshot
+	this.mutex.Unlock()
+
+	summary, err := this.source.GetProcessSummary(this.pid, prevSummary)
+	if err != nil {
+		return err
+	}
+
+	var threadSamples map[int]*ThreadSample
+	if snapshot != nil {
+		threadSamples = takeThreadSamples(this.source, this.pid, snapshot)
+	}
+
+	perfData, err := this.source.GetPerfData(this.pid)
+	if err != nil {
+		glog.V(3).Infof("GetPerfData(%d) Cause: [%s]", this.pid, err)
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.summary = summary
+	if summary.Rollup != nil {
+		this.history.RecordRollup(summary.Rollup)
+	}
+	if snapshot != nil {
+		recordThreadSamples(this.history, threadSamples)
+	}
+	if perfData != nil {
+		this.perfData = perfData
+	}
+
+	this.publish(WEB_EVENT_SUMMARY)
+	return nil
+}
+
+// RefreshSnapshot takes a new snapshot, the previous one being kept for the Growth and NMT tabs
+func (this *WebServer) RefreshSnapshot() error {
+	snapshot, err := this.source.GetSnapshot(this.pid)
+	if err != nil {
+		return err
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.currSnapshot != nil {
+		this.prevNmt = this.currSnapshot.Layout.NativeMemory
+	}
+	this.prevSnapshot, this.currSnapshot = this.currSnapshot, snapshot
+
+	this.publish(WEB_EVENT_SNAPSHOT)
+	return nil
+}
+
+// Run refreshes the state until stop is closed
+func (this *WebServer) Run(summaryInterval time.Duration, snapshotInterval time.Duration, stop chan bool) {
+	if err := this.RefreshSnapshot(); err != nil {
+		glog.Errorf("RefreshSnapshot Cause: [%s]", err)
+	}
+	if err := this.RefreshSummary(); err != nil {
+		glog.Errorf("RefreshSummary Cause: [%s]", err)
+	}
+
+	summaryTicker := time.NewTicker(summaryInterval)
+	defer summaryTicker.Stop()
+	snapshotTicker := time.NewTicker(snapshotInterval)
+	defer snapshotTicker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-summaryTicker.C:
+			if err := this.RefreshSummary(); err != nil {
+				glog.Errorf("RefreshSummary Cause: [%s]", err)
+			}
+		case <-snapshotTicker.C:
+			if err := this.RefreshSnapshot(); err != nil {
+				glog.Errorf("RefreshSnapshot Cause: [%s]", err)
+			}
+		}
+	}
+}
+
+//caller must hold mutex
+func (this *WebServer) publish(event string) {
+	for subscriber := range this.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			//a slow browser misses the event, it refetches on the next one anyway
+		}
+	}
+}
+
+// Handler routes the REST API, the event stream and the single-page UI
+func (this *WebServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/snapshot", this.handleSnapshot)
+	mux.HandleFunc("/api/threads", this.handleThreads)
+	mux.HandleFunc("/api/thread/", this.handleThreadStack)
+	mux.HandleFunc("/api/maps", this.handleMaps)
+	mux.HandleFunc("/api/history", this.handleHistory)
+	mux.HandleFunc("/api/jvm", this.handleJvm)
+	mux.HandleFunc("/api/memory", this.handleMemory)
+	mux.HandleFunc("/api/nmt", this.handleNmt)
+	mux.HandleFunc("/api/growth", this.handleGrowth)
+	mux.HandleFunc("/api/events", this.handleEvents)
+
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		glog.Fatalf("fs.Sub Cause: [%s]", err)
+	}
+	mux.Handle("/", http.FileServer(http.FS(assets)))
+
+	return mux
+}
+
+func writeJson(writer http.ResponseWriter, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		glog.Errorf("json.Encode Cause: [%s]", err)
+	}
+}
+
+//caller must hold mutex
+func (this *WebServer) requireSnapshot(writer http.ResponseWriter) bool {
+	if this.currSnapshot == nil {
+		http.Error(writer, "no snapshot taken yet", http.StatusServiceUnavailable)
+		return false
+	}
+	return true
+}
+
+func (this *WebServer) handleSnapshot(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	var response = struct {
+		Pid          int32            `json:"pid"`
+		Summary      *ProcessSummary  `json:"summary"`
+		Timestamp    time.Time        `json:"timestamp"`
+		ThreadStates map[string]int   `json:"threadStates"`
+		//names of the filters of /api/maps, by index
+		MappingFilters []string       `json:"mappingFilters"`
+	}{Pid: this.pid, Summary: this.summary, ThreadStates: make(map[string]int)}
+
+	for _, filter := range smaps.ListOfMappingFilters {
+		response.MappingFilters = append(response.MappingFilters, filter.Name)
+	}
+
+	if this.currSnapshot != nil {
+		response.Timestamp = this.currSnapshot.Timestamp
+		for _, jthread := range this.currSnapshot.Threads {
+			if jthread.State != "" {
+				response.ThreadStates[jthread.State]++
+			}
+		}
+	}
+
+	writeJson(writer, response)
+}
+
+func (this *WebServer) handleThreads(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+
+	var listOfThreads = []WebThread{}
+	for _, segment := range *filterJavaThread(this.currSnapshot.Segments) {
+		threadHistory := this.history.ThreadHistory(segment.TaskID)
+		listOfThreads = append(listOfThreads, WebThread{Tid: segment.TaskID, Name: segment.Path, Pool: jvmdump.ThreadPool(segment.Path),
+			State: segment.State, StackStart: segment.StackStart, StackStop: segment.StackStop,
+			Cpu: threadHistory.Cpu.Last(), CpuTrend: threadHistory.Cpu.Values(), IoRate: threadHistory.IoRate.Last(), IoTrend: threadHistory.IoRate.Values(),
+			ReadCount: segment.ReadCount, WriteCount: segment.WriteCount, ReadBytes: segment.ReadBytes, WriteBytes: segment.WriteBytes, StackRss: segment.Rss})
+	}
+
+	writeJson(writer, listOfThreads)
+}
+
+// handleThreadStack serves /api/thread/<tid>/stack
+func (this *WebServer) handleThreadStack(writer http.ResponseWriter, request *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(request.URL.Path, "/api/thread/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "stack" {
+		http.NotFound(writer, request)
+		return
+	}
+	tid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("invalid tid [%s]", parts[0]), http.StatusBadRequest)
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+	jthread, ok := this.currSnapshot.Threads[tid]
+	if !ok {
+		http.Error(writer, fmt.Sprintf("thread %d not found", tid), http.StatusNotFound)
+		return
+	}
+
+	writeJson(writer, jthread)
+}
+
+// handleMaps serves the MMap, Others and All tabs: view is one of mmap, others or all, filter is the index of a
+// smaps.ListOfMappingFilters entry, and grouped sums up the mappings of the same file
+func (this *WebServer) handleMaps(writer http.ResponseWriter, request *http.Request) {
+	query := request.URL.Query()
+
+	filterIndex := 0
+	if value := query.Get("filter"); value != "" {
+		var err error
+		filterIndex, err = strconv.Atoi(value)
+		if err != nil || filterIndex < 0 || filterIndex >= len(smaps.ListOfMappingFilters) {
+			http.Error(writer, fmt.Sprintf("invalid filter [%s]", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+
+	var listOfSegments *[]correlate.TaskMemorySegment
+	switch query.Get("view") {
+	case "mmap":
+		listOfSegments = filterMmap(this.currSnapshot.Segments)
+	case "others":
+		listOfSegments = filterOthers(this.currSnapshot.Segments)
+	case "", "all":
+		listOfSegments = this.currSnapshot.Segments
+	default:
+		http.Error(writer, fmt.Sprintf("invalid view [%s]", query.Get("view")), http.StatusBadRequest)
+		return
+	}
+	listOfSegments = filterByMappingFilter(listOfSegments, smaps.ListOfMappingFilters[filterIndex])
+
+	if query.Get("grouped") == "true" {
+		writeJson(writer, groupTaskMemorySegments(listOfSegments))
+		return
+	}
+	writeJson(writer, listOfSegments)
+}
+
+func (this *WebServer) handleHistory(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	type threadTrends struct {
+		Cpu    []float64 `json:"cpu"`
+		IoRate []float64 `json:"ioRate"`
+	}
+	var response = struct {
+		Rss     []float64             `json:"rss"`
+		Pss     []float64             `json:"pss"`
+		Threads map[int]threadTrends  `json:"threads"`
+	}{Rss: this.history.Rss.Values(), Pss: this.history.Pss.Values(), Threads: make(map[int]threadTrends)}
+
+	for tid, threadHistory := range this.history.Threads {
+		response.Threads[tid] = threadTrends{Cpu: threadHistory.Cpu.Values(), IoRate: threadHistory.IoRate.Values()}
+	}
+
+	writeJson(writer, response)
+}
+
+func (this *WebServer) handleJvm(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.perfData == nil {
+		http.Error(writer, "hsperfdata not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJson(writer, describePerfData(this.perfData))
+}
+
+func (this *WebServer) handleMemory(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+
+	writeJson(writer, correlate.SummarizeMemoryCategories(this.currSnapshot.Segments, this.currSnapshot.Layout.NativeMemory))
+}
+
+// handleNmt serves the NMT categories, with deltas since the previous snapshot
+func (this *WebServer) handleNmt(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+	if this.currSnapshot.Layout.NativeMemory == nil {
+		http.Error(writer, "NMT not available, run the JVM with -XX:NativeMemoryTracking=summary", http.StatusServiceUnavailable)
+		return
+	}
+
+	writeJson(writer, nmt.DiffNativeMemoryReports(this.currSnapshot.Layout.NativeMemory, this.prevNmt))
+}
+
+// handleGrowth serves the mappings which appeared, vanished or changed since the previous snapshot
+func (this *WebServer) handleGrowth(writer http.ResponseWriter, request *http.Request) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.requireSnapshot(writer) {
+		return
+	}
+
+	var listOfDeltas = []smaps.MappingDelta{}
+	if this.prevSnapshot != nil {
+		listOfDeltas = append(listOfDeltas, smaps.DiffMemorySegments(toProcessMemorySegments(this.prevSnapshot.Segments),
+			toProcessMemorySegments(this.currSnapshot.Segments))...)
+	}
+
+	writeJson(writer, listOfDeltas)
+}
+
+// handleEvents streams the refreshes as Server-Sent Events, the browser then refetches what it shows
+func (this *WebServer) handleEvents(writer http.ResponseWriter, request *http.Request) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		http.Error(writer, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events := make(chan string, 4)
+	this.mutex.Lock()
+	this.subscribers[events] = true
+	this.mutex.Unlock()
+	defer func() {
+		this.mutex.Lock()
+		delete(this.subscribers, events)
+		this.mutex.Unlock()
+	}()
+
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-request.Context().Done():
+			return
+		case event := <-events:
+			fmt.Fprintf(writer, "event: %s\ndata: {}\n\n", event)
+			flusher.Flush()
+		}
+	}
+}
+
+// runWeb serves the views of the TUI to a browser, i.e. `ptop web <pid> -listen 127.0.0.1:9780`
+func runWeb(args []string) error {
+	flags := flag.NewFlagSet("ptop web", flag.ContinueOnError)
+	listen := flags.String("listen", DEFAULT_WEB_LISTEN, "address to serve the web UI and the API on, which show the stacks and the mappings of the process without authentication; listening on other interfaces, e.g. :9780, is opt-in")
+	interval := flags.Duration("interval", DEFAULT_WEB_SNAPSHOT_INTERVAL_IN_SECOND * time.Second, "time between two snapshots")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("a pid is required")
+	}
+	parsedPid, err := strconv.ParseInt(positional[0], 10, 32)
+	if err != nil {
+		return usageErrorf("invalid pid [%s]", positional[0])
+	}
+
+	server := NewWebServer(&LiveSource{}, int32(parsedPid))
+	stop := make(chan bool)
+	go server.Run(DEFAULT_PROFILE_INTERVAL_IN_SECOND * time.Second, *interval, stop)
+
+	httpServer := &http.Server{Addr: *listen, Handler: server.Handler()}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		close(stop)
+		httpServer.Close()
+	}()
+
+	fmt.Fprintf(os.Stderr, "serving process %d on http://%s/\n", parsedPid, *listen)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
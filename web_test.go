@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/mcfongtw/go-ptop/correlate"
+	"github.com/mcfongtw/go-ptop/jvmdump"
+	"github.com/mcfongtw/go-ptop/smaps"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// the artifacts of the fake process of the correlate tests
+var webTestThreadDump = filepath.Join("correlate", "testdata", "threaddump.txt")
+
+var webTestSmaps = filepath.Join("correlate", "testdata", "proc", "4242", "smaps")
+
+// newTestWebServer serves a snapshot of the test artifacts, taken after a first one whose [heap] had 20 kB less RSS
+func newTestWebServer(t *testing.T) *httptest.Server {
+	frame, err := LoadFrame(webTestThreadDump, webTestSmaps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := *frame
+	previous.Smaps = strings.Replace(frame.Smaps, "Rss:                  76 kB", "Rss:                  56 kB", 1)
+
+	server := NewWebServer(NewFrameSource(4242, "test", &previous), 4242)
+	if err := server.RefreshSnapshot(); err != nil {
+		t.Fatalf("RefreshSnapshot failed: %s", err)
+	}
+	server.source = NewFrameSource(4242, "test", frame)
+	if err := server.RefreshSnapshot(); err != nil {
+		t.Fatalf("RefreshSnapshot failed: %s", err)
+	}
+	if err := server.RefreshSummary(); err != nil {
+		t.Fatalf("RefreshSummary failed: %s", err)
+	}
+
+	httpServer := httptest.NewServer(server.Handler())
+	t.Cleanup(httpServer.Close)
+	return httpServer
+}
+
+// get fetches path, checks its status and decodes it into value, unless nil or an error was expected
+func get(t *testing.T, server *httptest.Server, path string, status int, value interface{}) {
+	response, err := http.Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s failed: %s", path, err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	if response.StatusCode != status {
+		t.Errorf("GET %s returned %d, expected %d: %s", path, response.StatusCode, status, body)
+		return
+	}
+	if value != nil && status == http.StatusOK {
+		if err := json.Unmarshal(body, value); err != nil {
+			t.Errorf("GET %s returned invalid JSON: %s", path, err)
+		}
+	}
+}
+
+func TestWebThreads(t *testing.T) {
+	server := newTestWebServer(t)
+
+	var listOfThreads []WebThread
+	get(t, server, "/api/threads", http.StatusOK, &listOfThreads)
+	if len(listOfThreads) != 2 {
+		t.Fatalf("threads = %+v, expected main and worker-1", listOfThreads)
+	}
+	var mapOfThreads = make(map[int]WebThread)
+	for _, thread := range listOfThreads {
+		mapOfThreads[thread.Tid] = thread
+	}
+	if main := mapOfThreads[4242]; main.Name != "main" || main.State != "RUNNABLE" || main.StackRss != 64 {
+		t.Errorf("main = %+v", main)
+	}
+	if worker := mapOfThreads[4250]; worker.Name != "worker-1" || worker.Pool != "worker" || worker.State != "TIMED_WAITING" {
+		t.Errorf("worker-1 = %+v", worker)
+	}
+}
+
+func TestWebThreadStack(t *testing.T) {
+	server := newTestWebServer(t)
+
+	var jthread jvmdump.JavaThread
+	get(t, server, "/api/thread/4242/stack", http.StatusOK, &jthread)
+	if jthread.ThreadName != "main" || len(jthread.Frames) != 3 {
+		t.Errorf("stack of main = %+v", jthread)
+	}
+
+	get(t, server, "/api/thread/4243/stack", http.StatusNotFound, nil)
+	get(t, server, "/api/thread/4242/heap", http.StatusNotFound, nil)
+	get(t, server, "/api/thread/main/stack", http.StatusBadRequest, nil)
+}
+
+func TestWebMaps(t *testing.T) {
+	server := newTestWebServer(t)
+
+	var tests = []struct {
+		query    string
+		status   int
+		expected []string
+	}{
+		{"", http.StatusOK, []string{"/usr/lib/jvm/java-17-openjdk/bin/java", "[heap]", "", "", "", "main", "worker-1",
+			"/usr/lib/jvm/java-17-openjdk/lib/server/libjvm.so", "", "[stack]"}},
+		{"?view=mmap", http.StatusOK, []string{"/usr/lib/jvm/java-17-openjdk/bin/java", "/usr/lib/jvm/java-17-openjdk/lib/server/libjvm.so"}},
+		{"?view=others", http.StatusOK, []string{"[heap]", "", "", "", "", "[stack]"}},
+		//executable anonymous, i.e. the code cache
+		{"?filter=1&view=all", http.StatusOK, []string{""}},
+		{"?filter=1&view=mmap", http.StatusOK, nil},
+		{"?filter=&view=", http.StatusOK, []string{"/usr/lib/jvm/java-17-openjdk/bin/java", "[heap]", "", "", "", "main", "worker-1",
+			"/usr/lib/jvm/java-17-openjdk/lib/server/libjvm.so", "", "[stack]"}},
+		{"?filter=99", http.StatusBadRequest, nil},
+		{"?filter=x", http.StatusBadRequest, nil},
+		{"?view=threads", http.StatusBadRequest, nil},
+	}
+
+	for _, test := range tests {
+		var listOfSegments []correlate.TaskMemorySegment
+		get(t, server, "/api/maps"+test.query, test.status, &listOfSegments)
+		if test.status != http.StatusOK {
+			continue
+		}
+
+		var paths []string
+		for _, segment := range listOfSegments {
+			paths = append(paths, segment.Path)
+		}
+		if !reflect.DeepEqual(paths, test.expected) {
+			t.Errorf("/api/maps%s = %q, expected %q", test.query, paths, test.expected)
+		}
+	}
+}
+
+func TestWebGrowth(t *testing.T) {
+	server := newTestWebServer(t)
+
+	var listOfDeltas []smaps.MappingDelta
+	get(t, server, "/api/growth", http.StatusOK, &listOfDeltas)
+	if len(listOfDeltas) != 1 || listOfDeltas[0].Segment.Path != "[heap]" || listOfDeltas[0].Change != smaps.MAPPING_GROWN ||
+		listOfDeltas[0].RssDelta != 20 {
+		t.Errorf("growth = %+v, expected [heap] to grow by 20 kB", listOfDeltas)
+	}
+}
+
+func TestWebNoSnapshot(t *testing.T) {
+	server := httptest.NewServer(NewWebServer(&LiveSource{}, 4242).Handler())
+	defer server.Close()
+
+	for _, path := range []string{"/api/threads", "/api/maps", "/api/thread/4242/stack", "/api/growth"} {
+		get(t, server, path, http.StatusServiceUnavailable, nil)
+	}
+}